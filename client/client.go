@@ -1,42 +1,89 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
-	"log"
-	"net/http"
+	"flag"
+	"fmt"
 	"io"
-	"time"
+	"log"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
 )
 
 func main() {
+	server := flag.String("server", "http://localhost:8081", "address of the weather service")
+	city := flag.String("city", "", "city to fetch the weather for, the service's local city when empty")
+	format := flag.String("format", "text", "output format, text or json")
+	caFile := flag.String("ca", "", "PEM file of a CA to trust, for a service with a self-signed certificate")
+	insecure := flag.Bool("insecure", false, "skip the TLS certificate verification, for local development only")
+	flag.Parse()
+
+	if *format != "text" && *format != "json" {
+		log.Fatalf("Unknown format %q, expected text or json", *format)
+	}
+
 	// Send a request to the weather service for today
 	log.Println("Sending a request to the weather service for today's weather...")
 
-	client := http.Client{Timeout: time.Duration(1) * time.Second}
-	response, err := client.Get("https://localhost:8080/weather")
+	client := NewClient(strings.TrimSuffix(*server, "/"))
+	if *caFile != "" {
+		if err := client.UseCACertificate(*caFile); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	if *insecure {
+		client.SkipTLSVerification()
+	}
+	bodyBytes, err := client.Get(weatherPath(*city))
 
 	if err != nil {
-		log.Fatalf("Error sending request: %v", err)
+		log.Fatalf("%v", err)
 	}
 
-	defer response.Body.Close()
-
-	if response.StatusCode != http.StatusOK {
-		log.Fatalf("Error fetching weather data: status code %d", response.StatusCode)
+	if err := printWeather(os.Stdout, bodyBytes, *format); err != nil {
+		log.Fatalf("Error reading response body: %v", err)
 	}
 
-	bodyBytes, err := io.ReadAll(response.Body)
-	if err != nil {
-		log.Fatalf("Error reading response body: %v", err)
+}
+
+// weatherPath returns the path of the weather of city, or of the local weather when city is empty.
+func weatherPath(city string) string {
+	if city == "" {
+		return "/weather"
 	}
+	return "/weather/" + url.PathEscape(city)
+}
 
-	var jsonResponse []byte
-	err = json.Unmarshal(bodyBytes, &jsonResponse)
+// printWeather writes the weather response body to w, indented for json or one "field: value" line
+// per field, sorted by field, for text.
+func printWeather(w io.Writer, body []byte, format string) error {
+	if format == "json" {
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, body, "", "  "); err != nil {
+			return err
+		}
+		_, err := fmt.Fprintln(w, indented.String())
+		return err
+	}
 
-	if err != nil {
-		log.Fatalf("Error reading response body: %v", err)
+	var fields map[string]any
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return err
 	}
 
-	log.Println("Today's weather is: ", string(jsonResponse))
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	slices.Sort(names)
 
+	for _, name := range names {
+		if _, err := fmt.Fprintf(w, "%s: %v\n", name, fields[name]); err != nil {
+			return err
+		}
+	}
+	return nil
 }
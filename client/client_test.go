@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWeatherPath(t *testing.T) {
+	tests := map[string]string{
+		"":         "/weather",
+		"Tokyo":    "/weather/Tokyo",
+		"New York": "/weather/New%20York",
+	}
+
+	for city, expected := range tests {
+		if got := weatherPath(city); got != expected {
+			t.Errorf("weatherPath(%q) = %q, expected %q", city, got, expected)
+		}
+	}
+}
+
+func TestPrintWeather(t *testing.T) {
+	body := []byte(`{"city":"Tokyo","temperature":"291.5","country":"JP"}`)
+
+	tests := map[string]string{
+		"text": "city: Tokyo\ncountry: JP\ntemperature: 291.5\n",
+		"json": "{\n  \"city\": \"Tokyo\",\n  \"temperature\": \"291.5\",\n  \"country\": \"JP\"\n}\n",
+	}
+
+	for format, expected := range tests {
+		var out bytes.Buffer
+		if err := printWeather(&out, body, format); err != nil {
+			t.Fatalf("format %s: unexpected error %v", format, err)
+		}
+		if out.String() != expected {
+			t.Errorf("format %s: expected %q, got %q", format, expected, out.String())
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Client fetches from the weather service, retrying failed requests with exponential backoff
+// and caching successful responses, like the server does with the upstream API.
+//
+// The zero value is not usable, create one with NewClient and adjust the fields before the first request.
+type Client struct {
+	// Address of the weather service, such as http://localhost:8081
+	BaseURL string
+	// HTTP client used for every attempt, its Timeout bounds each attempt separately
+	HTTPClient *http.Client
+
+	// Number of retries after a failed attempt, 0 disables retrying
+	MaxRetries int
+	// Wait before the first retry, doubled before each following one
+	RetryBackoff time.Duration
+
+	// How long a successful response is served from the cache, 0 disables the cache
+	CacheTTL time.Duration
+
+	mutex sync.Mutex
+	cache map[string]cachedResponse
+
+	// Clock, replaced in tests
+	now func() time.Time
+}
+
+type cachedResponse struct {
+	body    []byte
+	expires time.Time
+}
+
+// NewClient returns a Client for the service at baseURL with a 1 second timeout per attempt,
+// 3 retries starting 100ms apart and a 1 minute cache.
+func NewClient(baseURL string) *Client {
+	return &Client{
+		BaseURL:    baseURL,
+		HTTPClient: &http.Client{Timeout: time.Duration(1) * time.Second},
+
+		MaxRetries:   3,
+		RetryBackoff: 100 * time.Millisecond,
+
+		CacheTTL: time.Minute,
+
+		cache: make(map[string]cachedResponse),
+		now:   time.Now,
+	}
+}
+
+// UseCACertificate makes the client trust the certificates issued by the PEM encoded CA at path,
+// on top of the system ones, for a service behind a private or self-signed certificate.
+func (c *Client) UseCACertificate(path string) error {
+	certificate, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading CA certificate: %v", err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(certificate) {
+		return fmt.Errorf("no PEM certificate found in %s", path)
+	}
+
+	c.tlsConfig().RootCAs = pool
+	return nil
+}
+
+// SkipTLSVerification stops the client from verifying the certificate of the service. It is meant
+// for local development against a self-signed certificate only, as any server can then pose as the
+// service, and logs a warning saying so. Prefer UseCACertificate whenever the certificate is at hand.
+func (c *Client) SkipTLSVerification() {
+	log.Println("WARNING: TLS certificate verification is disabled, the connection to the weather service is insecure")
+	c.tlsConfig().InsecureSkipVerify = true
+}
+
+// tlsConfig returns the TLS configuration of HTTPClient's transport, first giving the client a
+// transport of its own so the change never reaches http.DefaultTransport.
+func (c *Client) tlsConfig() *tls.Config {
+	transport, ok := c.HTTPClient.Transport.(*http.Transport)
+	if !ok || transport == http.DefaultTransport {
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+		c.HTTPClient.Transport = transport
+	}
+
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	return transport.TLSClientConfig
+}
+
+// Get returns the body of a successful GET of path, from the cache when it holds a fresh copy.
+//
+// Network errors, 429 and 5xx responses are retried, other statuses are returned as errors right away.
+//
+// Parameters:
+// path (string): The path of the resource, such as /weather.
+//
+// Return:
+// []byte: The response body.
+// error: The error of the last attempt if none succeeded.
+func (c *Client) Get(path string) ([]byte, error) {
+	if body, ok := c.cached(path); ok {
+		return body, nil
+	}
+
+	backoff := c.RetryBackoff
+
+	var err error
+	for attempt := 0; attempt <= c.MaxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		var body []byte
+		var retry bool
+		body, retry, err = c.get(path)
+		if err == nil {
+			c.store(path, body)
+			return body, nil
+		}
+		if !retry {
+			break
+		}
+	}
+
+	return nil, err
+}
+
+// get makes a single attempt at fetching path and reports whether a failure is worth retrying.
+func (c *Client) get(path string) ([]byte, bool, error) {
+	response, err := c.HTTPClient.Get(c.BaseURL + path)
+	if err != nil {
+		return nil, true, fmt.Errorf("error sending request: %v", err)
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		retry := response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= http.StatusInternalServerError
+		return nil, retry, fmt.Errorf("error fetching weather data: status code %d", response.StatusCode)
+	}
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, true, fmt.Errorf("error reading response body: %v", err)
+	}
+
+	return body, false, nil
+}
+
+func (c *Client) cached(path string) ([]byte, bool) {
+	if c.CacheTTL <= 0 {
+		return nil, false
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.cache[path]
+	if !ok || c.now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.body, true
+}
+
+func (c *Client) store(path string, body []byte) {
+	if c.CacheTTL <= 0 {
+		return
+	}
+
+	c.mutex.Lock()
+	c.cache[path] = cachedResponse{body: body, expires: c.now().Add(c.CacheTTL)}
+	c.mutex.Unlock()
+}
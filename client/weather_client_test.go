@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestClient points a client at a test server using handler, with retries too short to slow the tests down.
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	client := NewClient(server.URL)
+	client.RetryBackoff = time.Millisecond
+
+	return client
+}
+
+func TestClientRetriesServerErrors(t *testing.T) {
+	var calls atomic.Int32
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		fmt.Fprint(w, `{"city":"Sydney"}`)
+	})
+
+	body, err := client.Get("/weather")
+	if err != nil {
+		t.Fatalf("Expected the third attempt to succeed, got %v", err)
+	}
+	if string(body) != `{"city":"Sydney"}` {
+		t.Errorf("Unexpected body %s", body)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("Expected 3 attempts, got %d", calls.Load())
+	}
+}
+
+func TestClientGivesUp(t *testing.T) {
+	tests := []struct {
+		status   int
+		expected int32
+	}{
+		{http.StatusServiceUnavailable, 4},
+		{http.StatusNotFound, 1},
+	}
+
+	for _, tt := range tests {
+		var calls atomic.Int32
+		client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.WriteHeader(tt.status)
+		})
+
+		if _, err := client.Get("/weather"); err == nil {
+			t.Errorf("Status %d: expected an error", tt.status)
+		}
+		if calls.Load() != tt.expected {
+			t.Errorf("Status %d: expected %d attempts, got %d", tt.status, tt.expected, calls.Load())
+		}
+	}
+}
+
+func TestClientCachesResponses(t *testing.T) {
+	var calls atomic.Int32
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"call":%d}`, calls.Add(1))
+	})
+
+	now := time.Now()
+	client.now = func() time.Time { return now }
+
+	for range 2 {
+		if body, _ := client.Get("/weather"); string(body) != `{"call":1}` {
+			t.Errorf("Expected the cached body, got %s", body)
+		}
+	}
+
+	now = now.Add(2 * time.Minute)
+	if body, _ := client.Get("/weather"); string(body) != `{"call":2}` {
+		t.Errorf("Expected a fresh body once the entry expired, got %s", body)
+	}
+}
+
+// newTLSTestClient points a client at a TLS test server, whose certificate is self-signed.
+func newTLSTestClient(t *testing.T) (*Client, *httptest.Server) {
+	t.Helper()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"city":"Sydney"}`)
+	}))
+	t.Cleanup(server.Close)
+
+	client := NewClient(server.URL)
+	client.MaxRetries = 0
+
+	return client, server
+}
+
+func TestClientVerifiesTLSByDefault(t *testing.T) {
+	client, _ := newTLSTestClient(t)
+
+	if _, err := client.Get("/weather"); err == nil {
+		t.Error("Expected the self-signed certificate to be rejected")
+	}
+}
+
+func TestClientSkipTLSVerification(t *testing.T) {
+	client, _ := newTLSTestClient(t)
+	client.SkipTLSVerification()
+
+	if _, err := client.Get("/weather"); err != nil {
+		t.Errorf("Expected the request to succeed without verification, got %v", err)
+	}
+	if config := http.DefaultTransport.(*http.Transport).TLSClientConfig; config != nil && config.InsecureSkipVerify {
+		t.Error("Expected the default transport to be left untouched")
+	}
+}
+
+func TestClientUseCACertificate(t *testing.T) {
+	client, server := newTLSTestClient(t)
+
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(path, certificate, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.UseCACertificate(path); err != nil {
+		t.Fatalf("Error loading the CA certificate: %v", err)
+	}
+	if _, err := client.Get("/weather"); err != nil {
+		t.Errorf("Expected the certificate to be trusted, got %v", err)
+	}
+
+	if err := client.UseCACertificate(filepath.Join(t.TempDir(), "missing.pem")); err == nil {
+		t.Error("Expected an error for a missing CA file")
+	}
+}
@@ -0,0 +1,90 @@
+package weather
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// adminAuthMiddleware rejects the requests that do not carry token as their bearer token with an
+// HTTP 401 status code.
+func adminAuthMiddleware(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		given, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Admin token required"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+type timeoutRequest struct {
+	// A Go duration such as "800ms" or "5s"
+	Timeout string `json:"timeout"`
+}
+
+// putClientTimeout handles PUT /admin/config/timeout, replacing the upstream client timeout without a restart.
+//
+// Parameters:
+// ctx (gin.Context): The Gin context containing request and response objects. The body is a JSON
+// object of the form {"timeout": "5s"}, between 50ms and 10s.
+//
+// Return:
+// None. The function responds with the new timeout, or an HTTP 400 status code for an invalid one.
+func putClientTimeout(ctx *gin.Context) {
+
+	var request timeoutRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid timeout request body"})
+		return
+	}
+
+	timeout, err := time.ParseDuration(request.Timeout)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "timeout must be a duration such as 800ms or 5s"})
+		return
+	}
+
+	if err := SetRequestTimeout(timeout); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	logger.Info("Upstream client timeout updated", "timeout", timeout)
+
+	ctx.JSON(http.StatusOK, gin.H{"timeout": upstreamClientTimeout().String()})
+
+}
+
+// getCacheSummary handles GET /admin/cache, describing each entry of the current weather cache by
+// its city, age and remaining TTL, to diagnose why a client sees stale or missing data.
+// An expired entry, kept for the stale fallback, has no TTL remaining.
+//
+// Parameters:
+// ctx (gin.Context): The Gin context containing request and response objects.
+//
+// Return:
+// None. The function responds with the entries sorted by city.
+func getCacheSummary(ctx *gin.Context) {
+
+	infos := currentWeatherCache.Entries()
+
+	entries := make([]gin.H, 0, len(infos))
+	for _, info := range infos {
+		entries = append(entries, gin.H{
+			"city":                  info.Key,
+			"age_seconds":           int64(info.Age.Seconds()),
+			"ttl_remaining_seconds": int64(info.TTLRemaining.Seconds()),
+			"expired":               info.TTLRemaining == 0,
+			"compact":               info.Compact,
+		})
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"count": len(entries), "entries": entries})
+
+}
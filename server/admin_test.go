@@ -0,0 +1,169 @@
+package weather
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func newAdminRouter() *gin.Engine {
+	router := gin.New()
+	admin := router.Group("/admin", adminAuthMiddleware("s3cret"))
+	admin.PUT("/config/timeout", putClientTimeout)
+	admin.GET("/cache", getCacheSummary)
+	return router
+}
+
+func putTimeout(router *gin.Engine, token, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPut, "/admin/config/timeout", strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	router.ServeHTTP(w, request)
+	return w
+}
+
+func TestPutClientTimeoutRequiresAdminToken(t *testing.T) {
+	t.Cleanup(func() { upstreamTimeout.Store(0) })
+	router := newAdminRouter()
+
+	for _, token := range []string{"", "wrong"} {
+		if w := putTimeout(router, token, `{"timeout":"5s"}`); w.Code != http.StatusUnauthorized {
+			t.Errorf("Token %q: expected status %d, got %d", token, http.StatusUnauthorized, w.Code)
+		}
+	}
+
+	if timeout := upstreamClientTimeout(); timeout != config.RequestTimeout {
+		t.Errorf("Expected the timeout to stay %s, got %s", config.RequestTimeout, timeout)
+	}
+}
+
+func TestPutClientTimeoutValidatesRange(t *testing.T) {
+	t.Cleanup(func() { upstreamTimeout.Store(0) })
+	router := newAdminRouter()
+
+	for _, body := range []string{`{"timeout":"10ms"}`, `{"timeout":"1m"}`, `{"timeout":"soon"}`, `{}`, `not json`} {
+		if w := putTimeout(router, "s3cret", body); w.Code != http.StatusBadRequest {
+			t.Errorf("Body %s: expected status %d, got %d", body, http.StatusBadRequest, w.Code)
+		}
+	}
+
+	if timeout := upstreamClientTimeout(); timeout != config.RequestTimeout {
+		t.Errorf("Expected the timeout to stay %s, got %s", config.RequestTimeout, timeout)
+	}
+}
+
+func TestPutClientTimeoutAppliesToNextRequests(t *testing.T) {
+	t.Cleanup(func() { upstreamTimeout.Store(0) })
+	router := newAdminRouter()
+
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+		serveCannedWeather(w, r)
+	})
+
+	if _, err := sendWeatherRequest("Tokyo", unitsStandard); err == nil {
+		t.Fatal("Expected the slow upstream to time out with the default timeout")
+	}
+
+	w := putTimeout(router, "s3cret", `{"timeout":"1s"}`)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"timeout":"1s"`) {
+		t.Fatalf("Expected the new timeout in a 200 response, got %d %s", w.Code, w.Body.String())
+	}
+
+	if _, err := sendWeatherRequest("Tokyo", unitsStandard); err != nil {
+		t.Errorf("Expected the slow upstream to answer within the new timeout, got %v", err)
+	}
+}
+
+func TestGetCacheSummary(t *testing.T) {
+	withCache(t, time.Minute, false)
+	router := newAdminRouter()
+
+	now := time.Now()
+	currentWeatherCache.now = func() time.Time { return now }
+	currentWeatherCache.Set("tokyo", sampleWeatherData("Tokyo"))
+	now = now.Add(20 * time.Second)
+	currentWeatherCache.Set("london", sampleWeatherData("London"))
+	now = now.Add(50 * time.Second)
+
+	request := httptest.NewRequest(http.MethodGet, "/admin/cache", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, request)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d without the admin token, got %d", http.StatusUnauthorized, w.Code)
+	}
+
+	request.Header.Set("Authorization", "Bearer s3cret")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, request)
+
+	var summary struct {
+		Count   int `json:"count"`
+		Entries []struct {
+			City         string `json:"city"`
+			Age          int    `json:"age_seconds"`
+			TTLRemaining int    `json:"ttl_remaining_seconds"`
+			Expired      bool   `json:"expired"`
+		} `json:"entries"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &summary); err != nil {
+		t.Fatalf("Error unmarshalling response: %v", err)
+	}
+
+	if summary.Count != 2 || len(summary.Entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %s", w.Body.String())
+	}
+
+	london, tokyo := summary.Entries[0], summary.Entries[1]
+	if london.City != "london" || london.Age != 50 || london.TTLRemaining != 10 || london.Expired {
+		t.Errorf("Expected london stored 50s ago with 10s left, got %+v", london)
+	}
+	if tokyo.City != "tokyo" || tokyo.Age != 70 || tokyo.TTLRemaining != 0 || !tokyo.Expired {
+		t.Errorf("Expected tokyo stored 70s ago and expired, got %+v", tokyo)
+	}
+}
+
+func TestAdminRoutesRequireAdminToken(t *testing.T) {
+	previous := config
+	t.Cleanup(func() { config = previous })
+	config.StatsEnabled = true
+
+	get := func(path, token string) int {
+		router := gin.New()
+		registerRoutes(router, prometheus.NewRegistry())
+
+		w := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		request.Header.Set("Authorization", "Bearer "+token)
+		router.ServeHTTP(w, request)
+		return w.Code
+	}
+
+	for _, path := range []string{"/admin/stats", "/admin/circuit"} {
+		config.AdminToken = ""
+		if code := get(path, ""); code != http.StatusNotFound {
+			t.Errorf("Expected %s not to be served without an admin token configured, got %d", path, code)
+		}
+
+		config.AdminToken = "s3cret"
+		if code := get(path, "guess"); code != http.StatusUnauthorized {
+			t.Errorf("%s: expected status code %d with a wrong token, got %d", path, http.StatusUnauthorized, code)
+		}
+		if code := get(path, "s3cret"); code != http.StatusOK {
+			t.Errorf("%s: expected status code %d with the admin token, got %d", path, http.StatusOK, code)
+		}
+	}
+
+	if code := get("/circuit", "s3cret"); code != http.StatusNotFound {
+		t.Errorf("Expected /circuit not to be served outside the admin group, got %d", code)
+	}
+}
@@ -0,0 +1,127 @@
+package weather
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Airport is an entry of the embedded airport dataset.
+type Airport struct {
+	IATA    string  `json:"iata"`
+	ICAO    string  `json:"icao"`
+	Name    string  `json:"name"`
+	City    string  `json:"city"`
+	Country string  `json:"country"`
+	Lat     float64 `json:"lat"`
+	Lon     float64 `json:"lon"`
+}
+
+//go:embed airports.json
+var airportsJSON []byte
+
+// airports indexes the embedded dataset by both its 3 letter IATA and 4 letter ICAO codes.
+var airports = loadAirports(airportsJSON)
+
+func loadAirports(data []byte) map[string]Airport {
+	var list []Airport
+	if err := json.Unmarshal(data, &list); err != nil {
+		panic("invalid embedded airport dataset: " + err.Error())
+	}
+
+	index := make(map[string]Airport, 2*len(list))
+	for _, airport := range list {
+		index[airport.IATA] = airport
+		index[airport.ICAO] = airport
+	}
+
+	return index
+}
+
+// lookupAirport resolves an IATA or ICAO code, ignoring case and surrounding spaces.
+func lookupAirport(code string) (Airport, bool) {
+	airport, ok := airports[strings.ToUpper(strings.TrimSpace(code))]
+	return airport, ok
+}
+
+// getWeatherAirport retrieves the current weather at the airport given by its IATA or ICAO code in the path.
+//
+// Parameters:
+// ctx (gin.Context): The Gin context containing request and response objects. The code is extracted from the "code" parameter.
+// The optional "display" and "units" query parameters select the temperature scale and the units the upstream is queried in,
+// as for /weather/:location.
+//
+// Return:
+// None. The function responds with the weather data and the resolved airport as JSON.
+// If the code is unknown an HTTP 404 status code is returned, and an HTTP 500 status code if the fetch fails.
+func getWeatherAirport(ctx *gin.Context) {
+
+	code := ctx.Param("code")
+
+	units := parseUnits(ctx)
+
+	display, err := parseTemperatureDisplay(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	airport, ok := lookupAirport(code)
+	if !ok {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Unknown airport code"})
+		return
+	}
+
+	weatherData, err := instrumentedSendWeatherRequestByCoordinates(ctx.Request.Context(), Coordinates{Latitude: airport.Lat, Longitude: airport.Lon}, units)
+	if err != nil {
+		logger.Error("Error fetching airport weather data", "code", code, "error", err)
+		ctx.JSON(http.StatusInternalServerError, failedFetchResponse("Failed to fetch weather data", err))
+		return
+	}
+
+	if notModifiedSince(ctx, weatherData) {
+		ctx.AbortWithStatus(http.StatusNotModified)
+		return
+	}
+
+	if isStaleObservation(weatherData, time.Now()) {
+		ctx.Header("Warning", staleObservationWarning)
+	}
+
+	response := weatherResponse(weatherData, display)
+	response["airport"] = gin.H{
+		"iata": airport.IATA,
+		"icao": airport.ICAO,
+		"name": airport.Name,
+	}
+
+	ctx.JSON(http.StatusOK, response)
+
+}
+
+func instrumentedGetWeatherAirport(ctx *gin.Context) {
+	traceCtx, span := tracer.Start(ctx.Request.Context(), "getWeatherAirport")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("code", ctx.Param("code")),
+		attribute.String("method", ctx.Request.Method),
+		attribute.String("path", ctx.Request.URL.Path),
+	)
+
+	start := time.Now()
+	weatherRequestCounter.Add(traceCtx, 1,
+		metric.WithAttributes(attribute.Key("endpoint").String("getWeatherAirport")))
+	getWeatherAirport(ctx)
+	duration := time.Since(start).Seconds()
+	weatherRequestDuration.Record(traceCtx, duration,
+		metric.WithAttributes(attribute.Key("endpoint").String("getWeatherAirport")))
+
+	span.SetAttributes(attribute.Int("http.status_code", ctx.Writer.Status()))
+}
@@ -0,0 +1,82 @@
+package weather
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLookupAirport(t *testing.T) {
+	tests := []struct {
+		code string
+		iata string
+		ok   bool
+	}{
+		{"LHR", "LHR", true},
+		{"EGLL", "LHR", true},
+		{"sfo", "SFO", true},
+		{" ksfo ", "SFO", true},
+		{"XXX", "", false},
+		{"", "", false},
+	}
+
+	for _, test := range tests {
+		airport, ok := lookupAirport(test.code)
+		if ok != test.ok || airport.IATA != test.iata {
+			t.Errorf("lookupAirport(%q) = %q, %v, expected %q, %v", test.code, airport.IATA, ok, test.iata, test.ok)
+		}
+	}
+}
+
+func TestGetWeatherAirport(t *testing.T) {
+	var query string
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		fmt.Fprintf(w, `{"name":"Hounslow","sys":{"country":"GB"},"main":{"temp":12.5},"dt":%d}`, time.Now().Unix())
+	})
+
+	router := gin.New()
+	router.GET("/weather/:location", getWeatherInternational)
+	router.GET("/weather/airport/:code", getWeatherAirport)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/weather/airport/egll", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	if !strings.HasPrefix(query, "lat=51.4706&lon=-0.4619") {
+		t.Errorf("Expected the upstream to be queried by coordinates, got %q", query)
+	}
+
+	var response struct {
+		City    string            `json:"city"`
+		Airport map[string]string `json:"airport"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Error unmarshalling response: %v", err)
+	}
+
+	if response.City != "Hounslow" || response.Airport["iata"] != "LHR" || response.Airport["icao"] != "EGLL" {
+		t.Errorf("Unexpected response: %s", w.Body.String())
+	}
+}
+
+func TestGetWeatherAirportUnknownCode(t *testing.T) {
+	router := gin.New()
+	router.GET("/weather/airport/:code", getWeatherAirport)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/weather/airport/ZZZZ", nil))
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
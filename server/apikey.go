@@ -0,0 +1,101 @@
+package weather
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// providerOpenWeather is the name of the OpenWeatherMap provider, used to look up its API key.
+const providerOpenWeather = "openweather"
+
+// Files the API keys are read from, relative to the working directory and replaced in tests.
+var (
+	// One provider=key pair per line, blank lines and lines starting with # are ignored
+	apiKeysFile = "./api.keys"
+	// Legacy single key file, holding the OpenWeatherMap key only
+	apiKeyFile = "./api.key"
+)
+
+// openWeatherKeyVariable is the environment variable commonly used for the OpenWeatherMap key,
+// accepted alongside OPENWEATHER_API_KEY for deployments injecting secrets under that name.
+const openWeatherKeyVariable = "OWM_API_KEY"
+
+// apiKeyFor returns the API key of a weather provider.
+//
+// The key is looked up, in order, in the <PROVIDER>_API_KEY environment variable (e.g. OPENWEATHER_API_KEY),
+// for OpenWeatherMap in OWM_API_KEY, in the keys file and, for OpenWeatherMap only, in the legacy api.key file.
+//
+// Parameters:
+// provider (string): The provider name, e.g. "openweather".
+//
+// Return:
+// string: The API key.
+// error: An error naming the sources tried if no key is configured for the provider, or if a key file cannot be read.
+func apiKeyFor(provider string) (string, error) {
+	provider = strings.ToLower(strings.TrimSpace(provider))
+
+	variables := []string{strings.ToUpper(provider) + "_API_KEY"}
+	if provider == providerOpenWeather {
+		variables = append(variables, openWeatherKeyVariable)
+	}
+
+	for _, variable := range variables {
+		if key := strings.TrimSpace(os.Getenv(variable)); key != "" {
+			return key, nil
+		}
+	}
+
+	key, err := readKeysFile(apiKeysFile, provider)
+	if err != nil {
+		return "", err
+	}
+	if key != "" {
+		return key, nil
+	}
+
+	sources := append(variables, apiKeysFile)
+
+	if provider == providerOpenWeather {
+		file, err := os.ReadFile(apiKeyFile)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+		if key := strings.TrimSpace(string(file)); key != "" {
+			return key, nil
+		}
+		sources = append(sources, apiKeyFile)
+	}
+
+	return "", fmt.Errorf("no API key configured for provider %q, tried %s", provider, strings.Join(sources, ", "))
+}
+
+// readKeysFile returns the key of provider in the keys file at path, or an empty string if the file
+// does not exist or has no entry for the provider.
+func readKeysFile(path string, provider string) (string, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, key, ok := strings.Cut(line, "=")
+		if ok && strings.ToLower(strings.TrimSpace(name)) == provider {
+			return strings.TrimSpace(key), nil
+		}
+	}
+
+	return "", scanner.Err()
+}
@@ -0,0 +1,104 @@
+package weather
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// withKeyFiles points the key files at a temporary directory for the duration of the test.
+func withKeyFiles(t *testing.T, keys string, legacyKey string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	previousKeys, previousKey := apiKeysFile, apiKeyFile
+	apiKeysFile = filepath.Join(dir, "api.keys")
+	apiKeyFile = filepath.Join(dir, "api.key")
+	t.Cleanup(func() { apiKeysFile, apiKeyFile = previousKeys, previousKey })
+
+	if keys != "" {
+		if err := os.WriteFile(apiKeysFile, []byte(keys), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if legacyKey != "" {
+		if err := os.WriteFile(apiKeyFile, []byte(legacyKey), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestApiKeyForPrefersEnvironment(t *testing.T) {
+	withKeyFiles(t, "openweather=from-file\n", "legacy")
+	t.Setenv("OPENWEATHER_API_KEY", "from-env")
+
+	key, err := apiKeyFor("openweather")
+	if err != nil || key != "from-env" {
+		t.Errorf("Expected the environment key, got %q, %v", key, err)
+	}
+}
+
+func TestParseApiKeyPrefersOwmVariable(t *testing.T) {
+	withKeyFiles(t, "", "from-file")
+	t.Setenv("OPENWEATHER_API_KEY", "")
+	t.Setenv("OWM_API_KEY", "  from-env\n")
+
+	key, err := parseApiKey()
+	if err != nil || key != "from-env" {
+		t.Errorf("Expected the trimmed OWM_API_KEY, got %q, %v", key, err)
+	}
+
+	t.Setenv("OWM_API_KEY", "")
+
+	key, err = parseApiKey()
+	if err != nil || key != "from-file" {
+		t.Errorf("Expected the api.key file once OWM_API_KEY is empty, got %q, %v", key, err)
+	}
+}
+
+func TestApiKeyForKeysFile(t *testing.T) {
+	withKeyFiles(t, "# keys\n\nopenweather = ow-key\nweatherapi=wa-key\n", "legacy")
+
+	tests := map[string]string{
+		"openweather": "ow-key",
+		"WeatherAPI":  "wa-key",
+	}
+
+	for provider, want := range tests {
+		key, err := apiKeyFor(provider)
+		if err != nil || key != want {
+			t.Errorf("apiKeyFor(%q) = %q, %v, expected %q", provider, key, err, want)
+		}
+	}
+}
+
+func TestApiKeyForLegacyFile(t *testing.T) {
+	withKeyFiles(t, "", "legacy\n")
+
+	key, err := apiKeyFor(providerOpenWeather)
+	if err != nil || key != "legacy" {
+		t.Errorf("Expected the legacy key, got %q, %v", key, err)
+	}
+
+	if _, err := apiKeyFor("weatherapi"); err == nil {
+		t.Error("Expected an error for a provider without a key")
+	}
+}
+
+func TestApiKeyForNamesSourcesTried(t *testing.T) {
+	withKeyFiles(t, "", "")
+	t.Setenv("OPENWEATHER_API_KEY", "")
+	t.Setenv("OWM_API_KEY", "")
+
+	_, err := apiKeyFor(providerOpenWeather)
+	if err == nil {
+		t.Fatal("Expected an error without any key")
+	}
+
+	for _, source := range []string{"OPENWEATHER_API_KEY", "OWM_API_KEY", apiKeysFile, apiKeyFile} {
+		if !strings.Contains(err.Error(), source) {
+			t.Errorf("Expected the error to name %s, got %v", source, err)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package weather
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// A reference new moon, 2000-01-06 18:14 UTC, and the mean length of a lunation in days.
+var referenceNewMoon = time.Date(2000, time.January, 6, 18, 14, 0, 0, time.UTC)
+
+const synodicMonthDays = 29.530588853
+
+// moonPhases names the eighths of a lunation, starting at the new moon.
+var moonPhases = []string{
+	"new moon",
+	"waxing crescent",
+	"first quarter",
+	"waxing gibbous",
+	"full moon",
+	"waning gibbous",
+	"last quarter",
+	"waning crescent",
+}
+
+// moonAge returns how far into the current lunation t falls, as a fraction in [0, 1) where 0 is the
+// new moon and 0.5 the full moon.
+//
+// It counts mean lunations from referenceNewMoon, so it can be off from the true phase by up to
+// about half a day, close enough to name the phase.
+func moonAge(t time.Time) float64 {
+	days := t.Sub(referenceNewMoon).Hours() / 24
+	age := math.Mod(days/synodicMonthDays, 1)
+	if age < 0 {
+		age++
+	}
+	return age
+}
+
+// moonPhase names the phase of the moon at t, the eighth of the lunation it is closest to.
+func moonPhase(t time.Time) string {
+	index := int(math.Round(moonAge(t)*8)) % len(moonPhases)
+	return moonPhases[index]
+}
+
+// daylightHours returns the time between sunrise and sunset in hours, rounded to the minute.
+func daylightHours(weatherData WeatherData) float64 {
+	seconds := weatherData.Sys.Sunset - weatherData.Sys.Sunrise
+	return math.Round(float64(seconds)/60) / 60
+}
+
+// astroResponse builds the JSON body returned by /astro/:location. The moon phase is computed for the
+// observation time, or the current time when it is missing. The sun fields are null when the
+// upstream gives no sunrise or sunset, during the polar day and night.
+func astroResponse(weatherData WeatherData) gin.H {
+	observed := time.Now()
+	if weatherData.Dt != 0 {
+		observed = time.Unix(int64(weatherData.Dt), 0)
+	}
+
+	response := gin.H{
+		"city":           weatherData.Name,
+		"country":        weatherData.Sys.Country,
+		"sunrise":        nil,
+		"sunset":         nil,
+		"daylight_hours": nil,
+		"moon_phase":     moonPhase(observed),
+	}
+
+	if weatherData.hasSunTimes() {
+		response["sunrise"] = weatherData.Sys.Sunrise
+		response["sunset"] = weatherData.Sys.Sunset
+		response["daylight_hours"] = daylightHours(weatherData)
+	}
+
+	return response
+}
+
+// getAstro retrieves the sunrise, sunset, daylight duration and moon phase for the location given in the path.
+//
+// Parameters:
+// ctx (gin.Context): The Gin context containing request and response objects. The location is extracted from the "location" parameter.
+//
+// Return:
+// None. The function responds with the astronomical data as JSON, an HTTP 404 status code for an unknown
+// location, or an HTTP 500 status code if the fetch fails.
+func getAstro(ctx *gin.Context) {
+
+	city := ctx.Param("location")
+
+	weatherData, err := fetchWeatherSharedContext(ctx.Request.Context(), city, defaultUnits, 0)
+	if errors.Is(err, ErrCityNotFound) {
+		ctx.JSON(http.StatusNotFound, cityNotFoundResponse(city))
+		return
+	}
+	if err != nil {
+		logger.Error("Error fetching weather data", "city", city, "error", err)
+		ctx.JSON(http.StatusInternalServerError, failedFetchResponse("Failed to fetch weather data", err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, astroResponse(weatherData))
+
+}
+
+func instrumentedGetAstro(ctx *gin.Context) {
+	traceCtx, span := tracer.Start(ctx.Request.Context(), "getAstro")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("location", ctx.Param("location")),
+		attribute.String("method", ctx.Request.Method),
+		attribute.String("path", ctx.Request.URL.Path),
+	)
+
+	start := time.Now()
+	weatherRequestCounter.Add(traceCtx, 1,
+		metric.WithAttributes(attribute.Key("endpoint").String("getAstro")))
+	getAstro(ctx)
+	duration := time.Since(start).Seconds()
+	weatherRequestDuration.Record(traceCtx, duration,
+		metric.WithAttributes(attribute.Key("endpoint").String("getAstro")))
+
+	span.SetAttributes(attribute.Int("http.status_code", ctx.Writer.Status()))
+}
@@ -0,0 +1,101 @@
+package weather
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMoonPhaseKnownDates(t *testing.T) {
+	tests := []struct {
+		date time.Time
+		want string
+	}{
+		// Total solar eclipse over North America
+		{time.Date(2024, time.April, 8, 18, 21, 0, 0, time.UTC), "new moon"},
+		{time.Date(2024, time.April, 15, 19, 13, 0, 0, time.UTC), "first quarter"},
+		{time.Date(2024, time.April, 23, 23, 49, 0, 0, time.UTC), "full moon"},
+		{time.Date(2024, time.May, 1, 11, 27, 0, 0, time.UTC), "last quarter"},
+		{time.Date(2024, time.April, 12, 0, 0, 0, 0, time.UTC), "waxing crescent"},
+		{time.Date(2024, time.April, 27, 0, 0, 0, 0, time.UTC), "waning gibbous"},
+		// Eclipses on either side of the reference new moon
+		{time.Date(1999, time.August, 11, 11, 3, 0, 0, time.UTC), "new moon"},
+		{time.Date(2000, time.January, 21, 4, 44, 0, 0, time.UTC), "full moon"},
+		{time.Date(2017, time.August, 21, 18, 25, 0, 0, time.UTC), "new moon"},
+		{time.Date(2022, time.November, 8, 10, 59, 0, 0, time.UTC), "full moon"},
+	}
+
+	for _, tt := range tests {
+		if got := moonPhase(tt.date); got != tt.want {
+			t.Errorf("moonPhase(%s) = %q, want %q", tt.date.Format(time.DateTime), got, tt.want)
+		}
+	}
+}
+
+func TestDaylightHours(t *testing.T) {
+	// London on the 2024 summer solstice, 04:43 to 21:21 BST
+	sunrise := time.Date(2024, time.June, 20, 3, 43, 0, 0, time.UTC)
+	sunset := time.Date(2024, time.June, 20, 20, 21, 30, 0, time.UTC)
+
+	data := WeatherData{Sys: Sys{Sunrise: int(sunrise.Unix()), Sunset: int(sunset.Unix())}}
+
+	// 16h38m30s, rounded to 16h39m
+	if got := daylightHours(data); got != 16.65 {
+		t.Errorf("Expected 16.65 daylight hours, got %v", got)
+	}
+}
+
+func TestAstroResponse(t *testing.T) {
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"name": "Sydney", "dt": 1713916140, "main": {"temp": 290},
+			"sys": {"country": "AU", "sunrise": 1713903300, "sunset": 1713942600}}`)
+	})
+
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Params = []gin.Param{{Key: "location", Value: "Sydney"}}
+	ctx.Request, _ = http.NewRequest(http.MethodGet, "/astro/Sydney", nil)
+
+	instrumentedGetAstro(ctx)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %v", w.Code)
+	}
+
+	var data struct {
+		Sunrise       int64   `json:"sunrise"`
+		Sunset        int64   `json:"sunset"`
+		DaylightHours float64 `json:"daylight_hours"`
+		MoonPhase     string  `json:"moon_phase"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+		t.Fatalf("Error unmarshalling JSON response: %v", err)
+	}
+
+	if data.Sunrise != 1713903300 || data.Sunset != 1713942600 {
+		t.Errorf("Unexpected sun times %d and %d", data.Sunrise, data.Sunset)
+	}
+	if data.DaylightHours != 10.916666666666666 {
+		t.Errorf("Expected 10h55 of daylight, got %v hours", data.DaylightHours)
+	}
+	// Observed on 2024-04-23, the full moon
+	if data.MoonPhase != "full moon" {
+		t.Errorf("Expected a full moon, got %q", data.MoonPhase)
+	}
+}
+
+func TestAstroResponseWithoutSunTimes(t *testing.T) {
+	response := astroResponse(WeatherData{Name: "Longyearbyen", Dt: 1713916140})
+
+	if response["sunrise"] != nil || response["sunset"] != nil || response["daylight_hours"] != nil {
+		t.Errorf("Expected null sun fields during the polar day, got %v", response)
+	}
+	if response["moon_phase"] != "full moon" {
+		t.Errorf("Expected a full moon, got %v", response["moon_phase"])
+	}
+}
@@ -0,0 +1,526 @@
+package weather
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// maxBatchCities bounds the number of cities a single batch request may ask for.
+const maxBatchCities = 100
+
+// Bounds of the per-city timeout overrides of a batch request.
+const (
+	minBatchTimeout = 50 * time.Millisecond
+	maxBatchTimeout = 10 * time.Second
+)
+
+type batchRequest struct {
+	Cities []string `json:"cities"`
+
+	// Optional upstream timeout in milliseconds for some of the cities, keyed by city as spelled in Cities
+	TimeoutsMs map[string]int `json:"timeouts_ms"`
+}
+
+// timeouts validates the per-city timeout overrides and returns them as durations.
+func (r batchRequest) timeouts() (map[string]time.Duration, error) {
+	timeouts := make(map[string]time.Duration, len(r.TimeoutsMs))
+
+	for city, ms := range r.TimeoutsMs {
+		if !slices.Contains(r.Cities, city) {
+			return nil, fmt.Errorf("timeout given for %q, which is not in cities", city)
+		}
+
+		timeout := time.Duration(ms) * time.Millisecond
+		if timeout < minBatchTimeout || timeout > maxBatchTimeout {
+			return nil, fmt.Errorf("timeout for %q must be between %d and %d ms", city,
+				minBatchTimeout.Milliseconds(), maxBatchTimeout.Milliseconds())
+		}
+		timeouts[city] = timeout
+	}
+
+	return timeouts, nil
+}
+
+// ErrBatchBudgetExceeded is the error of the cities still being fetched when the time budget of
+// their batch ran out, see WeatherConfig.BatchBudget.
+var ErrBatchBudgetExceeded = errors.New("batch time budget exceeded")
+
+type batchResult struct {
+	index    int
+	location string
+	data     WeatherData
+	err      error
+}
+
+// batchStrategy fetches the weather for every city and returns one result per city, in request order.
+// A city found in timeouts is fetched with that upstream timeout instead of the default one.
+// Once ctx is done, it stops waiting and the cities not fetched yet fail with ErrBatchBudgetExceeded.
+type batchStrategy func(ctx context.Context, cities []string, units string, timeouts map[string]time.Duration) []batchResult
+
+/*
+batchStrategies are the concurrency strategies the batch endpoint can run with, selected
+through WeatherConfig.BatchStrategy.
+
+The stress endpoints explore four ways of fanning out the upstream fetches. Only the two
+that need no polling are offered for production traffic:
+
+- "channel" (default): CSP fan-in, each goroutine sends its result on a buffered channel
+and the handler consumes results as soon as they arrive. There is no shared lock and no
+busy-waiting, and a slow city never delays collecting the others.
+- "waitgroup": barrier, each goroutine writes its own slot and the handler waits for all
+of them. Simple, but the whole batch is held back by the slowest city.
+
+The SharedQueue strategies (stress2 and stress3) spin on the queue length while waiting,
+and FastPush busy-waits until the consumer drains the queue, so they stay behind the
+stress endpoints.
+*/
+var batchStrategies = map[string]batchStrategy{
+	"channel":   fetchBatchChannel,
+	"waitgroup": fetchBatchWaitGroup,
+}
+
+// batchSorts are the orderings the batch endpoint accepts in its sort query parameter.
+// Each compares two successful results, failed results are always sorted last.
+var batchSorts = map[string]func(a, b batchResult) int{
+	"temp": func(a, b batchResult) int {
+		return cmp.Compare(a.data.Main.Temp, b.data.Main.Temp)
+	},
+	"-temp": func(a, b batchResult) int {
+		return cmp.Compare(b.data.Main.Temp, a.data.Main.Temp)
+	},
+	"city": func(a, b batchResult) int {
+		return cmp.Compare(strings.ToLower(a.data.Name), strings.ToLower(b.data.Name))
+	},
+}
+
+// Ways the batch endpoint reports the cities it failed to fetch, selected with its on_error query parameter.
+const (
+	// Leave the city out of the results
+	onErrorOmit = "omit"
+	// Report the city with an error field in place of its weather (default)
+	onErrorField = "error"
+	// Report a null in place of the city
+	onErrorNull = "null"
+)
+
+var batchErrorModes = []string{onErrorOmit, onErrorField, onErrorNull}
+
+// sortBatchResults orders results in place with the named sort, keeping request order between equal results.
+func sortBatchResults(results []batchResult, sort string) {
+	compare := batchSorts[sort]
+
+	slices.SortStableFunc(results, func(a, b batchResult) int {
+		switch {
+		case a.err != nil && b.err != nil:
+			return 0
+		case a.err != nil:
+			return 1
+		case b.err != nil:
+			return -1
+		}
+		return compare(a, b)
+	})
+}
+
+// batchPage reads the offset and limit query parameters selecting the page of results returned.
+// A missing offset starts at the first result and a missing limit returns every remaining result.
+func batchPage(ctx *gin.Context) (offset int, limit int, err error) {
+	limit = maxBatchCities
+
+	if value := ctx.Query("offset"); value != "" {
+		offset, err = strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("offset must be a non negative integer")
+		}
+	}
+
+	if value := ctx.Query("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			return 0, 0, fmt.Errorf("limit must be a positive integer")
+		}
+	}
+
+	return offset, min(limit, maxBatchCities), nil
+}
+
+// budgetExceeded fails every city of results that has no result yet with ErrBatchBudgetExceeded.
+func budgetExceeded(results []batchResult, fetched []bool, cities []string) {
+	for i, city := range cities {
+		if !fetched[i] {
+			results[i] = batchResult{index: i, location: city, err: ErrBatchBudgetExceeded}
+		}
+	}
+}
+
+func fetchBatchChannel(ctx context.Context, cities []string, units string, timeouts map[string]time.Duration) []batchResult {
+	results := make([]batchResult, len(cities))
+
+	fanOutBatch(ctx, cities, units, timeouts, func(result batchResult) {
+		results[result.index] = result
+	})
+
+	return results
+}
+
+// fanOutBatch fetches every city concurrently and hands each result to emit as soon as it arrives,
+// in completion order, from the calling goroutine. Once ctx is done, the cities not fetched yet are
+// handed to emit failed with ErrBatchBudgetExceeded, and their upstream calls are cancelled unless
+// another request still waits for them.
+//
+// It is the channel strategy, also used as is to stream the results of a batch, see streamBatch.
+func fanOutBatch(ctx context.Context, cities []string, units string, timeouts map[string]time.Duration, emit func(batchResult)) {
+	// Buffered for every city, so the fetches still running when the budget runs out never block
+	channel := make(chan batchResult, len(cities))
+
+	for i, city := range cities {
+		go func(i int, city string) {
+			data, err := fetchWeatherSharedContext(ctx, city, units, timeouts[city])
+			channel <- batchResult{index: i, location: city, data: data, err: err}
+		}(i, city)
+	}
+
+	fetched := make([]bool, len(cities))
+	for range cities {
+		select {
+		case result := <-channel:
+			fetched[result.index] = true
+			emit(result)
+		case <-ctx.Done():
+			for i, city := range cities {
+				if !fetched[i] {
+					emit(batchResult{index: i, location: city, err: ErrBatchBudgetExceeded})
+				}
+			}
+			return
+		}
+	}
+}
+
+func fetchBatchWaitGroup(ctx context.Context, cities []string, units string, timeouts map[string]time.Duration) []batchResult {
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+
+	results := make([]batchResult, len(cities))
+	fetched := make([]bool, len(cities))
+
+	for i, city := range cities {
+		wg.Add(1)
+		go func(i int, city string) {
+			defer wg.Done()
+			data, err := fetchWeatherSharedContext(ctx, city, units, timeouts[city])
+
+			mutex.Lock()
+			results[i] = batchResult{index: i, location: city, data: data, err: err}
+			fetched[i] = true
+			mutex.Unlock()
+		}(i, city)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	// Barrier: Block until all goroutines are done, or the budget runs out
+	select {
+	case <-done:
+		return results
+	case <-ctx.Done():
+	}
+
+	// The fetches still running keep writing to results, hand out a copy of it
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	partial := slices.Clone(results)
+	budgetExceeded(partial, fetched, cities)
+	return partial
+}
+
+/*
+getWeatherBatch fetches the weather for every city in the request body concurrently
+and returns the results in the same order as the requested cities.
+
+Each city is fetched through the shared flight group, so two batches submitted at the
+same time for overlapping cities only hit the upstream API once per shared city.
+
+Parameters:
+- ctx: The Gin context used to handle the HTTP request and response. The body is a JSON
+object of the form {"cities": ["London", "Paris"]}, optionally with per-city upstream
+timeouts such as "timeouts_ms": {"Paris": 800}, each between 50ms and 10s.
+
+Cities that fail to fetch are reported in place with an error field instead of failing
+the whole batch, or as null or not at all when the on_error query parameter is "null" or
+"omit". The fan-out follows the configured batch strategy.
+
+All the fetches share the time budget of WeatherConfig.BatchBudget. When it runs out, the cities
+fetched so far are returned and the others are reported as failed.
+
+Results are returned in request order, unless the sort query parameter asks for "temp",
+"-temp" or "city", in which case the failed cities come last. The temperatures follow the
+units and display query parameters as on /weather/:location, Celsius by default.
+
+The offset and limit query parameters page through the results once they are all collected
+and sorted, the whole batch is still fetched. The X-Total-Count header reports the number of
+results before paging, omitted cities excluded.
+
+With stream=true, each result is instead written as soon as its city is fetched, as one JSON
+line of an application/x-ndjson response, see streamBatch.
+*/
+func getWeatherBatch(ctx *gin.Context) {
+
+	var request batchRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid batch request body"})
+		return
+	}
+
+	if len(request.Cities) == 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "At least one city is required"})
+		return
+	}
+
+	if len(request.Cities) > maxBatchCities {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d cities are allowed per batch", maxBatchCities)})
+		return
+	}
+
+	timeouts, err := request.timeouts()
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	serveBatch(ctx, request.Cities, timeouts)
+
+}
+
+// serveBatch fetches the weather for cities with the configured batch strategy and responds with
+// the results, sorted, paged and with failures reported as asked by the sort, offset, limit and
+// on_error query parameters. The units and display query parameters are read as for
+// /weather/:location, see parseTemperatureDisplay, and every entry reports the units used.
+// It is shared by the batch and group endpoints, the caller validates cities and timeouts.
+func serveBatch(ctx *gin.Context, cities []string, timeouts map[string]time.Duration) {
+	sort := ctx.Query("sort")
+	if _, ok := batchSorts[sort]; sort != "" && !ok {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "sort must be one of temp, -temp or city"})
+		return
+	}
+
+	offset, limit, err := batchPage(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	onError := ctx.DefaultQuery("on_error", onErrorField)
+	if !slices.Contains(batchErrorModes, onError) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "on_error must be one of omit, error or null"})
+		return
+	}
+
+	stream, err := streamBatchResults(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	units := parseUnits(ctx)
+	display, err := parseTemperatureDisplay(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// A single deadline shared by every fetch, so a few slow cities cannot hold the whole batch
+	batchCtx := ctx.Request.Context()
+	if config.BatchBudget > 0 {
+		var cancel context.CancelFunc
+		batchCtx, cancel = context.WithTimeout(batchCtx, config.BatchBudget)
+		defer cancel()
+	}
+
+	if stream {
+		streamBatch(ctx, batchCtx, cities, units, display, timeouts, onError)
+		return
+	}
+
+	strategy := batchStrategies[config.BatchStrategy]
+	results := strategy(batchCtx, cities, units, timeouts)
+
+	if sort != "" {
+		sortBatchResults(results, sort)
+	}
+
+	batchResponse := make([]gin.H, 0, len(cities))
+
+	logger.Info("Processing batch results", "cities", len(cities), "strategy", config.BatchStrategy)
+	for _, result := range results {
+		if entry, ok := batchEntry(result, units, display, onError); ok {
+			batchResponse = append(batchResponse, entry)
+		}
+	}
+
+	start := min(offset, len(batchResponse))
+	end := min(start+limit, len(batchResponse))
+
+	ctx.Header("X-Total-Count", strconv.Itoa(len(batchResponse)))
+	ctx.JSON(http.StatusOK, batchResponse[start:end])
+}
+
+// batchEntry returns the response entry of one batch result, with its temperature in display and
+// the units it was fetched in, reporting a failed city as asked by onError: with an error field,
+// as a nil entry serialized as null, or not at all, ok being false.
+func batchEntry(result batchResult, units string, display string, onError string) (entry gin.H, ok bool) {
+	if result.err != nil {
+		stressLogger.Error("Weather fetch failed", "city", result.location, "error", result.err)
+
+		switch onError {
+		case onErrorField:
+			return gin.H{
+				"city":  result.location,
+				"error": "Failed to fetch weather data",
+			}, true
+		case onErrorNull:
+			return nil, true
+		}
+		return nil, false
+	}
+
+	entry = gin.H{
+		"city":    result.data.Name,
+		"country": result.data.Sys.Country,
+		"units":   units,
+	}
+	setTemperature(entry, "temperature", result.data.Main.Temp, display)
+
+	return entry, true
+}
+
+// streamBatchResults reads the stream query parameter, false when it is missing. Streamed results
+// come in completion order, so they cannot be sorted or paged.
+func streamBatchResults(ctx *gin.Context) (bool, error) {
+	value := ctx.Query("stream")
+	if value == "" {
+		return false, nil
+	}
+
+	stream, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("stream must be true or false")
+	}
+
+	if stream && (ctx.Query("sort") != "" || ctx.Query("offset") != "" || ctx.Query("limit") != "") {
+		return false, fmt.Errorf("stream cannot be combined with sort, offset or limit")
+	}
+
+	return stream, nil
+}
+
+/*
+streamBatch fetches cities and writes each result as soon as it arrives, as one JSON line of an
+application/x-ndjson response flushed right away, so clients can process a large batch
+incrementally instead of waiting for its slowest city.
+
+The lines come in completion order and carry the same entries as the regular response, failed
+cities being reported as asked by onError. The fetches always fan out as with the channel
+strategy, since the barrier of the waitgroup strategy would hold back every line until the end.
+*/
+func streamBatch(ctx *gin.Context, batchCtx context.Context, cities []string, units string, display string, timeouts map[string]time.Duration, onError string) {
+	ctx.Header("Content-Type", "application/x-ndjson")
+	ctx.Status(http.StatusOK)
+
+	encoder := json.NewEncoder(ctx.Writer)
+
+	logger.Info("Streaming batch results", "cities", len(cities))
+	fanOutBatch(batchCtx, cities, units, timeouts, func(result batchResult) {
+		entry, ok := batchEntry(result, units, display, onError)
+		if !ok {
+			return
+		}
+
+		if err := encoder.Encode(entry); err != nil {
+			logger.Error("Error writing batch result", "city", result.location, "error", err)
+			return
+		}
+		ctx.Writer.Flush()
+	})
+}
+
+/*
+getWeatherGroup fetches the weather for every city of a named group, see WeatherConfig.CityGroups.
+
+Parameters:
+- ctx: The Gin context used to handle the HTTP request and response. The group is extracted
+from the "name" parameter.
+
+The group is served like a batch of its cities, so it accepts the same sort, offset and limit
+query parameters. An HTTP 404 status code is returned for an unknown group.
+*/
+func getWeatherGroup(ctx *gin.Context) {
+	name := ctx.Param("name")
+
+	cities, ok := config.CityGroups[name]
+	if !ok {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Unknown city group %q", name)})
+		return
+	}
+
+	serveBatch(ctx, cities, nil)
+}
+
+func instrumentedGetWeatherBatch(ctx *gin.Context) {
+	traceCtx, span := tracer.Start(ctx.Request.Context(), "getWeatherBatch")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("method", ctx.Request.Method),
+		attribute.String("path", ctx.Request.URL.Path),
+	)
+
+	start := time.Now()
+	weatherRequestCounter.Add(traceCtx, 1,
+		metric.WithAttributes(attribute.Key("endpoint").String("getWeatherBatch")))
+	getWeatherBatch(ctx)
+	duration := time.Since(start).Seconds()
+	weatherRequestDuration.Record(traceCtx, duration,
+		metric.WithAttributes(attribute.Key("endpoint").String("getWeatherBatch")))
+
+	span.SetAttributes(attribute.Int("http.status_code", ctx.Writer.Status()))
+}
+
+func instrumentedGetWeatherGroup(ctx *gin.Context) {
+	traceCtx, span := tracer.Start(ctx.Request.Context(), "getWeatherGroup")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("group", ctx.Param("name")),
+		attribute.String("method", ctx.Request.Method),
+		attribute.String("path", ctx.Request.URL.Path),
+	)
+
+	start := time.Now()
+	weatherRequestCounter.Add(traceCtx, 1,
+		metric.WithAttributes(attribute.Key("endpoint").String("getWeatherGroup")))
+	getWeatherGroup(ctx)
+	duration := time.Since(start).Seconds()
+	weatherRequestDuration.Record(traceCtx, duration,
+		metric.WithAttributes(attribute.Key("endpoint").String("getWeatherGroup")))
+
+	span.SetAttributes(attribute.Int("http.status_code", ctx.Writer.Status()))
+}
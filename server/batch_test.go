@@ -0,0 +1,807 @@
+package weather
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newBatchContext builds a Gin test context carrying a batch request body for the given cities.
+func newBatchContext(t *testing.T, cities []string) (*gin.Context, *httptest.ResponseRecorder) {
+	t.Helper()
+
+	body, err := json.Marshal(batchRequest{Cities: cities})
+	if err != nil {
+		t.Fatalf("Error marshalling batch request: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request, _ = http.NewRequest(http.MethodPost, "/weather/batch", bytes.NewReader(body))
+	ctx.Request.Header.Set("Content-Type", "application/json")
+
+	return ctx, w
+}
+
+func TestWeatherBatchResponse(t *testing.T) {
+	ctx, w := newBatchContext(t, []string{"Tokyo", "London", ""})
+
+	instrumentedGetWeatherBatch(ctx)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var data []map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+		t.Fatalf("Error unmarshalling JSON response: %v", err)
+	}
+
+	if len(data) != 3 {
+		t.Fatalf("Expected 3 results, got %d", len(data))
+	}
+	if data[0]["city"] != "Tokyo" || data[1]["city"] != "London" {
+		t.Errorf("Results are not in request order: %v", data)
+	}
+	if data[2]["error"] == "" {
+		t.Errorf("Expected an error entry for the empty city, got %v", data[2])
+	}
+}
+
+func TestWeatherBatchRejectsEmptyBody(t *testing.T) {
+	ctx, w := newBatchContext(t, nil)
+
+	instrumentedGetWeatherBatch(ctx)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+// TestWeatherBatchSharesConcurrentFetches submits two overlapping batches at the same time and
+// checks that every city, including the shared ones, is only fetched from the upstream once.
+func TestWeatherBatchSharesConcurrentFetches(t *testing.T) {
+	release := make(chan struct{})
+
+	var mutex sync.Mutex
+	calls := map[string]int{}
+
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		calls[r.URL.Query().Get("q")]++
+		mutex.Unlock()
+
+		<-release
+		serveCannedWeather(w, r)
+	})
+
+	batches := [][]string{
+		{"London", "Paris", "Tokyo"},
+		{"Paris", "Tokyo", "Berlin"},
+	}
+
+	var wg sync.WaitGroup
+	recorders := make([]*httptest.ResponseRecorder, len(batches))
+
+	for i, cities := range batches {
+		ctx, w := newBatchContext(t, cities)
+		recorders[i] = w
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			instrumentedGetWeatherBatch(ctx)
+		}()
+	}
+
+	// Hold the upstream until both batches are waiting on the shared cities
+	waitUntil(t, func() bool {
+		return weatherFlights.callers("paris") == 2 &&
+			weatherFlights.callers("tokyo") == 2 &&
+			weatherFlights.callers("london") == 1 &&
+			weatherFlights.callers("berlin") == 1
+	})
+	close(release)
+	wg.Wait()
+
+	for city, count := range calls {
+		if count != 1 {
+			t.Errorf("Expected %s to be fetched once, fetched %d times", city, count)
+		}
+	}
+	if len(calls) != 4 {
+		t.Errorf("Expected 4 distinct upstream fetches, got %d: %v", len(calls), calls)
+	}
+
+	for i, w := range recorders {
+		var data []map[string]string
+		if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+			t.Fatalf("Error unmarshalling JSON response: %v", err)
+		}
+		for j, entry := range data {
+			if entry["city"] != batches[i][j] {
+				t.Errorf("Batch %d: expected %s at position %d, got %v", i, batches[i][j], j, entry)
+			}
+		}
+	}
+}
+
+// TestFlightGroupPanicReleasesWaiters panics in a shared fetch and checks the caller running it
+// gets the panic while the callers waiting on it get an error instead of blocking forever.
+func TestFlightGroupPanicReleasesWaiters(t *testing.T) {
+	group := &flightGroup{}
+	release := make(chan struct{})
+
+	leader := make(chan any)
+	go func() {
+		defer func() { leader <- recover() }()
+		group.Do("oslo", func() (WeatherData, error) {
+			<-release
+			panic("upstream decoder exploded")
+		})
+	}()
+	waitUntil(t, func() bool { return group.callers("oslo") == 1 })
+
+	waiter := make(chan error)
+	go func() {
+		_, err, _ := group.Do("oslo", func() (WeatherData, error) { return WeatherData{}, nil })
+		waiter <- err
+	}()
+	waitUntil(t, func() bool { return group.callers("oslo") == 2 })
+
+	close(release)
+
+	select {
+	case err := <-waiter:
+		if !errors.Is(err, ErrFlightPanicked) {
+			t.Errorf("Expected the waiter to get ErrFlightPanicked, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the waiter to be released after the panic")
+	}
+	if r := <-leader; r != "upstream decoder exploded" {
+		t.Errorf("Expected the panic to reach the caller running the fetch, got %v", r)
+	}
+	if group.callers("oslo") != 0 {
+		t.Error("Expected the panicked call to be forgotten")
+	}
+}
+
+// TestFlightGroupCancelsAbandonedCall gives up on a shared fetch from both of its callers in turn,
+// and checks the fetch keeps running for the remaining caller and is cancelled once both are gone.
+func TestFlightGroupCancelsAbandonedCall(t *testing.T) {
+	group := &flightGroup{}
+	cancelled := make(chan struct{})
+
+	call := func(ctx context.Context) chan error {
+		result := make(chan error, 1)
+		go func() {
+			_, err, _ := group.DoContext(ctx, "oslo", func(ctx context.Context) (WeatherData, error) {
+				<-ctx.Done()
+				close(cancelled)
+				return WeatherData{}, ctx.Err()
+			})
+			result <- err
+		}()
+		return result
+	}
+
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	leader := call(leaderCtx)
+	waitUntil(t, func() bool { return group.callers("oslo") == 1 })
+
+	waiterCtx, cancelWaiter := context.WithCancel(context.Background())
+	waiter := call(waiterCtx)
+	waitUntil(t, func() bool { return group.callers("oslo") == 2 })
+
+	cancelLeader()
+	if err := <-leader; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the leader to give up with context.Canceled, got %v", err)
+	}
+	select {
+	case <-cancelled:
+		t.Fatal("Expected the fetch to keep running while a caller still waits for it")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancelWaiter()
+	if err := <-waiter; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the waiter to give up with context.Canceled, got %v", err)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the fetch to be cancelled once every caller gave up")
+	}
+	if group.callers("oslo") != 0 {
+		t.Error("Expected the abandoned call to be forgotten")
+	}
+}
+
+// TestDedupWindowCoalescesBursts sends requests for the same city a few milliseconds apart, never
+// overlapping, and counts the upstream fetches with and without a dedup window.
+func TestDedupWindowCoalescesBursts(t *testing.T) {
+	var mutex sync.Mutex
+	calls := 0
+
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		calls++
+		mutex.Unlock()
+		serveCannedWeather(w, r)
+	})
+
+	burst := func(window time.Duration) int {
+		previous := config.DedupWindow
+		config.DedupWindow = window
+		defer func() { config.DedupWindow = previous }()
+
+		mutex.Lock()
+		calls = 0
+		mutex.Unlock()
+
+		for range 5 {
+			if _, err := fetchWeatherShared("Oslo"); err != nil {
+				t.Fatalf("Error fetching weather: %v", err)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+
+		waitUntil(t, func() bool { return weatherFlights.callers("oslo") == 0 })
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		return calls
+	}
+
+	if fetched := burst(0); fetched != 5 {
+		t.Errorf("Expected 5 upstream fetches without a window, got %d", fetched)
+	}
+	if fetched := burst(200 * time.Millisecond); fetched != 1 {
+		t.Errorf("Expected a single upstream fetch within the window, got %d", fetched)
+	}
+}
+
+func TestWeatherBatchStrategiesPreserveOrder(t *testing.T) {
+	previous := config
+	t.Cleanup(func() { config = previous })
+
+	cities := []string{"Paris", "Sydney", "Berlin", "Tokyo"}
+
+	for name := range batchStrategies {
+		t.Run(name, func(t *testing.T) {
+			config.BatchStrategy = name
+
+			ctx, w := newBatchContext(t, cities)
+			instrumentedGetWeatherBatch(ctx)
+
+			var data []map[string]string
+			if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+				t.Fatalf("Error unmarshalling JSON response: %v", err)
+			}
+			for i, entry := range data {
+				if entry["city"] != cities[i] {
+					t.Errorf("Expected %s at position %d, got %v", cities[i], i, entry)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadConfigBatchStrategy(t *testing.T) {
+	t.Setenv("WEATHER_BATCH_STRATEGY", "")
+	cfg, err := loadConfig()
+	if err != nil || cfg.BatchStrategy != "channel" {
+		t.Errorf("Expected the channel strategy by default, got %q (%v)", cfg.BatchStrategy, err)
+	}
+
+	t.Setenv("WEATHER_BATCH_STRATEGY", "waitgroup")
+	cfg, err = loadConfig()
+	if err != nil || cfg.BatchStrategy != "waitgroup" {
+		t.Errorf("Expected the waitgroup strategy, got %q (%v)", cfg.BatchStrategy, err)
+	}
+
+	t.Setenv("WEATHER_BATCH_STRATEGY", "yielding")
+	if _, err = loadConfig(); err == nil {
+		t.Error("Expected an error for an unknown strategy")
+	}
+}
+
+func TestWeatherBatchSort(t *testing.T) {
+	temperatures := map[string]float64{"Tokyo": 290, "London": 280, "Cairo": 305}
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		city := r.URL.Query().Get("q")
+		temp, ok := temperatures[city]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, `{"name":%q,"main":{"temp":%v}}`, city, temp)
+	})
+
+	cities := []string{"Atlantis", "Tokyo", "London", "Cairo"}
+
+	tests := map[string][]string{
+		"temp":  {"London", "Tokyo", "Cairo", "Atlantis"},
+		"-temp": {"Cairo", "Tokyo", "London", "Atlantis"},
+		"city":  {"Cairo", "London", "Tokyo", "Atlantis"},
+	}
+
+	for sort, want := range tests {
+		ctx, w := newBatchContext(t, cities)
+		ctx.Request.URL.RawQuery = "sort=" + sort
+
+		getWeatherBatch(ctx)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("sort=%s: expected status %d, got %d", sort, http.StatusOK, w.Code)
+		}
+
+		var data []map[string]string
+		if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+			t.Fatalf("sort=%s: error unmarshalling JSON response: %v", sort, err)
+		}
+
+		got := make([]string, len(data))
+		for i, entry := range data {
+			got[i] = entry["city"]
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("sort=%s: expected %v, got %v", sort, want, got)
+		}
+		if data[len(data)-1]["error"] == "" {
+			t.Errorf("sort=%s: expected the failed city last, got %v", sort, data[len(data)-1])
+		}
+	}
+}
+
+func TestWeatherBatchRejectsUnknownSort(t *testing.T) {
+	ctx, w := newBatchContext(t, []string{"Tokyo"})
+	ctx.Request.URL.RawQuery = "sort=humidity"
+
+	getWeatherBatch(ctx)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestWeatherBatchPage(t *testing.T) {
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"name":%q,"main":{"temp":290}}`, r.URL.Query().Get("q"))
+	})
+
+	ctx, w := newBatchContext(t, []string{"Tokyo", "London", "Cairo", "Paris", "Lima"})
+	ctx.Request.URL.RawQuery = "offset=1&limit=2"
+
+	getWeatherBatch(ctx)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	if total := w.Header().Get("X-Total-Count"); total != "5" {
+		t.Errorf("Expected a total count of 5, got %q", total)
+	}
+
+	var data []map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+		t.Fatalf("Error unmarshalling JSON response: %v", err)
+	}
+
+	got := make([]string, len(data))
+	for i, entry := range data {
+		got[i] = entry["city"]
+	}
+	if want := []string{"London", "Cairo"}; !slices.Equal(got, want) {
+		t.Errorf("Expected page %v, got %v", want, got)
+	}
+}
+
+func TestWeatherBatchRejectsInvalidPage(t *testing.T) {
+	for _, query := range []string{"offset=-1", "offset=abc", "limit=0", "limit=-2", "limit=abc"} {
+		ctx, w := newBatchContext(t, []string{"Tokyo"})
+		ctx.Request.URL.RawQuery = query
+
+		getWeatherBatch(ctx)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Query %q: expected status %d, got %d", query, http.StatusBadRequest, w.Code)
+		}
+	}
+}
+
+func TestWeatherBatchOnError(t *testing.T) {
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		city := r.URL.Query().Get("q")
+		if city == "Atlantis" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, `{"name":%q,"main":{"temp":290}}`, city)
+	})
+
+	tests := []struct {
+		onError  string
+		expected string
+	}{
+		{"", `[{"city":"Tokyo"},{"city":"Atlantis","error":"Failed to fetch weather data"},{"city":"London"}]`},
+		{"error", `[{"city":"Tokyo"},{"city":"Atlantis","error":"Failed to fetch weather data"},{"city":"London"}]`},
+		{"null", `[{"city":"Tokyo"},null,{"city":"London"}]`},
+		{"omit", `[{"city":"Tokyo"},{"city":"London"}]`},
+	}
+
+	for _, tt := range tests {
+		ctx, w := newBatchContext(t, []string{"Tokyo", "Atlantis", "London"})
+		if tt.onError != "" {
+			ctx.Request.URL.RawQuery = "on_error=" + tt.onError
+		}
+
+		getWeatherBatch(ctx)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("on_error=%s: expected status %d, got %d", tt.onError, http.StatusOK, w.Code)
+		}
+
+		// Keep the fields that identify each entry, the weather itself is checked elsewhere
+		var data []map[string]string
+		if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+			t.Fatalf("on_error=%s: error unmarshalling JSON response: %v", tt.onError, err)
+		}
+		for _, entry := range data {
+			delete(entry, "country")
+			delete(entry, "temperature")
+			delete(entry, "units")
+		}
+
+		if got, _ := json.Marshal(data); string(got) != tt.expected {
+			t.Errorf("on_error=%s: expected %s, got %s", tt.onError, tt.expected, got)
+		}
+	}
+}
+
+func TestWeatherBatchRejectsUnknownOnError(t *testing.T) {
+	ctx, w := newBatchContext(t, []string{"Tokyo"})
+	ctx.Request.URL.RawQuery = "on_error=skip"
+
+	getWeatherBatch(ctx)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestWeatherBatchUnits(t *testing.T) {
+	var upstreamUnits atomic.Value
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		upstreamUnits.Store(r.URL.Query().Get("units"))
+		fmt.Fprintf(w, `{"name": "Tokyo", "main": {"temp": %v}}`, inRequestedUnits(r, 295.15))
+	})
+
+	for _, tt := range []struct {
+		query    string
+		units    string
+		expected map[string]string
+	}{
+		{"", unitsMetric, map[string]string{"temperature": displayTemperature(295.15, displayCelsius)}},
+		{"units=imperial", unitsImperial, map[string]string{"temperature": displayTemperature(295.15, displayFahrenheit)}},
+		{"display=both", unitsMetric, map[string]string{
+			"temperature_c": displayTemperature(295.15, displayCelsius),
+			"temperature_f": displayTemperature(295.15, displayFahrenheit),
+		}},
+	} {
+		ctx, w := newBatchContext(t, []string{"Tokyo"})
+		ctx.Request.URL.RawQuery = tt.query
+
+		getWeatherBatch(ctx)
+
+		var data []map[string]string
+		if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil || len(data) != 1 {
+			t.Fatalf("%q: expected a single entry, got %s", tt.query, w.Body.String())
+		}
+
+		if got := upstreamUnits.Load(); got != tt.units {
+			t.Errorf("%q: expected the upstream to be queried in %s, got %v", tt.query, tt.units, got)
+		}
+		if data[0]["units"] != tt.units {
+			t.Errorf("%q: expected the entry to report %s units, got %v", tt.query, tt.units, data[0])
+		}
+		for field, expected := range tt.expected {
+			if data[0][field] != expected {
+				t.Errorf("%q: expected %s %s, got %v", tt.query, field, expected, data[0])
+			}
+		}
+	}
+
+	ctx, w := newBatchContext(t, []string{"Tokyo"})
+	ctx.Request.URL.RawQuery = "display=rankine"
+	getWeatherBatch(ctx)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an unknown display, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+// newBatchTimeoutContext builds a Gin test context carrying a batch request with per-city timeouts.
+func newBatchTimeoutContext(t *testing.T, cities []string, timeoutsMs map[string]int) (*gin.Context, *httptest.ResponseRecorder) {
+	t.Helper()
+
+	ctx, w := newBatchContext(t, nil)
+
+	body, err := json.Marshal(batchRequest{Cities: cities, TimeoutsMs: timeoutsMs})
+	if err != nil {
+		t.Fatalf("Error marshalling batch request: %v", err)
+	}
+	ctx.Request, _ = http.NewRequest(http.MethodPost, "/weather/batch", bytes.NewReader(body))
+	ctx.Request.Header.Set("Content-Type", "application/json")
+
+	return ctx, w
+}
+
+func TestWeatherBatchTimeoutOverride(t *testing.T) {
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("q") == "Slowtown" {
+			time.Sleep(400 * time.Millisecond)
+		}
+		serveCannedWeather(w, r)
+	})
+
+	// Slowtown takes longer than the default timeout, only the override lets it through
+	ctx, w := newBatchTimeoutContext(t, []string{"Tokyo", "Slowtown"}, map[string]int{"Slowtown": 2000})
+	getWeatherBatch(ctx)
+
+	var data []map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+		t.Fatalf("Error unmarshalling JSON response: %v", err)
+	}
+	if data[1]["error"] != "" || data[1]["city"] != "Slowtown" {
+		t.Errorf("Expected Slowtown to be fetched within its timeout override, got %v", data[1])
+	}
+
+	ctx, w = newBatchTimeoutContext(t, []string{"Slowtown"}, nil)
+	getWeatherBatch(ctx)
+
+	if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+		t.Fatalf("Error unmarshalling JSON response: %v", err)
+	}
+	if data[0]["error"] == "" {
+		t.Errorf("Expected Slowtown to time out without an override, got %v", data[0])
+	}
+}
+
+// TestWeatherBatchSharedBudget requests a batch whose slow cities each fit their own timeout but
+// together overrun the shared budget, and checks that the fast cities are returned on time.
+func TestWeatherBatchSharedBudget(t *testing.T) {
+	previous := config
+	t.Cleanup(func() { config = previous })
+	config.BatchBudget = 150 * time.Millisecond
+
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Query().Get("q"), "Slow") {
+			select {
+			case <-time.After(time.Second):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		serveCannedWeather(w, r)
+	})
+
+	cities := []string{"Tokyo", "Slowtown", "London", "Slowville"}
+	timeouts := map[string]int{"Slowtown": 2000, "Slowville": 2000}
+
+	for name := range batchStrategies {
+		t.Run(name, func(t *testing.T) {
+			config.BatchStrategy = name
+
+			ctx, w := newBatchTimeoutContext(t, cities, timeouts)
+			start := time.Now()
+			getWeatherBatch(ctx)
+
+			if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+				t.Errorf("Expected the batch to end with its budget, took %s", elapsed)
+			}
+
+			var data []map[string]string
+			if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+				t.Fatalf("Error unmarshalling JSON response: %v", err)
+			}
+			if len(data) != len(cities) {
+				t.Fatalf("Expected %d results, got %v", len(cities), data)
+			}
+
+			for i, city := range cities {
+				slow := strings.HasPrefix(city, "Slow")
+				if failed := data[i]["error"] != ""; failed != slow {
+					t.Errorf("Expected %s to fail: %v, got %v", city, slow, data[i])
+				}
+			}
+		})
+	}
+}
+
+func TestWeatherBatchBudgetCancelsFetches(t *testing.T) {
+	previous := config
+	t.Cleanup(func() { config = previous })
+	config.BatchBudget = 100 * time.Millisecond
+	config.CacheTTL = 0
+
+	cancelled := make(chan struct{}, 1)
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("q") == "Slowtown" {
+			<-r.Context().Done()
+			cancelled <- struct{}{}
+			return
+		}
+		serveCannedWeather(w, r)
+	})
+
+	ctx, w := newBatchContext(t, []string{"Tokyo", "Slowtown"})
+	getWeatherBatch(ctx)
+
+	if w.Code != http.StatusOK && w.Code != http.StatusGatewayTimeout {
+		t.Fatalf("Expected the batch to answer once its budget ran out, got %d", w.Code)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the upstream request for Slowtown to be cancelled with the batch budget")
+	}
+}
+
+func TestWeatherBatchRejectsInvalidTimeouts(t *testing.T) {
+	tests := map[string]map[string]int{
+		"too short":    {"Tokyo": 10},
+		"too long":     {"Tokyo": 60000},
+		"unknown city": {"London": 500},
+		"negative":     {"Tokyo": -1},
+	}
+
+	for name, timeouts := range tests {
+		ctx, w := newBatchTimeoutContext(t, []string{"Tokyo"}, timeouts)
+		getWeatherBatch(ctx)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", name, http.StatusBadRequest, w.Code)
+		}
+	}
+}
+
+func getGroup(t *testing.T, name string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request, _ = http.NewRequest(http.MethodGet, "/weather/group/"+name, nil)
+	ctx.Params = gin.Params{{Key: "name", Value: name}}
+
+	getWeatherGroup(ctx)
+
+	return w
+}
+
+func TestWeatherGroup(t *testing.T) {
+	previous := config
+	t.Cleanup(func() { config = previous })
+	config.CityGroups = map[string][]string{"europe": {"London", "Paris", "Berlin"}}
+
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"name":%q,"main":{"temp":285}}`, r.URL.Query().Get("q"))
+	})
+
+	w := getGroup(t, "europe")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var data []map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+		t.Fatalf("Error unmarshalling JSON response: %v", err)
+	}
+
+	got := make([]string, len(data))
+	for i, entry := range data {
+		got[i] = entry["city"]
+	}
+	if want := []string{"London", "Paris", "Berlin"}; !slices.Equal(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	if w := getGroup(t, "asia"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d for an unknown group, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestLoadConfigCityGroups(t *testing.T) {
+	t.Setenv("WEATHER_CITY_GROUPS", `{"europe": ["London", "Paris"]}`)
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !slices.Equal(cfg.CityGroups["europe"], []string{"London", "Paris"}) {
+		t.Errorf("Unexpected city groups %v", cfg.CityGroups)
+	}
+
+	for _, value := range []string{`["London"]`, `{"empty": []}`} {
+		t.Setenv("WEATHER_CITY_GROUPS", value)
+		if _, err := loadConfig(); err == nil {
+			t.Errorf("Expected %s to be rejected", value)
+		}
+	}
+}
+
+// TestWeatherBatchStream reads a streamed batch line by line, and checks that the cities already
+// fetched are delivered while a slow one is still being fetched.
+func TestWeatherBatchStream(t *testing.T) {
+	release := make(chan struct{})
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		city := r.URL.Query().Get("q")
+		switch city {
+		case "Lima":
+			<-release
+		case "Atlantis":
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		// In Celsius, as the batch queries the upstream in metric units
+		fmt.Fprintf(w, `{"name":%q,"main":{"temp":17}}`, city)
+	})
+
+	router := gin.New()
+	router.POST("/weather/batch", getWeatherBatch)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	body := `{"cities": ["Lima", "Tokyo", "Atlantis"]}`
+	resp, err := http.Post(server.URL+"/weather/batch?stream=true&on_error=null", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Error posting the batch: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if contentType := resp.Header.Get("Content-Type"); contentType != "application/x-ndjson" {
+		t.Errorf("Expected an application/x-ndjson response, got %q", contentType)
+	}
+
+	lines := bufio.NewScanner(resp.Body)
+	var got []string
+	for lines.Scan() {
+		got = append(got, lines.Text())
+		// Lima is only answered once the two other cities were delivered
+		if len(got) == 2 {
+			close(release)
+		}
+	}
+
+	slices.Sort(got[:2])
+	expected := []string{`null`, `{"city":"Tokyo","country":"","temperature":"17","units":"metric"}`, `{"city":"Lima","country":"","temperature":"17","units":"metric"}`}
+	if !slices.Equal(got, expected) {
+		t.Errorf("Expected the lines %q, got %q", expected, got)
+	}
+}
+
+func TestWeatherBatchStreamRejectsPaging(t *testing.T) {
+	for _, query := range []string{"stream=yes", "stream=true&sort=temp", "stream=true&limit=1"} {
+		ctx, w := newBatchContext(t, []string{"Tokyo"})
+		ctx.Request.URL.RawQuery = query
+
+		getWeatherBatch(ctx)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", query, http.StatusBadRequest, w.Code)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package weather
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+bodyLimitMiddleware rejects request bodies larger than maxBytes with 413 Request Entity Too Large,
+a maxBytes of 0 lets any size through.
+
+A body announced as too large by its Content-Length is rejected before any of it is read. This
+keeps Expect: 100-continue working for large uploads: net/http only sends the interim 100 Continue
+once the handler starts reading the body, so a client waiting for it never sends a body the
+server would refuse. A body without a Content-Length, such as a chunked one, is cut off once it
+exceeds maxBytes and fails to decode in the handler.
+*/
+func bodyLimitMiddleware(maxBytes int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if maxBytes <= 0 {
+			c.Next()
+			return
+		}
+
+		if c.Request.ContentLength > maxBytes {
+			// Do not wait for a body that was never going to be read before answering
+			c.Header("Connection", "close")
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body too large"})
+			return
+		}
+
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes)
+		c.Next()
+	}
+}
@@ -0,0 +1,110 @@
+package weather
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// postExpectingContinue sends the headers of a POST with Expect: 100-continue over conn and
+// returns the status line the server answers with before any body is sent.
+func postExpectingContinue(t *testing.T, conn net.Conn, reader *bufio.Reader, contentLength int) string {
+	t.Helper()
+
+	fmt.Fprintf(conn, "POST /upload HTTP/1.1\r\nHost: test\r\nContent-Type: application/json\r\n"+
+		"Content-Length: %d\r\nExpect: 100-continue\r\n\r\n", contentLength)
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	status, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatalf("Failed to read the interim response: %v", err)
+	}
+
+	return strings.TrimSpace(status)
+}
+
+func TestBodyLimitExpectContinue(t *testing.T) {
+	router := gin.New()
+	router.POST("/upload", bodyLimitMiddleware(64), func(c *gin.Context) {
+		var body map[string]any
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.Status(http.StatusBadRequest)
+			return
+		}
+		c.Status(http.StatusOK)
+	})
+
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	dial := func() (net.Conn, *bufio.Reader) {
+		conn, err := net.Dial("tcp", server.Listener.Addr().String())
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn, bufio.NewReader(conn)
+	}
+
+	t.Run("oversized body rejected before it is sent", func(t *testing.T) {
+		conn, reader := dial()
+
+		status := postExpectingContinue(t, conn, reader, 1<<20)
+		if status != "HTTP/1.1 413 Request Entity Too Large" {
+			t.Errorf("Expected 413 without a 100 Continue, got %q", status)
+		}
+	})
+
+	t.Run("accepted body sent after 100 Continue", func(t *testing.T) {
+		conn, reader := dial()
+
+		body := `{"cities": ["Tokyo"]}`
+		status := postExpectingContinue(t, conn, reader, len(body))
+		if status != "HTTP/1.1 100 Continue" {
+			t.Fatalf("Expected 100 Continue, got %q", status)
+		}
+
+		// Blank line ending the interim response
+		reader.ReadString('\n')
+		fmt.Fprint(conn, body)
+
+		response, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatalf("Failed to read the final response: %v", err)
+		}
+		response.Body.Close()
+
+		if response.StatusCode != http.StatusOK {
+			t.Errorf("Expected status code 200, got %v", response.StatusCode)
+		}
+	})
+}
+
+func TestBodyLimitChunkedBody(t *testing.T) {
+	router := gin.New()
+	router.POST("/upload", bodyLimitMiddleware(16), func(c *gin.Context) {
+		var body map[string]any
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.Status(http.StatusBadRequest)
+			return
+		}
+		c.Status(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/upload", strings.NewReader(`{"cities": ["Tokyo", "Paris"]}`))
+	// An unknown length, as for a chunked upload
+	req.ContentLength = -1
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected a body past the limit to fail to decode, got %v", w.Code)
+	}
+}
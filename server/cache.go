@@ -0,0 +1,295 @@
+package weather
+
+import (
+	"context"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// compactWeather holds only the fields of WeatherData that the responses are built from.
+// Keep it in sync with weatherResponse, notModifiedSince, isStaleObservation, IsDaytime, comfort,
+// setWind, setPressure, ConditionGroup, Precipitation and Summary.
+type compactWeather struct {
+	name             string
+	country          string
+	temp             float64
+	tempMin          *float64
+	tempMax          *float64
+	feelsLike        *float64
+	pressure         float64
+	seaLevel         float64
+	grndLevel        float64
+	windSpeed        float64
+	dt               int
+	sunrise          int
+	sunset           int
+	conditionID      int
+	description      string
+	rain             Rain
+	snow             Snow
+	fallbackProvider string
+}
+
+func compactWeatherData(weatherData WeatherData) *compactWeather {
+	conditionID, description := 0, ""
+	if len(weatherData.Weather) > 0 {
+		conditionID, description = weatherData.Weather[0].ID, weatherData.Weather[0].Description
+	}
+
+	return &compactWeather{
+		name:             weatherData.Name,
+		country:          weatherData.Sys.Country,
+		temp:             weatherData.Main.Temp,
+		tempMin:          weatherData.Main.TempMin,
+		tempMax:          weatherData.Main.TempMax,
+		feelsLike:        weatherData.Main.FeelsLike,
+		pressure:         weatherData.Main.Pressure,
+		seaLevel:         weatherData.Main.SeaLevel,
+		grndLevel:        weatherData.Main.GrndLevel,
+		windSpeed:        weatherData.Wind.Speed,
+		dt:               weatherData.Dt,
+		sunrise:          weatherData.Sys.Sunrise,
+		sunset:           weatherData.Sys.Sunset,
+		conditionID:      conditionID,
+		description:      description,
+		rain:             weatherData.Rain,
+		snow:             weatherData.Snow,
+		fallbackProvider: weatherData.FallbackProvider,
+	}
+}
+
+// expand rebuilds a WeatherData with the fields kept by compactWeatherData, the others are left zero.
+func (c *compactWeather) expand() WeatherData {
+	var weather []Weather
+	if c.conditionID != 0 || c.description != "" {
+		weather = []Weather{{ID: c.conditionID, Description: c.description}}
+	}
+
+	return WeatherData{
+		Name:    c.name,
+		Weather: weather,
+		Sys:     Sys{Country: c.country, Sunrise: c.sunrise, Sunset: c.sunset},
+		Main: Main{
+			Temp: c.temp, TempMin: c.tempMin, TempMax: c.tempMax, FeelsLike: c.feelsLike,
+			Pressure: c.pressure, SeaLevel: c.seaLevel, GrndLevel: c.grndLevel,
+		},
+		Wind: Wind{Speed: c.windSpeed},
+		Rain: c.rain,
+		Snow: c.snow,
+		Dt:   c.dt,
+
+		FallbackProvider: c.fallbackProvider,
+	}
+}
+
+// cacheEntry stores either the full upstream struct or its compact form, depending on WeatherConfig.CacheCompact
+// when the entry was stored.
+type cacheEntry struct {
+	stored  time.Time
+	expires time.Time
+	full    *WeatherData
+	compact *compactWeather
+}
+
+// data returns the weather held by the entry.
+func (e *cacheEntry) data() WeatherData {
+	if e.compact != nil {
+		return e.compact.expand()
+	}
+	return *e.full
+}
+
+// weatherCache keeps the current weather of recently requested locations for WeatherConfig.CacheTTL,
+// keyed by normalized location.
+type weatherCache struct {
+	mutex   sync.RWMutex
+	entries map[string]*cacheEntry
+
+	// Clock, replaced in tests
+	now func() time.Time
+}
+
+// currentWeatherCache is shared by every handler fetching the current weather by location.
+var currentWeatherCache = newWeatherCache()
+
+func newWeatherCache() *weatherCache {
+	return &weatherCache{
+		entries: make(map[string]*cacheEntry),
+		now:     time.Now,
+	}
+}
+
+// Get returns the cached weather for key if it has not expired yet.
+//
+// Parameters:
+// key (string): The normalized location.
+//
+// Return:
+// WeatherData: The cached data, with only the response fields set if the entry was stored compact.
+// bool: true on a cache hit.
+func (c *weatherCache) Get(key string) (WeatherData, bool) {
+	c.mutex.RLock()
+	entry, ok := c.entries[key]
+	c.mutex.RUnlock()
+
+	if !ok {
+		return WeatherData{}, false
+	}
+
+	now := c.now()
+	if now.After(entry.expires) {
+		// Expired entries are kept for GetStale until they are older than WeatherConfig.MaxStaleAge
+		if now.Sub(entry.stored) > config.MaxStaleAge {
+			c.mutex.Lock()
+			if c.entries[key] == entry {
+				delete(c.entries, key)
+			}
+			c.mutex.Unlock()
+		}
+		return WeatherData{}, false
+	}
+
+	return entry.data(), true
+}
+
+// GetStale returns the cached weather for key, expired or not, as long as it was stored at most
+// maxAge ago. It is the fallback served when the upstream API fails.
+//
+// Parameters:
+// key (string): The normalized location.
+// maxAge (time.Duration): The maximum age of the entry, 0 never returns anything.
+//
+// Return:
+// WeatherData: The cached data, with only the response fields set if the entry was stored compact.
+// bool: true if an entry recent enough was found.
+func (c *weatherCache) GetStale(key string, maxAge time.Duration) (WeatherData, bool) {
+	c.mutex.RLock()
+	entry, ok := c.entries[key]
+	c.mutex.RUnlock()
+
+	if !ok || c.now().Sub(entry.stored) > maxAge {
+		return WeatherData{}, false
+	}
+
+	return entry.data(), true
+}
+
+// Set stores the weather for key for WeatherConfig.CacheTTL, jittered by WeatherConfig.CacheTTLJitter.
+// It does nothing when the cache is disabled.
+//
+// Parameters:
+// key (string): The normalized location.
+// weatherData (WeatherData): The data fetched from upstream.
+func (c *weatherCache) Set(key string, weatherData WeatherData) {
+	if config.CacheTTL <= 0 {
+		return
+	}
+
+	now := c.now()
+	entry := &cacheEntry{stored: now, expires: now.Add(jitteredTTL(config.CacheTTL, config.CacheTTLJitter))}
+	if config.CacheCompact {
+		entry.compact = compactWeatherData(weatherData)
+	} else {
+		entry.full = &weatherData
+	}
+
+	c.mutex.Lock()
+	c.entries[key] = entry
+	c.mutex.Unlock()
+}
+
+// jitteredTTL returns ttl shortened or lengthened by a random amount of up to percent of it.
+func jitteredTTL(ttl time.Duration, percent int) time.Duration {
+	if percent <= 0 {
+		return ttl
+	}
+
+	spread := float64(ttl) * float64(percent) / 100
+	return ttl + time.Duration((rand.Float64()*2-1)*spread)
+}
+
+// Sweep removes the entries that expired more than WeatherConfig.MaxStaleAge after they were
+// stored, the ones Get would drop on their next lookup, and returns the number removed. Entries
+// still recent enough for GetStale are kept.
+func (c *weatherCache) Sweep() int {
+	now := c.now()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	swept := 0
+	for key, entry := range c.entries {
+		if now.After(entry.expires) && now.Sub(entry.stored) > config.MaxStaleAge {
+			delete(c.entries, key)
+			swept++
+		}
+	}
+	return swept
+}
+
+/*
+sweepWeatherCache sweeps currentWeatherCache every interval, see WeatherConfig.CacheSweepInterval.
+Without it an entry is only dropped when looked up again, so the cities requested once and never
+again would stay in memory for good. The entries swept are counted in weather_cache_swept_total.
+
+It returns when ctx is done.
+*/
+func sweepWeatherCache(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if swept := currentWeatherCache.Sweep(); swept > 0 {
+			cacheSweptCounter.Add(ctx, float64(swept))
+			logger.Info("Swept expired cache entries", "count", swept)
+		}
+	}
+}
+
+// Len returns the number of entries held, expired ones included until they are next looked up past
+// WeatherConfig.MaxStaleAge or swept.
+func (c *weatherCache) Len() int {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return len(c.entries)
+}
+
+// cacheEntryInfo describes a cached entry without its weather, for diagnostics.
+type cacheEntryInfo struct {
+	Key          string
+	Age          time.Duration
+	TTLRemaining time.Duration
+	Compact      bool
+}
+
+// Entries describes every entry held, expired ones included, sorted by key.
+func (c *weatherCache) Entries() []cacheEntryInfo {
+	now := c.now()
+
+	c.mutex.RLock()
+	infos := make([]cacheEntryInfo, 0, len(c.entries))
+	for key, entry := range c.entries {
+		infos = append(infos, cacheEntryInfo{
+			Key:          key,
+			Age:          now.Sub(entry.stored),
+			TTLRemaining: max(entry.expires.Sub(now), 0),
+			Compact:      entry.compact != nil,
+		})
+	}
+	c.mutex.RUnlock()
+
+	slices.SortFunc(infos, func(a, b cacheEntryInfo) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+
+	return infos
+}
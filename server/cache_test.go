@@ -0,0 +1,312 @@
+package weather
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// withCache enables the cache with the given settings and a fresh currentWeatherCache for the duration of the test.
+func withCache(tb testing.TB, ttl time.Duration, compact bool) {
+	tb.Helper()
+
+	previousConfig, previousCache := config, currentWeatherCache
+	config.CacheTTL = ttl
+	config.CacheCompact = compact
+	currentWeatherCache = newWeatherCache()
+
+	tb.Cleanup(func() {
+		config, currentWeatherCache = previousConfig, previousCache
+	})
+}
+
+func sampleWeatherData(city string) WeatherData {
+	return WeatherData{
+		GeoPos:  Coordinates{Longitude: 151.2, Latitude: -33.8},
+		Sys:     Sys{Country: "AU", Sunrise: 1700000000, Sunset: 1700040000},
+		Base:    "stations",
+		Weather: []Weather{{ID: 800, Main: "Clear", Description: "clear sky", Icon: "01d"}},
+		Main: Main{
+			Temp: 21.5, TempMin: optionalTemperature(19), TempMax: optionalTemperature(24), FeelsLike: optionalTemperature(21),
+			Pressure: 1012, Humidity: 60,
+		},
+		Wind: Wind{Speed: 4.1, Deg: 120},
+		Dt:   1700020000,
+		Name: city,
+		Cod:  200,
+	}
+}
+
+func TestWeatherCacheCompactKeepsResponseFields(t *testing.T) {
+	withCache(t, time.Minute, true)
+
+	data := sampleWeatherData("Sydney")
+	data.Rain = Rain{OneH: 0.4}
+	data.Main.SeaLevel, data.Main.GrndLevel = 1013, 1004
+	currentWeatherCache.Set("sydney", data)
+
+	cached, ok := currentWeatherCache.Get("sydney")
+	if !ok {
+		t.Fatal("Expected a cache hit")
+	}
+
+	if got, want := weatherResponse(cached, displayStandard), weatherResponse(data, displayStandard); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Expected the compact entry to produce %v, got %v", want, got)
+	}
+	if cached.Dt != data.Dt {
+		t.Errorf("Expected Dt %d, got %d", data.Dt, cached.Dt)
+	}
+}
+
+func TestWeatherCacheExpires(t *testing.T) {
+	withCache(t, time.Minute, false)
+	// Without a stale fallback, nothing keeps the expired entry around
+	config.MaxStaleAge = 0
+
+	now := time.Now()
+	currentWeatherCache.now = func() time.Time { return now }
+	currentWeatherCache.Set("sydney", sampleWeatherData("Sydney"))
+
+	now = now.Add(2 * time.Minute)
+	if _, ok := currentWeatherCache.Get("sydney"); ok {
+		t.Error("Expected the entry to have expired")
+	}
+	if currentWeatherCache.Len() != 0 {
+		t.Errorf("Expected the expired entry to be dropped, %d left", currentWeatherCache.Len())
+	}
+}
+
+// TestWeatherCacheMaxStaleAge checks that a failed upstream call falls back to the expired entry
+// only until it is older than the max stale age, and fails after that.
+func TestWeatherCacheMaxStaleAge(t *testing.T) {
+	withCache(t, time.Minute, false)
+	config.MaxStaleAge = 10 * time.Minute
+
+	now := time.Now()
+	currentWeatherCache.now = func() time.Time { return now }
+	currentWeatherCache.Set("sydney", sampleWeatherData("Sydney"))
+
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	now = now.Add(5 * time.Minute)
+	data, err := fetchWeatherShared("Sydney")
+	if err != nil {
+		t.Fatalf("Expected the stale entry to be served on upstream failure, got %v", err)
+	}
+	if data.Name != "Sydney" {
+		t.Errorf("Expected the stale weather of Sydney, got %q", data.Name)
+	}
+
+	now = now.Add(10 * time.Minute)
+	if _, err := fetchWeatherShared("Sydney"); err == nil {
+		t.Error("Expected an error once the entry is older than the max stale age")
+	}
+}
+
+// TestWeatherCacheCountsStaleServed checks that weather_stale_served_total counts the stale entries
+// served on upstream failure by city and country, and not the failures left without a fallback.
+func TestWeatherCacheCountsStaleServed(t *testing.T) {
+	reader := withMetricReader(t)
+	withCache(t, time.Minute, false)
+	config.MaxStaleAge = 10 * time.Minute
+
+	now := time.Now()
+	currentWeatherCache.now = func() time.Time { return now }
+	currentWeatherCache.Set("sydney", sampleWeatherData("Sydney"))
+
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	now = now.Add(5 * time.Minute)
+	for _, city := range []string{"Sydney", "Atlantis"} {
+		fetchWeatherShared(city)
+	}
+
+	var collected metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &collected); err != nil {
+		t.Fatalf("Error collecting metrics: %v", err)
+	}
+
+	var served float64
+	for _, scope := range collected.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			if data, ok := m.Data.(metricdata.Sum[float64]); ok && m.Name == "weather_stale_served_total" {
+				for _, point := range data.DataPoints {
+					served += point.Value
+					city, _ := point.Attributes.Value("city")
+					country, _ := point.Attributes.Value("country")
+					if city.AsString() != "sydney" || country.AsString() != "AU" {
+						t.Errorf("Expected the stale entry to be labelled sydney, AU, got %s, %s", city.AsString(), country.AsString())
+					}
+				}
+			}
+		}
+	}
+
+	if served != 1 {
+		t.Errorf("Expected weather_stale_served_total to count 1 stale entry, got %v", served)
+	}
+}
+
+func TestWeatherCacheSweep(t *testing.T) {
+	withCache(t, time.Minute, false)
+	config.MaxStaleAge = 10 * time.Minute
+
+	now := time.Now()
+	currentWeatherCache.now = func() time.Time { return now }
+
+	currentWeatherCache.Set("sydney", sampleWeatherData("Sydney"))
+	now = now.Add(5 * time.Minute)
+	currentWeatherCache.Set("tokyo", sampleWeatherData("Tokyo"))
+	now = now.Add(9 * time.Minute)
+	currentWeatherCache.Set("lima", sampleWeatherData("Lima"))
+
+	// Sydney is past the max stale age, Tokyo expired but can still be served stale, Lima is fresh
+	if swept := currentWeatherCache.Sweep(); swept != 1 {
+		t.Errorf("Expected 1 entry swept, got %d", swept)
+	}
+
+	var keys []string
+	for _, info := range currentWeatherCache.Entries() {
+		keys = append(keys, info.Key)
+	}
+	if fmt.Sprint(keys) != "[lima tokyo]" {
+		t.Errorf("Expected lima and tokyo to be kept, got %v", keys)
+	}
+}
+
+// TestSweepWeatherCacheCountsSwept runs the background sweep and checks that the entries it
+// removes are counted in weather_cache_swept_total.
+func TestSweepWeatherCacheCountsSwept(t *testing.T) {
+	reader := withMetricReader(t)
+	withCache(t, time.Minute, false)
+	config.MaxStaleAge = 0
+
+	now := time.Now()
+	currentWeatherCache.now = func() time.Time { return now }
+	currentWeatherCache.Set("sydney", sampleWeatherData("Sydney"))
+	currentWeatherCache.Set("tokyo", sampleWeatherData("Tokyo"))
+	now = now.Add(2 * time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		sweepWeatherCache(ctx, time.Millisecond)
+		close(done)
+	}()
+
+	waitUntil(t, func() bool { return currentWeatherCache.Len() == 0 })
+	cancel()
+	<-done
+
+	var collected metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &collected); err != nil {
+		t.Fatalf("Error collecting metrics: %v", err)
+	}
+
+	var swept float64
+	for _, scope := range collected.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			if data, ok := m.Data.(metricdata.Sum[float64]); ok && m.Name == "weather_cache_swept_total" {
+				for _, point := range data.DataPoints {
+					swept += point.Value
+				}
+			}
+		}
+	}
+
+	if swept != 2 {
+		t.Errorf("Expected weather_cache_swept_total to count 2 entries, got %v", swept)
+	}
+}
+
+func TestWeatherCacheJittersTTL(t *testing.T) {
+	withCache(t, time.Minute, false)
+	config.CacheTTLJitter = 20
+
+	now := time.Now()
+	currentWeatherCache.now = func() time.Time { return now }
+
+	minTTL, maxTTL := 48*time.Second, 72*time.Second
+	ttls := make(map[time.Duration]bool)
+
+	for i := range 100 {
+		key := fmt.Sprint("city", i)
+		currentWeatherCache.Set(key, sampleWeatherData(key))
+
+		ttl := currentWeatherCache.entries[key].expires.Sub(now)
+		if ttl < minTTL || ttl > maxTTL {
+			t.Errorf("Expected a TTL between %s and %s, got %s", minTTL, maxTTL, ttl)
+		}
+		ttls[ttl] = true
+	}
+
+	if len(ttls) < 2 {
+		t.Errorf("Expected the stored TTLs to vary, got %v", ttls)
+	}
+}
+
+func TestFetchWeatherSharedUsesCache(t *testing.T) {
+	withCache(t, time.Minute, false)
+
+	var calls atomic.Int64
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		serveCannedWeather(w, r)
+	})
+
+	for _, city := range []string{"Tokyo", "tokyo", " TOKYO"} {
+		if _, err := fetchWeatherShared(city); err != nil {
+			t.Fatalf("Error fetching %q: %v", city, err)
+		}
+	}
+
+	if calls.Load() != 1 {
+		t.Errorf("Expected a single upstream call, got %d", calls.Load())
+	}
+}
+
+// BenchmarkWeatherCacheMemory reports the heap held per cached city for the full and compact entries.
+func BenchmarkWeatherCacheMemory(b *testing.B) {
+	const cities = 10000
+
+	for _, compact := range []bool{false, true} {
+		name := "full"
+		if compact {
+			name = "compact"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			withCache(b, time.Hour, compact)
+
+			var perEntry float64
+			for b.Loop() {
+				currentWeatherCache = newWeatherCache()
+
+				var before, after runtime.MemStats
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+
+				for i := range cities {
+					key := fmt.Sprintf("city-%d", i)
+					currentWeatherCache.Set(key, sampleWeatherData(key))
+				}
+
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+				perEntry = float64(after.HeapAlloc-before.HeapAlloc) / cities
+			}
+
+			b.ReportMetric(perEntry, "bytes/entry")
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package weather
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// cacheControlWriter drops the Cache-Control header of error responses, so a failed fetch is not
+// cached by clients and proxies for as long as the weather it failed to return.
+type cacheControlWriter struct {
+	gin.ResponseWriter
+}
+
+func (w *cacheControlWriter) WriteHeader(code int) {
+	if code >= http.StatusBadRequest {
+		w.Header().Del("Cache-Control")
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// cacheControlValue is the Cache-Control header allowing clients and proxies to reuse a response
+// for maxAge, or forbidding them to store it at all when maxAge is 0.
+func cacheControlValue(maxAge time.Duration) string {
+	if maxAge <= 0 {
+		return "no-store"
+	}
+	return fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds()))
+}
+
+/*
+cacheControlMiddleware sets the Cache-Control header of the successful responses of a route, so
+each kind of data is reused by clients and proxies for as long as it stays relevant: the current
+weather for WeatherConfig.CurrentMaxAge, forecasts for WeatherConfig.ForecastMaxAge, and the admin
+routes not at all.
+
+Parameters:
+- maxAge: How long a response may be reused, 0 sends no-store.
+*/
+func cacheControlMiddleware(maxAge time.Duration) gin.HandlerFunc {
+	value := cacheControlValue(maxAge)
+
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", value)
+		c.Writer = &cacheControlWriter{ResponseWriter: c.Writer}
+		c.Next()
+	}
+}
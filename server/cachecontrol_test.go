@@ -0,0 +1,62 @@
+package weather
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCacheControlMiddleware(t *testing.T) {
+	router := gin.New()
+	router.GET("/weather/:location", cacheControlMiddleware(10*time.Minute), func(c *gin.Context) {
+		if c.Param("location") == "Atlantis" {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch weather data"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"city": c.Param("location")})
+	})
+	router.GET("/forecast/:location", cacheControlMiddleware(time.Hour), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"city": c.Param("location")})
+	})
+	router.GET("/admin/cache", cacheControlMiddleware(0), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{})
+	})
+
+	for _, tc := range []struct {
+		target   string
+		expected string
+	}{
+		{"/weather/Tokyo", "public, max-age=600"},
+		{"/forecast/Tokyo", "public, max-age=3600"},
+		{"/admin/cache", "no-store"},
+		{"/weather/Atlantis", ""},
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.target, nil))
+
+		if got := w.Header().Get("Cache-Control"); got != tc.expected {
+			t.Errorf("%s: expected Cache-Control %q, got %q", tc.target, tc.expected, got)
+		}
+	}
+}
+
+func TestLoadConfigMaxAge(t *testing.T) {
+	t.Setenv("WEATHER_CURRENT_MAX_AGE", "5m")
+	t.Setenv("WEATHER_FORECAST_MAX_AGE", "")
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("Error loading the configuration: %v", err)
+	}
+	if cfg.CurrentMaxAge != 5*time.Minute || cfg.ForecastMaxAge != time.Hour {
+		t.Errorf("Expected max ages of 5m and 1h, got %s and %s", cfg.CurrentMaxAge, cfg.ForecastMaxAge)
+	}
+
+	t.Setenv("WEATHER_FORECAST_MAX_AGE", "-1h")
+	if _, err := loadConfig(); err == nil {
+		t.Error("Expected a negative max age to be rejected")
+	}
+}
@@ -0,0 +1,162 @@
+package weather
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrCircuitOpen is returned without calling the upstream API for a location whose circuit is open.
+var ErrCircuitOpen = errors.New("circuit open for location")
+
+// maxCircuits bounds the number of locations circuitBreakers tracks, the locations are client supplied.
+const maxCircuits = 1000
+
+// locationCircuit tracks the consecutive upstream failures of one location.
+type locationCircuit struct {
+	failures int
+	openedAt time.Time
+	failedAt time.Time
+}
+
+/*
+circuitBreakers short-circuits the upstream calls of the locations that keep failing, each
+location on its own: a city that consistently times out stops being requested while every other
+city is fetched as usual.
+
+A location's circuit opens after WeatherConfig.BreakerThreshold consecutive failures. Once
+WeatherConfig.BreakerCooldown has passed, calls are let through again (half-open): a success
+closes the circuit and a failure opens it for another cooldown.
+
+At most limit locations are tracked. When full, the closed circuits whose last failure is older
+than the cooldown are forgotten first, then the least recently failed one.
+*/
+type circuitBreakers struct {
+	mutex    sync.Mutex
+	circuits map[string]*locationCircuit
+	limit    int
+
+	// Clock, replaced in tests
+	now func() time.Time
+}
+
+// locationBreakers is shared by every fetch of the current weather by location, keyed by normalized location.
+var locationBreakers = newCircuitBreakers(maxCircuits)
+
+func newCircuitBreakers(limit int) *circuitBreakers {
+	return &circuitBreakers{
+		circuits: make(map[string]*locationCircuit),
+		limit:    limit,
+		now:      time.Now,
+	}
+}
+
+// isOpen reports whether the circuit is open and tracked by the breakers.
+func (c *locationCircuit) isOpen(now time.Time) bool {
+	return c.failures >= config.BreakerThreshold && now.Sub(c.openedAt) < config.BreakerCooldown
+}
+
+// Allow reports whether the upstream may be called for key. It always does when the breakers are disabled.
+func (b *circuitBreakers) Allow(key string) bool {
+	if config.BreakerThreshold <= 0 {
+		return true
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	circuit, ok := b.circuits[key]
+	return !ok || !circuit.isOpen(b.now())
+}
+
+// Record closes the circuit of key on success, and counts the failure otherwise, opening the
+// circuit when the threshold is reached. An unknown city is an answer from the upstream rather
+// than a failure, so typos never open the circuit.
+func (b *circuitBreakers) Record(key string, err error) {
+	if config.BreakerThreshold <= 0 {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if err == nil || errors.Is(err, ErrCityNotFound) {
+		delete(b.circuits, key)
+		return
+	}
+
+	now := b.now()
+
+	circuit, ok := b.circuits[key]
+	if !ok {
+		if len(b.circuits) >= b.limit {
+			b.evict(now)
+		}
+		circuit = &locationCircuit{}
+		b.circuits[key] = circuit
+	}
+
+	circuit.failures++
+	circuit.failedAt = now
+	if circuit.failures >= config.BreakerThreshold {
+		circuit.openedAt = now
+	}
+}
+
+// evict makes room for a new circuit, see circuitBreakers. The caller holds the mutex.
+func (b *circuitBreakers) evict(now time.Time) {
+	for key, circuit := range b.circuits {
+		if circuit.failures < config.BreakerThreshold && now.Sub(circuit.failedAt) >= config.BreakerCooldown {
+			delete(b.circuits, key)
+		}
+	}
+	if len(b.circuits) < b.limit {
+		return
+	}
+
+	oldest := ""
+	for key, circuit := range b.circuits {
+		if oldest == "" || circuit.failedAt.Before(b.circuits[oldest].failedAt) {
+			oldest = key
+		}
+	}
+	delete(b.circuits, oldest)
+}
+
+// OpenLocations returns the locations whose circuit is currently open, sorted.
+func (b *circuitBreakers) OpenLocations() []string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := b.now()
+	locations := []string{}
+	for key, circuit := range b.circuits {
+		if circuit.isOpen(now) {
+			locations = append(locations, key)
+		}
+	}
+	slices.Sort(locations)
+
+	return locations
+}
+
+// getCircuitStatus handles the /admin/circuit route.
+// It responds with the locations whose circuit is open, along with the breaker settings.
+//
+// Parameters:
+// ctx (gin.Context): The Gin context containing request and response objects.
+//
+// Return:
+// None
+func getCircuitStatus(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{
+		"enabled":          config.BreakerThreshold > 0,
+		"threshold":        config.BreakerThreshold,
+		"cooldown_seconds": config.BreakerCooldown.Seconds(),
+		"open_locations":   locationBreakers.OpenLocations(),
+	})
+}
@@ -0,0 +1,160 @@
+package weather
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// withBreakers enables fresh location breakers for the duration of the test, on a clock the test controls.
+func withBreakers(t *testing.T, threshold int, cooldown time.Duration) *time.Time {
+	t.Helper()
+
+	previousConfig, previousBreakers := config, locationBreakers
+	config.BreakerThreshold = threshold
+	config.BreakerCooldown = cooldown
+
+	now := time.Now()
+	locationBreakers = newCircuitBreakers(maxCircuits)
+	locationBreakers.now = func() time.Time { return now }
+
+	t.Cleanup(func() {
+		config, locationBreakers = previousConfig, previousBreakers
+	})
+
+	return &now
+}
+
+func TestCircuitBreakersOpenPerLocation(t *testing.T) {
+	now := withBreakers(t, 2, time.Minute)
+
+	var calls atomic.Int32
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if strings.EqualFold(r.URL.Query().Get("q"), "Atlantis") {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{"name": "Tokyo", "main": {"temp": 20}}`))
+	})
+
+	for range 2 {
+		if _, err := fetchWeatherShared("Atlantis"); err == nil {
+			t.Fatal("Expected the failing location to fail")
+		}
+	}
+
+	callsBefore := calls.Load()
+	if _, err := fetchWeatherShared(" atlantis "); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Expected ErrCircuitOpen once the threshold is reached, got %v", err)
+	}
+	if calls.Load() != callsBefore {
+		t.Error("Expected no upstream call for a location whose circuit is open")
+	}
+
+	if _, err := fetchWeatherShared("Tokyo"); err != nil {
+		t.Errorf("Expected other locations to be fetched normally, got %v", err)
+	}
+
+	if open := locationBreakers.OpenLocations(); !slices.Equal(open, []string{"atlantis"}) {
+		t.Errorf("Expected only atlantis to be open, got %v", open)
+	}
+
+	// After the cooldown a call is let through, and its failure opens the circuit again
+	*now = now.Add(time.Minute)
+	if _, err := fetchWeatherShared("Atlantis"); err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Expected the upstream to be called after the cooldown, got %v", err)
+	}
+	if _, err := fetchWeatherShared("Atlantis"); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Expected a failure after the cooldown to reopen the circuit, got %v", err)
+	}
+}
+
+func TestCircuitBreakersCloseOnSuccess(t *testing.T) {
+	withBreakers(t, 2, time.Minute)
+
+	locationBreakers.Record("paris", errors.New("timeout"))
+	locationBreakers.Record("paris", nil)
+	locationBreakers.Record("paris", errors.New("timeout"))
+
+	if !locationBreakers.Allow("paris") {
+		t.Error("Expected a success to reset the consecutive failures")
+	}
+}
+
+func TestCircuitBreakersDisabled(t *testing.T) {
+	withBreakers(t, 0, time.Minute)
+
+	for range 10 {
+		locationBreakers.Record("paris", errors.New("timeout"))
+	}
+
+	if !locationBreakers.Allow("paris") {
+		t.Error("Expected every call to be allowed when the breakers are disabled")
+	}
+}
+
+func TestCircuitBreakersBounded(t *testing.T) {
+	now := withBreakers(t, 2, time.Minute)
+	locationBreakers.limit = 2
+
+	locationBreakers.Record("paris", errors.New("timeout"))
+	*now = now.Add(time.Minute)
+	locationBreakers.Record("london", errors.New("timeout"))
+	locationBreakers.Record("london", errors.New("timeout"))
+	locationBreakers.Record("tokyo", errors.New("timeout"))
+
+	if _, ok := locationBreakers.circuits["paris"]; ok {
+		t.Error("Expected the idle closed circuit to be forgotten")
+	}
+	if locationBreakers.Allow("london") {
+		t.Error("Expected the open circuit to be kept")
+	}
+
+	locationBreakers.Record("berlin", errors.New("timeout"))
+
+	if len(locationBreakers.circuits) != 2 {
+		t.Errorf("Expected at most 2 circuits, got %d", len(locationBreakers.circuits))
+	}
+	if _, ok := locationBreakers.circuits["berlin"]; !ok {
+		t.Error("Expected the new circuit to be tracked")
+	}
+}
+
+func TestGetCircuitStatus(t *testing.T) {
+	withBreakers(t, 1, time.Minute)
+
+	locationBreakers.Record("london", errors.New("timeout"))
+	locationBreakers.Record("atlantis", errors.New("timeout"))
+
+	router := gin.New()
+	router.GET("/admin/circuit", getCircuitStatus)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/admin/circuit", nil)
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %v", w.Code)
+	}
+
+	var status struct {
+		Enabled       bool     `json:"enabled"`
+		OpenLocations []string `json:"open_locations"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatalf("Failed to decode the circuit status: %v", err)
+	}
+
+	if !status.Enabled || !slices.Equal(status.OpenLocations, []string{"atlantis", "london"}) {
+		t.Errorf("Expected atlantis and london to be open, got %+v", status)
+	}
+}
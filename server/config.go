@@ -0,0 +1,543 @@
+package weather
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WeatherConfig holds the tunables of the weather service.
+//
+// The defaults are meant for production. Each field can be overridden through a WEATHER_*
+// environment variable, which is read once when WeatherServer starts.
+type WeatherConfig struct {
+	// Concurrency strategy used by the batch endpoint, one of the keys of batchStrategies
+	BatchStrategy string
+	// Total time budget shared by the fetches of a batch request, past which the cities fetched so
+	// far are returned and the others reported as failed. 0 disables the budget
+	BatchBudget time.Duration
+	// How /weather/query resolves a request giving both q and lat and lon: "coordinates", "name" or "reject"
+	LocationPrecedence string
+	// How a path with a trailing slash, such as /weather/, is handled: "redirect" answers with a
+	// redirect to the path without it, "serve" serves that path directly
+	TrailingSlash string
+	// Named lists of cities served on /weather/group/:name, set as a JSON object such as
+	// {"europe": ["London", "Paris", "Berlin"]}
+	CityGroups map[string][]string
+
+	// Requests per second allowed for each client IP, 0 disables rate limiting
+	RateLimit float64
+	// Number of requests a client IP may burst above the rate
+	RateBurst int
+	// IPs or CIDRs of the reverse proxies whose X-Forwarded-For header gives the client IP, empty
+	// trusts none so clients cannot pick the IP they are rate limited as
+	TrustedProxies []string
+
+	// Maximum number of requests in flight to the upstream API, shared by every handler
+	MaxUpstreamRequests int
+	// Maximum number of connections open to the upstream host at once, 0 means unlimited
+	UpstreamMaxConnsPerHost int
+	// Number of connections opened to the upstream host at startup, 0 disables the warm-up
+	WarmupConnections int
+	// Cities fetched into the cache at startup
+	WarmupCities []string
+	// Maximum number of cities fetched at once while preloading
+	WarmupConcurrency int
+	// Delay between the start of two preloaded fetches
+	WarmupStagger time.Duration
+	// Consecutive upstream failures after which the circuit of a location opens, 0 disables the breakers
+	BreakerThreshold int
+	// How long an open circuit short-circuits its location before calls are let through again
+	BreakerCooldown time.Duration
+	// Timeout of each upstream request, which SetRequestTimeout and /admin/config/timeout adjust at runtime
+	RequestTimeout time.Duration
+	// Upstream timeout of each provider, keyed by provider name. A provider without an entry keeps
+	// its own default, the upstream client timeout for OpenWeatherMap
+	ProviderTimeouts map[string]time.Duration
+	// Temperature difference in degrees past which the two providers of /weather/:location/consensus
+	// are reported as disagreeing
+	ConsensusMaxDelta float64
+
+	// ID of this instance sent in the X-Served-By header, the host name by default
+	InstanceID string
+	// Also report the instance ID in the served_by field of the weather responses
+	ServedByField bool
+
+	// Include the upstream status code in the error responses of failed fetches. It helps debugging
+	// integrations but reveals upstream internals to clients
+	DebugErrors bool
+	// Log only one in this many of the per-city records of the stress and batch handlers, so
+	// high-volume runs do not flood the logs. Errors are always logged, 1 logs every record
+	StressLogSampleRate int
+
+	// Bearer token required by the /admin/config, /admin/cache, /admin/circuit and /admin/stats routes, which are not served while it is empty
+	AdminToken string
+
+	// Serve canned weather, forecasts and One Call data from DemoProvider instead of calling the
+	// upstream API, so every route runs without network access or API key
+	DemoMode bool
+
+	// Serve the /weather/stress0 to /weather/stress3 benchmarking endpoints. Meant for development,
+	// they should be disabled in production
+	StressEndpoints bool
+	// Serve the cumulative counters on /stats
+	StatsEnabled bool
+	// Let /metrics answer in the OpenMetrics format to scrapers asking for it in their Accept header
+	MetricsOpenMetrics bool
+	// Serve a request duration histogram on /metrics with the trace ID of each request as an exemplar
+	MetricsExemplars bool
+
+	// Ascending Celsius temperatures at which the cold, mild, warm and hot comfort levels start,
+	// below the first one it is freezing
+	ComfortThresholds []float64
+
+	// Age past which an upstream observation is flagged as stale, 0 disables the check
+	StaleObservationAge time.Duration
+	// Number of days of forecast a client may request, the free upstream tier forecasts 5 days ahead
+	ForecastHorizonDays int
+
+	// Maximum number of simultaneous client connections, 0 means unlimited
+	MaxConnections int
+	// Maximum size in bytes of a request body, 0 means unlimited
+	MaxBodyBytes int
+
+	// How long the current weather of a location is cached, 0 disables the cache
+	CacheTTL time.Duration
+	// Percentage by which each entry's TTL is randomly shortened or lengthened, so entries stored
+	// together do not all expire and refresh from upstream at once. 0 disables the jitter
+	CacheTTLJitter int
+	// Age past which a cached entry is no longer served in place of a failed upstream call, so the
+	// degraded mode fails instead of serving arbitrarily old weather. 0 disables the stale fallback
+	MaxStaleAge time.Duration
+	// Cache only the response fields instead of the full upstream struct, to fit more cities in memory
+	CacheCompact bool
+	// How often the expired entries are swept from the cache, so cities requested once do not stay
+	// in memory for good. 0 disables the sweep, entries are then only dropped when looked up again
+	CacheSweepInterval time.Duration
+	// How long the result of an upstream fetch is still handed to new requests for the same location
+	// after the fetch completed, coalescing bursts that just miss each other. 0 only shares fetches
+	// that are in flight
+	DedupWindow time.Duration
+
+	// Serve /weather from the last value fetched in the background only, never calling the upstream API
+	// while a client waits. Responses are instant but up to LocalRefreshInterval old
+	LocalCacheOnly bool
+	// How often the background refresher fetches the local weather in cache-only mode
+	LocalRefreshInterval time.Duration
+
+	// How long serialized GET responses are replayed for identical requests, 0 disables the response cache
+	ResponseCacheTTL time.Duration
+	// Maximum number of responses held by the response cache
+	ResponseCacheSize int
+
+	// How long clients and proxies may reuse a current weather response, sent as the max-age of its
+	// Cache-Control header. 0 sends no-store
+	CurrentMaxAge time.Duration
+	// How long clients and proxies may reuse a forecast response, longer than the current weather
+	// as forecasts are updated less often. 0 sends no-store
+	ForecastMaxAge time.Duration
+}
+
+// config is the active configuration, replaced by WeatherServer at startup and by tests.
+var config = defaultConfig()
+
+func defaultConfig() WeatherConfig {
+	return WeatherConfig{
+		BatchStrategy:      "channel",
+		BatchBudget:        15 * time.Second,
+		LocationPrecedence: precedenceCoordinates,
+		TrailingSlash:      trailingSlashRedirect,
+		RateLimit:          0,
+		RateBurst:          10,
+
+		MaxUpstreamRequests:     20,
+		UpstreamMaxConnsPerHost: 10,
+		WarmupConnections:       0,
+		WarmupConcurrency:       4,
+		WarmupStagger:           100 * time.Millisecond,
+		BreakerThreshold:        5,
+		BreakerCooldown:         30 * time.Second,
+		RequestTimeout:          5 * time.Second,
+		ConsensusMaxDelta:       2,
+
+		InstanceID:    hostnameInstanceID(),
+		ServedByField: false,
+
+		DebugErrors:         false,
+		StressLogSampleRate: 100,
+
+		DemoMode: false,
+
+		StressEndpoints:    true,
+		StatsEnabled:       true,
+		MetricsOpenMetrics: true,
+		MetricsExemplars:   false,
+
+		ComfortThresholds: []float64{0, 10, 20, 28},
+
+		StaleObservationAge: time.Hour,
+		ForecastHorizonDays: 5,
+
+		MaxConnections: 0,
+		MaxBodyBytes:   64 << 10,
+
+		CacheTTL:           10 * time.Minute,
+		CacheTTLJitter:     0,
+		CacheCompact:       false,
+		CacheSweepInterval: 5 * time.Minute,
+		MaxStaleAge:        time.Hour,
+		DedupWindow:        100 * time.Millisecond,
+
+		LocalCacheOnly:       false,
+		LocalRefreshInterval: time.Minute,
+
+		ResponseCacheTTL:  0,
+		ResponseCacheSize: 1000,
+
+		CurrentMaxAge:  10 * time.Minute,
+		ForecastMaxAge: time.Hour,
+	}
+}
+
+// loadConfig builds the configuration from the defaults and the WEATHER_* environment variables.
+//
+// Parameters:
+// None
+//
+// Return:
+// WeatherConfig: The resulting configuration.
+// error: An error if any variable holds an invalid value.
+func loadConfig() (WeatherConfig, error) {
+	cfg := defaultConfig()
+
+	if strategy := os.Getenv("WEATHER_BATCH_STRATEGY"); strategy != "" {
+		if _, ok := batchStrategies[strategy]; !ok {
+			return cfg, fmt.Errorf("unknown WEATHER_BATCH_STRATEGY %q", strategy)
+		}
+		cfg.BatchStrategy = strategy
+	}
+	if behavior := os.Getenv("WEATHER_TRAILING_SLASH"); behavior != "" {
+		if behavior != trailingSlashRedirect && behavior != trailingSlashServe {
+			return cfg, fmt.Errorf("unknown WEATHER_TRAILING_SLASH %q", behavior)
+		}
+		cfg.TrailingSlash = behavior
+	}
+	if err := envDuration("WEATHER_BATCH_BUDGET", &cfg.BatchBudget); err != nil {
+		return cfg, err
+	}
+
+	if precedence := os.Getenv("WEATHER_LOCATION_PRECEDENCE"); precedence != "" {
+		if !slices.Contains(locationPrecedences, precedence) {
+			return cfg, fmt.Errorf("unknown WEATHER_LOCATION_PRECEDENCE %q", precedence)
+		}
+		cfg.LocationPrecedence = precedence
+	}
+
+	if groups := os.Getenv("WEATHER_CITY_GROUPS"); groups != "" {
+		if err := json.Unmarshal([]byte(groups), &cfg.CityGroups); err != nil {
+			return cfg, fmt.Errorf("invalid WEATHER_CITY_GROUPS: %v", err)
+		}
+		for name, cities := range cfg.CityGroups {
+			if len(cities) == 0 || len(cities) > maxBatchCities {
+				return cfg, fmt.Errorf("city group %q must hold between 1 and %d cities", name, maxBatchCities)
+			}
+		}
+	}
+
+	if err := envFloat("WEATHER_RATE_LIMIT", &cfg.RateLimit); err != nil {
+		return cfg, err
+	}
+	if err := envInt("WEATHER_RATE_BURST", &cfg.RateBurst); err != nil {
+		return cfg, err
+	}
+	if err := envList("WEATHER_TRUSTED_PROXIES", &cfg.TrustedProxies); err != nil {
+		return cfg, err
+	}
+
+	if err := envInt("WEATHER_MAX_UPSTREAM_REQUESTS", &cfg.MaxUpstreamRequests); err != nil {
+		return cfg, err
+	}
+	if cfg.MaxUpstreamRequests == 0 {
+		return cfg, fmt.Errorf("WEATHER_MAX_UPSTREAM_REQUESTS must be at least 1")
+	}
+	if err := envInt("WEATHER_UPSTREAM_MAX_CONNS_PER_HOST", &cfg.UpstreamMaxConnsPerHost); err != nil {
+		return cfg, err
+	}
+	if err := envInt("WEATHER_WARMUP_CONNECTIONS", &cfg.WarmupConnections); err != nil {
+		return cfg, err
+	}
+	if err := envList("WEATHER_WARMUP_CITIES", &cfg.WarmupCities); err != nil {
+		return cfg, err
+	}
+	if err := envInt("WEATHER_WARMUP_CONCURRENCY", &cfg.WarmupConcurrency); err != nil {
+		return cfg, err
+	}
+	if cfg.WarmupConcurrency == 0 {
+		return cfg, fmt.Errorf("WEATHER_WARMUP_CONCURRENCY must be at least 1")
+	}
+	if err := envDuration("WEATHER_WARMUP_STAGGER", &cfg.WarmupStagger); err != nil {
+		return cfg, err
+	}
+	if err := envInt("WEATHER_BREAKER_THRESHOLD", &cfg.BreakerThreshold); err != nil {
+		return cfg, err
+	}
+	if err := envDuration("WEATHER_BREAKER_COOLDOWN", &cfg.BreakerCooldown); err != nil {
+		return cfg, err
+	}
+	if err := envDuration("WEATHER_REQUEST_TIMEOUT", &cfg.RequestTimeout); err != nil {
+		return cfg, err
+	}
+	if cfg.RequestTimeout < minUpstreamTimeout || cfg.RequestTimeout > maxUpstreamTimeout {
+		return cfg, fmt.Errorf("WEATHER_REQUEST_TIMEOUT must be between %s and %s", minUpstreamTimeout, maxUpstreamTimeout)
+	}
+	if err := envDurations("WEATHER_PROVIDER_TIMEOUTS", &cfg.ProviderTimeouts); err != nil {
+		return cfg, err
+	}
+	if err := envFloat("WEATHER_CONSENSUS_MAX_DELTA", &cfg.ConsensusMaxDelta); err != nil {
+		return cfg, err
+	}
+
+	if id := os.Getenv("WEATHER_INSTANCE_ID"); id != "" {
+		cfg.InstanceID = id
+	}
+	if err := envBool("WEATHER_SERVED_BY_FIELD", &cfg.ServedByField); err != nil {
+		return cfg, err
+	}
+
+	if err := envBool("WEATHER_DEBUG_ERRORS", &cfg.DebugErrors); err != nil {
+		return cfg, err
+	}
+	if err := envInt("WEATHER_STRESS_LOG_SAMPLE_RATE", &cfg.StressLogSampleRate); err != nil {
+		return cfg, err
+	}
+	if cfg.StressLogSampleRate == 0 {
+		return cfg, fmt.Errorf("WEATHER_STRESS_LOG_SAMPLE_RATE must be at least 1")
+	}
+
+	cfg.AdminToken = os.Getenv("WEATHER_ADMIN_TOKEN")
+
+	if err := envBool("WEATHER_DEMO_MODE", &cfg.DemoMode); err != nil {
+		return cfg, err
+	}
+
+	if err := envBool("WEATHER_STRESS_ENDPOINTS", &cfg.StressEndpoints); err != nil {
+		return cfg, err
+	}
+	if err := envBool("WEATHER_STATS_ENABLED", &cfg.StatsEnabled); err != nil {
+		return cfg, err
+	}
+	if err := envBool("WEATHER_METRICS_OPENMETRICS", &cfg.MetricsOpenMetrics); err != nil {
+		return cfg, err
+	}
+	if err := envBool("WEATHER_METRICS_EXEMPLARS", &cfg.MetricsExemplars); err != nil {
+		return cfg, err
+	}
+
+	if err := envFloats("WEATHER_COMFORT_THRESHOLDS", &cfg.ComfortThresholds); err != nil {
+		return cfg, err
+	}
+	if len(cfg.ComfortThresholds) != len(comfortLevels)-1 || !slices.IsSorted(cfg.ComfortThresholds) {
+		return cfg, fmt.Errorf("WEATHER_COMFORT_THRESHOLDS must be %d ascending temperatures", len(comfortLevels)-1)
+	}
+
+	if err := envDuration("WEATHER_STALE_OBSERVATION_AGE", &cfg.StaleObservationAge); err != nil {
+		return cfg, err
+	}
+	if err := envInt("WEATHER_FORECAST_HORIZON_DAYS", &cfg.ForecastHorizonDays); err != nil {
+		return cfg, err
+	}
+	if cfg.ForecastHorizonDays < 1 {
+		return cfg, fmt.Errorf("WEATHER_FORECAST_HORIZON_DAYS must be at least 1")
+	}
+
+	if err := envInt("WEATHER_MAX_CONNECTIONS", &cfg.MaxConnections); err != nil {
+		return cfg, err
+	}
+	if err := envInt("WEATHER_MAX_BODY_BYTES", &cfg.MaxBodyBytes); err != nil {
+		return cfg, err
+	}
+
+	if err := envDuration("WEATHER_CACHE_TTL", &cfg.CacheTTL); err != nil {
+		return cfg, err
+	}
+	if err := envDuration("WEATHER_MAX_STALE_AGE", &cfg.MaxStaleAge); err != nil {
+		return cfg, err
+	}
+	if err := envInt("WEATHER_CACHE_TTL_JITTER", &cfg.CacheTTLJitter); err != nil {
+		return cfg, err
+	}
+	if cfg.CacheTTLJitter > 100 {
+		return cfg, fmt.Errorf("WEATHER_CACHE_TTL_JITTER must be a percentage between 0 and 100")
+	}
+	if err := envBool("WEATHER_CACHE_COMPACT", &cfg.CacheCompact); err != nil {
+		return cfg, err
+	}
+	if err := envDuration("WEATHER_CACHE_SWEEP_INTERVAL", &cfg.CacheSweepInterval); err != nil {
+		return cfg, err
+	}
+	if err := envDuration("WEATHER_DEDUP_WINDOW", &cfg.DedupWindow); err != nil {
+		return cfg, err
+	}
+
+	if err := envBool("WEATHER_LOCAL_CACHE_ONLY", &cfg.LocalCacheOnly); err != nil {
+		return cfg, err
+	}
+	if err := envDuration("WEATHER_LOCAL_REFRESH_INTERVAL", &cfg.LocalRefreshInterval); err != nil {
+		return cfg, err
+	}
+	if cfg.LocalCacheOnly && cfg.LocalRefreshInterval == 0 {
+		return cfg, fmt.Errorf("WEATHER_LOCAL_REFRESH_INTERVAL must be positive when WEATHER_LOCAL_CACHE_ONLY is set")
+	}
+
+	if err := envDuration("WEATHER_RESPONSE_CACHE_TTL", &cfg.ResponseCacheTTL); err != nil {
+		return cfg, err
+	}
+	if err := envInt("WEATHER_RESPONSE_CACHE_SIZE", &cfg.ResponseCacheSize); err != nil {
+		return cfg, err
+	}
+
+	if err := envDuration("WEATHER_CURRENT_MAX_AGE", &cfg.CurrentMaxAge); err != nil {
+		return cfg, err
+	}
+	if err := envDuration("WEATHER_FORECAST_MAX_AGE", &cfg.ForecastMaxAge); err != nil {
+		return cfg, err
+	}
+
+	return cfg, nil
+}
+
+// envFloat overwrites target with the non-negative float held by the environment variable name, if set.
+func envFloat(name string, target *float64) error {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil || parsed < 0 || math.IsNaN(parsed) || math.IsInf(parsed, 0) {
+		return fmt.Errorf("invalid %s %q: expected a non-negative number", name, value)
+	}
+
+	*target = parsed
+	return nil
+}
+
+// envInt overwrites target with the non-negative integer held by the environment variable name, if set.
+func envInt(name string, target *int) error {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 0 {
+		return fmt.Errorf("invalid %s %q: expected a non-negative integer", name, value)
+	}
+
+	*target = parsed
+	return nil
+}
+
+// envBool overwrites target with the boolean held by the environment variable name, if set.
+func envBool(name string, target *bool) error {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: expected true or false", name, value)
+	}
+
+	*target = parsed
+	return nil
+}
+
+// envDuration overwrites target with the non-negative duration (e.g. "90s", "1h") held by the environment variable name, if set.
+func envDuration(name string, target *time.Duration) error {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed < 0 {
+		return fmt.Errorf("invalid %s %q: expected a non-negative duration such as 90s", name, value)
+	}
+
+	*target = parsed
+	return nil
+}
+
+// envList overwrites target with the comma separated values (e.g. "London,Paris") held by the environment
+// variable name, if set. Values are trimmed and empty ones skipped.
+func envList(name string, target *[]string) error {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+
+	var parsed []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			parsed = append(parsed, item)
+		}
+	}
+
+	*target = parsed
+	return nil
+}
+
+// envFloats overwrites target with the comma separated numbers (e.g. "0,10,20,28") held by the environment
+// variable name, if set. Unlike envFloat, negative numbers are accepted.
+func envFloats(name string, target *[]float64) error {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+
+	var parsed []float64
+	for _, item := range strings.Split(value, ",") {
+		number, err := strconv.ParseFloat(strings.TrimSpace(item), 64)
+		if err != nil || math.IsNaN(number) || math.IsInf(number, 0) {
+			return fmt.Errorf("invalid %s %q: expected comma separated numbers", name, value)
+		}
+		parsed = append(parsed, number)
+	}
+
+	*target = parsed
+	return nil
+}
+
+// envDurations overwrites target with the comma separated name=duration pairs (e.g. "openweather=200ms,backup=2s")
+// held by the environment variable name, if set. Names are lower cased and every duration must be positive.
+func envDurations(name string, target *map[string]time.Duration) error {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+
+	parsed := make(map[string]time.Duration)
+	for _, pair := range strings.Split(value, ",") {
+		key, duration, ok := strings.Cut(pair, "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+		if !ok || key == "" {
+			return fmt.Errorf("invalid %s %q: expected name=duration pairs such as openweather=200ms", name, value)
+		}
+
+		d, err := time.ParseDuration(strings.TrimSpace(duration))
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid %s %q: expected a positive duration for %s", name, value, key)
+		}
+		parsed[key] = d
+	}
+
+	*target = parsed
+	return nil
+}
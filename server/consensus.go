@@ -0,0 +1,166 @@
+package weather
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Confidence levels of a consensus reading, see consensusConfidence.
+const (
+	// Both providers answered and agree within WeatherConfig.ConsensusMaxDelta
+	confidenceHigh = "high"
+	// Both providers answered but disagree by more than WeatherConfig.ConsensusMaxDelta
+	confidenceLow = "low"
+	// Only one provider answered, its reading cannot be cross-checked
+	confidenceReduced = "reduced"
+)
+
+// consensusReading is the answer of one provider to a consensus request.
+type consensusReading struct {
+	provider string
+	data     WeatherData
+	err      error
+}
+
+// consensusWeather asks the first two providers for the current weather of location concurrently,
+// each bounded by its own configured timeout, and returns their readings in provider order.
+func consensusWeather(ctx context.Context, providers []Provider, location string) []consensusReading {
+	readings := make([]consensusReading, min(len(providers), 2))
+
+	var wg sync.WaitGroup
+	for i := range readings {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			data, err := currentWeatherWithin(ctx, providers[i], location)
+			readings[i] = consensusReading{provider: providers[i].Name(), data: data, err: err}
+		}(i)
+	}
+	wg.Wait()
+
+	return readings
+}
+
+// consensusConfidence rates how far the readings can be trusted, from how many succeeded and how
+// much their temperatures differ. The difference is only meaningful with two successful readings.
+//
+// Return:
+// string: One of the confidence levels.
+// float64: The absolute temperature difference in degrees, 0 unless both readings succeeded.
+// bool: false when no reading succeeded.
+func consensusConfidence(readings []consensusReading, maxDelta float64) (string, float64, bool) {
+	var succeeded []WeatherData
+	for _, reading := range readings {
+		if reading.err == nil {
+			succeeded = append(succeeded, reading.data)
+		}
+	}
+
+	switch len(succeeded) {
+	case 0:
+		return "", 0, false
+	case 1:
+		return confidenceReduced, 0, true
+	}
+
+	delta := math.Round(math.Abs(succeeded[0].Main.Temp-succeeded[1].Main.Temp)*100) / 100
+	if delta > maxDelta {
+		return confidenceLow, delta, true
+	}
+	return confidenceHigh, delta, true
+}
+
+/*
+getWeatherConsensus fetches the current weather of the location given in the path from two
+providers at once and returns both readings with a confidence indicator, for clients that need
+to know how reliable the data is.
+
+The confidence is high when both providers agree within WeatherConfig.ConsensusMaxDelta degrees,
+low when they disagree by more, which is also flagged with disagreement, and reduced when only
+one provider answered. A failed provider is reported in place with an error field.
+
+Parameters:
+- ctx: The Gin context used to handle the HTTP request and response. The location is extracted
+from the "location" parameter, the optional "display" query parameter selects the temperature scale.
+
+An HTTP 503 status code is returned when fewer than two providers are configured, and an HTTP 500
+status code when both fail. The readings bypass the cache, which only holds a single provider's data.
+*/
+func getWeatherConsensus(ctx *gin.Context) {
+
+	city := ctx.Param("location")
+
+	display, err := parseTemperatureDisplay(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if len(weatherProviders) < 2 {
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"error": "Consensus needs at least two weather providers"})
+		return
+	}
+
+	readings := consensusWeather(ctx.Request.Context(), weatherProviders, city)
+
+	confidence, delta, ok := consensusConfidence(readings, config.ConsensusMaxDelta)
+	if !ok {
+		logger.Error("Every consensus provider failed", "city", city)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch weather data"})
+		return
+	}
+
+	entries := make([]gin.H, 0, len(readings))
+	for _, reading := range readings {
+		if reading.err != nil {
+			logger.Error("Consensus provider failed", "provider", reading.provider, "city", city, "error", reading.err)
+			entries = append(entries, gin.H{"provider": reading.provider, "error": "Failed to fetch weather data"})
+			continue
+		}
+
+		entry := gin.H{"provider": reading.provider}
+		setTemperature(entry, "temperature", reading.data.Main.Temp, display)
+		entries = append(entries, entry)
+	}
+
+	response := gin.H{
+		"city":       city,
+		"readings":   entries,
+		"confidence": confidence,
+	}
+	if confidence != confidenceReduced {
+		response["temperature_delta"] = delta
+		response["disagreement"] = confidence == confidenceLow
+	}
+
+	ctx.JSON(http.StatusOK, response)
+
+}
+
+func instrumentedGetWeatherConsensus(ctx *gin.Context) {
+	traceCtx, span := tracer.Start(ctx.Request.Context(), "getWeatherConsensus")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("location", ctx.Param("location")),
+		attribute.String("method", ctx.Request.Method),
+		attribute.String("path", ctx.Request.URL.Path),
+	)
+
+	start := time.Now()
+	weatherRequestCounter.Add(traceCtx, 1,
+		metric.WithAttributes(attribute.Key("endpoint").String("getWeatherConsensus")))
+	getWeatherConsensus(ctx)
+	duration := time.Since(start).Seconds()
+	weatherRequestDuration.Record(traceCtx, duration,
+		metric.WithAttributes(attribute.Key("endpoint").String("getWeatherConsensus")))
+
+	span.SetAttributes(attribute.Int("http.status_code", ctx.Writer.Status()))
+}
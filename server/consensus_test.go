@@ -0,0 +1,121 @@
+package weather
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// temperatureProvider answers with a fixed temperature in Kelvin, or with err when set.
+type temperatureProvider struct {
+	name   string
+	kelvin float64
+	err    error
+}
+
+func (p temperatureProvider) Name() string {
+	return p.name
+}
+
+func (p temperatureProvider) CurrentWeather(ctx context.Context, location string) (WeatherData, error) {
+	if p.err != nil {
+		return WeatherData{}, p.err
+	}
+	return WeatherData{Name: location, Main: Main{Temp: p.kelvin}}, nil
+}
+
+func (p temperatureProvider) Ping(ctx context.Context) error {
+	return p.err
+}
+
+func getConsensus(t *testing.T, providers []Provider) (int, map[string]any) {
+	t.Helper()
+
+	previousConfig, previousProviders := config, weatherProviders
+	t.Cleanup(func() { config, weatherProviders = previousConfig, previousProviders })
+	weatherProviders = providers
+	config.ConsensusMaxDelta = 2
+
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Params = []gin.Param{{Key: "location", Value: "Tokyo"}}
+	ctx.Request, _ = http.NewRequest(http.MethodGet, "/weather/Tokyo/consensus?display=celsius", nil)
+
+	getWeatherConsensus(ctx)
+
+	var data map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+		t.Fatalf("Error unmarshalling JSON response: %v", err)
+	}
+	return w.Code, data
+}
+
+func TestWeatherConsensusConfidence(t *testing.T) {
+	failed := errors.New("provider down")
+
+	tests := []struct {
+		name         string
+		providers    []Provider
+		confidence   string
+		disagreement any
+	}{
+		{"agree", []Provider{temperatureProvider{name: "a", kelvin: 293.15}, temperatureProvider{name: "b", kelvin: 294.65}}, confidenceHigh, false},
+		{"disagree", []Provider{temperatureProvider{name: "a", kelvin: 293.15}, temperatureProvider{name: "b", kelvin: 298.15}}, confidenceLow, true},
+		{"one fails", []Provider{temperatureProvider{name: "a", err: failed}, temperatureProvider{name: "b", kelvin: 298.15}}, confidenceReduced, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, data := getConsensus(t, tt.providers)
+
+			if status != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, status)
+			}
+			if data["confidence"] != tt.confidence || data["disagreement"] != tt.disagreement {
+				t.Errorf("Expected %s confidence with disagreement %v, got %v", tt.confidence, tt.disagreement, data)
+			}
+
+			readings, _ := data["readings"].([]any)
+			if len(readings) != 2 {
+				t.Fatalf("Expected both readings, got %v", data["readings"])
+			}
+			for i, reading := range readings {
+				entry := reading.(map[string]any)
+				if entry["provider"] != tt.providers[i].Name() {
+					t.Errorf("Expected reading %d from %s, got %v", i, tt.providers[i].Name(), entry)
+				}
+			}
+		})
+	}
+}
+
+func TestWeatherConsensusReadings(t *testing.T) {
+	_, data := getConsensus(t, []Provider{
+		temperatureProvider{name: "a", err: errors.New("provider down")},
+		temperatureProvider{name: "b", kelvin: 298.15},
+	})
+
+	readings := data["readings"].([]any)
+	if failed := readings[0].(map[string]any); failed["error"] == nil || failed["temperature"] != nil {
+		t.Errorf("Expected an error in place of the failed reading, got %v", failed)
+	}
+	if succeeded := readings[1].(map[string]any); succeeded["temperature"] != "25" {
+		t.Errorf("Expected 25°C from the remaining provider, got %v", succeeded)
+	}
+}
+
+func TestWeatherConsensusUnavailable(t *testing.T) {
+	if status, _ := getConsensus(t, []Provider{temperatureProvider{name: "a", kelvin: 293.15}}); status != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d with a single provider, got %d", http.StatusServiceUnavailable, status)
+	}
+
+	failed := errors.New("provider down")
+	if status, _ := getConsensus(t, []Provider{temperatureProvider{name: "a", err: failed}, temperatureProvider{name: "b", err: failed}}); status != http.StatusInternalServerError {
+		t.Errorf("Expected status %d when both providers fail, got %d", http.StatusInternalServerError, status)
+	}
+}
@@ -0,0 +1,185 @@
+package weather
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// providerDemo is the name of DemoProvider.
+const providerDemo = "demo"
+
+// demoConditions are the weather conditions DemoProvider picks from, in the OpenWeatherMap format.
+var demoConditions = []Weather{
+	{ID: 800, Main: "Clear", Description: "clear sky", Icon: "01d"},
+	{ID: 801, Main: "Clouds", Description: "few clouds", Icon: "02d"},
+	{ID: 803, Main: "Clouds", Description: "broken clouds", Icon: "04d"},
+	{ID: 500, Main: "Rain", Description: "light rain", Icon: "10d"},
+	{ID: 701, Main: "Mist", Description: "mist", Icon: "50d"},
+}
+
+/*
+DemoProvider serves canned weather without any network access or API key, so the service runs
+out of the box for demos and CI, see WeatherConfig.DemoMode.
+
+The weather of a location is derived from a hash of its normalized name: the same city always
+gets the same plausible readings, and different cities get different ones. Only the observation
+time follows the clock, so the data is never flagged as stale.
+
+Besides the current weather of the provider chain, it provides the forecasts, the weather at
+coordinates and the One Call data standing in for the other upstream requests in demo mode.
+*/
+type DemoProvider struct{}
+
+func (DemoProvider) Name() string {
+	return providerDemo
+}
+
+// CurrentWeather returns the canned weather of location in the standard units, whatever the
+// units asked for on ctx, as the other providers do once their answer is converted.
+func (DemoProvider) CurrentWeather(ctx context.Context, location string) (WeatherData, error) {
+	if err := ctx.Err(); err != nil {
+		return WeatherData{}, err
+	}
+
+	name, err := url.PathUnescape(strings.TrimSpace(location))
+	if err != nil {
+		name = strings.TrimSpace(location)
+	}
+
+	return demoWeather(name), nil
+}
+
+// WeatherAt returns the canned weather at coordinates, in the standard units and named after them.
+func (DemoProvider) WeatherAt(coordinates Coordinates) WeatherData {
+	data := demoWeather(fmt.Sprintf("%.2f,%.2f", coordinates.Latitude, coordinates.Longitude))
+	data.GeoPos = coordinates
+	return data
+}
+
+// Forecast returns the canned forecast of location over days, 0 for the five days the upstream
+// covers, in 3 hour slots starting with the next one. The temperature follows a daily cycle around
+// the current canned weather, warmest in the afternoon, and is in Kelvin as the upstream sends it.
+func (DemoProvider) Forecast(location string, days int) ForecastData {
+	current := demoWeather(location)
+
+	slots := 5 * forecastSlotsPerDay
+	if days > 0 {
+		slots = min(days*forecastSlotsPerDay, slots)
+	}
+
+	start := time.Now().Truncate(3 * time.Hour).Add(3 * time.Hour)
+	list := make([]ForecastEntry, 0, slots)
+	for i := range slots {
+		at := start.Add(time.Duration(i) * 3 * time.Hour).UTC()
+		swing := 4 * math.Sin(float64(at.Hour()-9)/24*2*math.Pi)
+
+		list = append(list, ForecastEntry{
+			Dt:         int(at.Unix()),
+			Main:       Main{Temp: roundHundredths(current.Main.Temp + swing), Pressure: current.Main.Pressure, Humidity: current.Main.Humidity},
+			Weather:    current.Weather,
+			Clouds:     current.Clouds,
+			Wind:       current.Wind,
+			Visibility: current.Visibility,
+			DtTxt:      at.Format(time.DateTime),
+		})
+	}
+
+	return ForecastData{
+		Cnt:  len(list),
+		List: list,
+		City: ForecastCity{
+			ID:      current.ID,
+			Name:    current.Name,
+			GeoPos:  current.GeoPos,
+			Country: current.Sys.Country,
+			Sunrise: current.Sys.Sunrise,
+			Sunset:  current.Sys.Sunset,
+		},
+	}
+}
+
+// OneCall returns the canned One Call data at coordinates: the weather of WeatherAt, a UV index
+// that is 0 at night, and no alerts.
+func (p DemoProvider) OneCall(coordinates Coordinates) OneCallData {
+	current := p.WeatherAt(coordinates)
+
+	uvi := 0.0
+	if current.IsDaytime() {
+		uvi = float64(demoSeed(current.Name) >> 48 % 11)
+	}
+
+	return OneCallData{
+		Latitude:  coordinates.Latitude,
+		Longitude: coordinates.Longitude,
+		Timezone:  "UTC",
+		Current: OneCallCurrent{
+			Dt:        current.Dt,
+			Sunrise:   current.Sys.Sunrise,
+			Sunset:    current.Sys.Sunset,
+			Temp:      current.Main.Temp,
+			FeelsLike: *current.Main.FeelsLike,
+			Pressure:  current.Main.Pressure,
+			Humidity:  current.Main.Humidity,
+			Uvi:       uvi,
+			Weather:   current.Weather,
+		},
+		Alerts: []Alert{},
+	}
+}
+
+// Ping always succeeds, DemoProvider has nothing to reach.
+func (DemoProvider) Ping(ctx context.Context) error {
+	return nil
+}
+
+// demoSeed hashes the normalized name of a location into the seed of its canned readings.
+func demoSeed(name string) uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(normalizeLocation(name)))
+	return hash.Sum64()
+}
+
+// demoWeather returns the canned weather of the location name, see DemoProvider.
+func demoWeather(name string) WeatherData {
+	seed := demoSeed(name)
+
+	// Between -5 and 34.9 degrees Celsius
+	celsius := float64(seed%400)/10 - 5
+	temp := roundHundredths(celsius + 273.15)
+	now := time.Now()
+	midnight := now.Truncate(24 * time.Hour)
+
+	return WeatherData{
+		GeoPos: Coordinates{
+			Latitude:  math.Round((float64(seed>>8%1600)/10-80)*100) / 100,
+			Longitude: math.Round((float64(seed>>20%3600)/10-180)*100) / 100,
+		},
+		Sys: Sys{
+			Country: "XX",
+			Sunrise: int(midnight.Add(6 * time.Hour).Unix()),
+			Sunset:  int(midnight.Add(18 * time.Hour).Unix()),
+		},
+		Base:    "stations",
+		Weather: []Weather{demoConditions[seed>>32%uint64(len(demoConditions))]},
+		Main: Main{
+			Temp:      temp,
+			TempMin:   optionalTemperature(roundHundredths(temp - 2)),
+			TempMax:   optionalTemperature(roundHundredths(temp + 2)),
+			FeelsLike: optionalTemperature(roundHundredths(temp - float64(seed>>12%30)/10)),
+			Pressure:  float64(995 + seed>>16%35),
+			Humidity:  int(30 + seed>>24%60),
+		},
+		Visibility: 10000,
+		Wind:       Wind{Speed: float64(seed>>28%120) / 10, Deg: float64(seed >> 36 % 360)},
+		Clouds:     Clouds{All: int(seed >> 40 % 100)},
+		Dt:         int(now.Unix()),
+		ID:         int(seed >> 44 % 1000000),
+		Name:       name,
+		Cod:        200,
+	}
+}
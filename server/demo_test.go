@@ -0,0 +1,152 @@
+package weather
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// roundTripperFunc lets a function stand in for an http.RoundTripper.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestDemoModeWithoutNetwork(t *testing.T) {
+	previousConfig, previousProviders := config, weatherProviders
+	previousTransport := upstreamClient.Transport
+	previousKeys, previousKey := apiKeysFile, apiKeyFile
+	t.Cleanup(func() {
+		config, weatherProviders = previousConfig, previousProviders
+		upstreamClient.Transport = previousTransport
+		apiKeysFile, apiKeyFile = previousKeys, previousKey
+	})
+
+	// No API key anywhere, and any outgoing request fails the test
+	dir := t.TempDir()
+	apiKeysFile, apiKeyFile = filepath.Join(dir, "api.keys"), filepath.Join(dir, "api.key")
+	t.Setenv("OPENWEATHER_API_KEY", "")
+	t.Setenv("OWM_API_KEY", "")
+	upstreamClient.Transport = roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		t.Errorf("Expected no network access in demo mode, got a request to %s", r.URL.Host)
+		return nil, errors.New("network disabled")
+	})
+
+	t.Setenv("WEATHER_DEMO_MODE", "true")
+	cfg, err := loadConfig()
+	if err != nil || !cfg.DemoMode {
+		t.Fatalf("Expected WEATHER_DEMO_MODE to enable demo mode, got %v (%v)", cfg.DemoMode, err)
+	}
+	config = cfg
+	config.CacheTTL = 0
+	config.DedupWindow = 0
+	weatherProviders = []Provider{DemoProvider{}}
+
+	router := gin.New()
+	router.GET("/weather/:location", getWeatherInternational)
+
+	get := func(city string) map[string]any {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/weather/"+city+"?units=standard", nil))
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected status code %d, got %d: %s", city, http.StatusOK, w.Code, w.Body.String())
+		}
+
+		var data map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+			t.Fatalf("%s: error unmarshalling JSON response: %v", city, err)
+		}
+		return data
+	}
+
+	tokyo := get("Tokyo")
+	if tokyo["city"] != "Tokyo" || tokyo["description"] == "" {
+		t.Errorf("Expected canned weather for Tokyo, got %v", tokyo)
+	}
+	if again := get("tokyo"); again["temperature"] != tokyo["temperature"] {
+		t.Errorf("Expected the same temperature for the same city, got %v and %v", tokyo["temperature"], again["temperature"])
+	}
+	if lima := get("Lima"); lima["temperature"] == tokyo["temperature"] {
+		t.Errorf("Expected different cities to get different temperatures, both got %v", lima["temperature"])
+	}
+}
+
+func TestDemoModeServesEveryRoute(t *testing.T) {
+	previousConfig, previousProviders := config, weatherProviders
+	previousTransport := upstreamClient.Transport
+	previousKeys, previousKey := apiKeysFile, apiKeyFile
+	t.Cleanup(func() {
+		config, weatherProviders = previousConfig, previousProviders
+		upstreamClient.Transport = previousTransport
+		apiKeysFile, apiKeyFile = previousKeys, previousKey
+	})
+
+	dir := t.TempDir()
+	apiKeysFile, apiKeyFile = filepath.Join(dir, "api.keys"), filepath.Join(dir, "api.key")
+	t.Setenv("OPENWEATHER_API_KEY", "")
+	t.Setenv("OWM_API_KEY", "")
+	offline := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		t.Errorf("Expected no network access in demo mode, got a request to %s", r.URL)
+		return nil, errors.New("network disabled")
+	})
+	upstreamClient.Transport = offline
+
+	config.DemoMode = true
+	config.CacheTTL = 0
+	config.DedupWindow = 0
+	config.ResponseCacheTTL = 0
+	config.StressEndpoints = true
+	config.StatsEnabled = true
+	config.AdminToken = "demo-token"
+	config.CityGroups = map[string][]string{"asia": {"Tokyo", "Seoul"}}
+	weatherProviders = []Provider{DemoProvider{}}
+
+	router := gin.New()
+	registerRoutes(router, prometheus.NewRegistry())
+
+	params := strings.NewReplacer(":location", "Tokyo", ":code", "ATL", ":name", "asia")
+	queries := map[string]string{
+		"/weather":           "?include_forecast=true",
+		"/weather/:location": "?include_forecast=true",
+		"/weather/query":     "?q=Tokyo",
+		"/alerts/coords":     "?lat=35.68&lon=139.69",
+		"/uv/coords":         "?lat=35.68&lon=139.69",
+	}
+	bodies := map[string]string{
+		"/weather/batch":        `{"cities": ["Tokyo", "Lima"]}`,
+		"/forecast/batch":       `{"cities": ["Tokyo", "Lima"]}`,
+		"/admin/config/timeout": `{"timeout": "5s"}`,
+	}
+
+	for _, route := range router.Routes() {
+		target := params.Replace(route.Path) + queries[route.Path]
+
+		request := httptest.NewRequest(route.Method, target, strings.NewReader(bodies[route.Path]))
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set("Authorization", "Bearer "+config.AdminToken)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, request)
+
+		// A consensus compares several providers, and demo mode has a single one
+		if route.Path == "/weather/:location/consensus" {
+			if w.Code != http.StatusServiceUnavailable {
+				t.Errorf("%s %s: expected status code %d, got %d", route.Method, target, http.StatusServiceUnavailable, w.Code)
+			}
+			continue
+		}
+
+		if w.Code >= http.StatusBadRequest || strings.Contains(w.Body.String(), `"error":`) {
+			t.Errorf("%s %s: expected canned data, got %d: %s", route.Method, target, w.Code, w.Body.String())
+		}
+	}
+}
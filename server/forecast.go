@@ -0,0 +1,430 @@
+package weather
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+type ForecastEntry struct {
+	Dt         int       `json:"dt"`
+	Main       Main      `json:"main"`
+	Weather    []Weather `json:"weather"`
+	Clouds     Clouds    `json:"clouds"`
+	Wind       Wind      `json:"wind"`
+	Visibility int       `json:"visibility"`
+	Pop        float64   `json:"pop"`
+	Rain       Rain      `json:"rain"`
+	Snow       Snow      `json:"snow"`
+	DtTxt      string    `json:"dt_txt"`
+}
+
+type ForecastCity struct {
+	ID       int         `json:"id"`
+	Name     string      `json:"name"`
+	GeoPos   Coordinates `json:"coord"`
+	Country  string      `json:"country"`
+	Timezone int         `json:"timezone"`
+	Sunrise  int         `json:"sunrise"`
+	Sunset   int         `json:"sunset"`
+}
+
+type ForecastData struct {
+	Cnt  int             `json:"cnt"`
+	List []ForecastEntry `json:"list"`
+	City ForecastCity    `json:"city"`
+}
+
+// forecastSlotsPerDay is the number of 3 hour slots the upstream forecasts each day.
+const forecastSlotsPerDay = 8
+
+// sendForecastRequest sends a GET request to the OpenWeatherMap API to fetch the 5 day / 3 hour forecast for a location.
+//
+// Parameters:
+// ctx (context.Context): Cancels the request, as when the client of the handler goes away.
+// location (string): The location for which to fetch the forecast.
+// days (int): The number of days of forecast to fetch, 0 fetches every slot the upstream has.
+//
+// Return:
+// ForecastData: A struct containing the parsed forecast.
+// error: An error if any occurred during the request or response processing.
+func sendForecastRequest(ctx context.Context, location string, days int) (ForecastData, error) {
+	if config.DemoMode {
+		return DemoProvider{}.Forecast(location, days), nil
+	}
+
+	var apiKey, err = parseApiKey()
+	if err != nil {
+		return ForecastData{}, fmt.Errorf("could not parse api key %v", err)
+	}
+
+	requestUrl := fmt.Sprintf("%s/data/2.5/forecast?q=%s&appid=%s", weatherApiHost, location, apiKey)
+	if days > 0 {
+		requestUrl += fmt.Sprintf("&cnt=%d", days*forecastSlotsPerDay)
+	}
+
+	logger.Info("Making a forecast GET request", "location", location)
+
+	release, err := acquireUpstream(ctx)
+	if err != nil {
+		return ForecastData{}, fmt.Errorf("forecast request for %q cancelled: %w", location, err)
+	}
+	defer release()
+
+	resp, err := upstreamGet(ctx, requestUrl)
+	if err != nil {
+		if errors.Is(ctx.Err(), context.Canceled) {
+			return ForecastData{}, fmt.Errorf("forecast request for %q cancelled: %w", location, ctx.Err())
+		}
+		return ForecastData{}, fmt.Errorf("failed to fetch forecast data: %v", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return ForecastData{}, fmt.Errorf("forecast API request failed with %w", upstreamStatusError(resp.StatusCode))
+	}
+
+	body, err := readUpstreamBody(resp.Body)
+	if err != nil {
+		return ForecastData{}, fmt.Errorf("failed to read forecast data: %w", err)
+	}
+
+	if err := checkNotEmpty(body); err != nil {
+		return ForecastData{}, err
+	}
+
+	if err := checkContentType(resp.Header.Get("Content-Type"), body); err != nil {
+		return ForecastData{}, err
+	}
+
+	forecastData := ForecastData{}
+	err = json.Unmarshal(body, &forecastData)
+	if err != nil {
+		return ForecastData{}, describeDecodeError(err, body)
+	}
+
+	return forecastData, nil
+}
+
+// forecastResponse flattens the forecast into the JSON returned to clients, one entry per 3 hour slot,
+// with the temperatures in the given display, see setTemperature.
+func forecastResponse(forecastData ForecastData, display string) gin.H {
+	entries := make([]gin.H, 0, len(forecastData.List))
+
+	for _, entry := range forecastData.List {
+		description := ""
+		if len(entry.Weather) > 0 {
+			description = entry.Weather[0].Description
+		}
+
+		response := gin.H{
+			"time":        entry.Dt,
+			"description": description,
+		}
+		setTemperature(response, "temperature", entry.Main.Temp, display)
+		entries = append(entries, response)
+	}
+
+	return gin.H{
+		"city":     forecastData.City.Name,
+		"country":  forecastData.City.Country,
+		"forecast": entries,
+	}
+}
+
+// shortForecastSlots is the number of 3 hour slots in the short forecast, the next 12 hours.
+const shortForecastSlots = 4
+
+// includeForecast reads the include_forecast query parameter, false when it is missing.
+func includeForecast(ctx *gin.Context) (bool, error) {
+	value := ctx.Query("include_forecast")
+	if value == "" {
+		return false, nil
+	}
+
+	include, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("include_forecast must be true or false")
+	}
+
+	return include, nil
+}
+
+// fetchShortForecast fetches the forecast of the next few hours for location, as the entries of forecastResponse
+// in the given display, abandoning the request once ctx is cancelled.
+func fetchShortForecast(ctx context.Context, location string, display string) ([]gin.H, error) {
+	forecastData, err := instrumentedSendForecastRequest(ctx, location, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	forecastData.List = forecastData.List[:min(len(forecastData.List), shortForecastSlots)]
+	return forecastResponse(forecastData, display)["forecast"].([]gin.H), nil
+}
+
+// forecastSlot is the interval between two entries of the upstream forecast.
+const forecastSlot = 3 * time.Hour
+
+// forecastTime reads the at query parameter, an RFC 3339 time to get the forecast for instead of the
+// current weather. It reports false when at is missing, and rejects a time outside the forecast
+// window, from now to WeatherConfig.ForecastHorizonDays ahead.
+func forecastTime(ctx *gin.Context, now time.Time) (time.Time, bool, error) {
+	value := ctx.Query("at")
+	if value == "" {
+		return time.Time{}, false, nil
+	}
+
+	at, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("at must be an RFC 3339 time")
+	}
+
+	horizon := time.Duration(config.ForecastHorizonDays) * 24 * time.Hour
+	if at.Before(now) || at.After(now.Add(horizon)) {
+		return time.Time{}, false, fmt.Errorf("at must be within the next %d days", config.ForecastHorizonDays)
+	}
+
+	return at, true, nil
+}
+
+// nearestForecastEntry returns the entry whose slot is closest to at, false when there is none
+// within half a slot of it, which happens when the upstream returned a shorter forecast.
+func nearestForecastEntry(entries []ForecastEntry, at time.Time) (ForecastEntry, bool) {
+	var nearest ForecastEntry
+	best := time.Duration(-1)
+
+	for _, entry := range entries {
+		distance := at.Sub(time.Unix(int64(entry.Dt), 0)).Abs()
+		if best < 0 || distance < best {
+			nearest, best = entry, distance
+		}
+	}
+
+	return nearest, best >= 0 && best <= forecastSlot/2
+}
+
+// forecastAtResponse is the response of /weather for a time in the future, the forecast slot nearest to it.
+func forecastAtResponse(forecastData ForecastData, entry ForecastEntry, display string) gin.H {
+	description := ""
+	if len(entry.Weather) > 0 {
+		description = entry.Weather[0].Description
+	}
+
+	response := gin.H{
+		"city":        forecastData.City.Name,
+		"country":     forecastData.City.Country,
+		"time":        entry.Dt,
+		"description": description,
+		"forecast":    true,
+	}
+	setTemperature(response, "temperature", entry.Main.Temp, display)
+
+	return response
+}
+
+// getWeatherAt responds with the forecast slot of city nearest to at, see getWeatherInternational.
+func getWeatherAt(ctx *gin.Context, city string, at time.Time, display string) {
+	forecastData, err := instrumentedSendForecastRequest(ctx.Request.Context(), city, 0)
+	if err != nil {
+		logger.Error("Error fetching forecast data", "city", city, "error", err)
+		ctx.JSON(http.StatusInternalServerError, failedFetchResponse("Failed to fetch forecast data", err))
+		return
+	}
+
+	entry, ok := nearestForecastEntry(forecastData.List, at)
+	if !ok {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "No forecast is available for the requested time"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, forecastAtResponse(forecastData, entry, display))
+}
+
+// forecastDays reads the days query parameter, the number of days of forecast requested.
+// A missing days returns 0, the whole forecast, and anything beyond WeatherConfig.ForecastHorizonDays is rejected.
+func forecastDays(ctx *gin.Context) (int, error) {
+	value := ctx.Query("days")
+	if value == "" {
+		return 0, nil
+	}
+
+	days, err := strconv.Atoi(value)
+	if err != nil || days < 1 || days > config.ForecastHorizonDays {
+		return 0, fmt.Errorf("days must be between 1 and %d", config.ForecastHorizonDays)
+	}
+
+	return days, nil
+}
+
+// getForecast retrieves the 5 day forecast for the location given in the path.
+//
+// Parameters:
+// ctx (gin.Context): The Gin context containing request and response objects. The location is extracted from the "location" parameter,
+// and the optional days query parameter limits the forecast to that many days, up to the configured horizon.
+//
+// Return:
+// None. The function responds with the forecast as JSON, an HTTP 400 status code for days beyond the horizon,
+// or an HTTP 500 status code if the fetch fails.
+func getForecast(ctx *gin.Context) {
+
+	city := ctx.Param("location")
+
+	days, err := forecastDays(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	forecastData, err := instrumentedSendForecastRequest(ctx.Request.Context(), city, days)
+	if err != nil {
+		logger.Error("Error fetching forecast data", "city", city, "error", err)
+		ctx.JSON(http.StatusInternalServerError, failedFetchResponse("Failed to fetch forecast data", err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, forecastResponse(forecastData, displayStandard))
+
+}
+
+type forecastResult struct {
+	index    int
+	location string
+	data     ForecastData
+	err      error
+}
+
+/*
+getForecastBatch fetches the 5 day forecast for every city in the request body concurrently,
+for clients planning trips to several destinations at once.
+
+Parameters:
+- ctx: The Gin context used to handle the HTTP request and response. The body is a JSON
+object of the form {"cities": ["London", "Paris"]}.
+
+The results are returned in request order. A city whose forecast cannot be fetched is
+reported in place with an error field. The number of concurrent upstream calls is capped
+by the shared upstream slots.
+*/
+func getForecastBatch(ctx *gin.Context) {
+
+	var request batchRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid batch request body"})
+		return
+	}
+
+	if len(request.Cities) == 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "At least one city is required"})
+		return
+	}
+
+	if len(request.Cities) > maxBatchCities {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d cities are allowed per batch", maxBatchCities)})
+		return
+	}
+
+	channel := make(chan forecastResult, len(request.Cities))
+	requestCtx := ctx.Request.Context()
+
+	for i, city := range request.Cities {
+		go func(i int, city string) {
+			data, err := instrumentedSendForecastRequest(requestCtx, city, 0)
+			channel <- forecastResult{index: i, location: city, data: data, err: err}
+		}(i, city)
+	}
+
+	batchResponse := make([]gin.H, len(request.Cities))
+
+	for range request.Cities {
+
+		result := <-channel
+
+		if result.err != nil {
+			logger.Error("Forecast fetch failed", "city", result.location, "error", result.err)
+			batchResponse[result.index] = gin.H{
+				"city":  result.location,
+				"error": "Failed to fetch forecast data",
+			}
+			continue
+		}
+
+		batchResponse[result.index] = forecastResponse(result.data, displayStandard)
+	}
+
+	ctx.JSON(http.StatusOK, batchResponse)
+
+}
+
+func instrumentedSendForecastRequest(ctx context.Context, location string, days int) (ForecastData, error) {
+	ctx, span := tracer.Start(ctx, "sendForecastRequest")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("location", location),
+		attribute.Int("days", days),
+	)
+
+	start := time.Now()
+	weatherRequestCounter.Add(ctx, 1,
+		metric.WithAttributes(attribute.Key("endpoint").String("sendForecastRequest")))
+	data, err := sendForecastRequest(ctx, location, days)
+	duration := time.Since(start).Seconds()
+	weatherRequestDuration.Record(ctx, duration,
+		metric.WithAttributes(attribute.Key("endpoint").String("sendForecastRequest")))
+
+	if err != nil {
+		recordUpstreamError(ctx, span, "sendForecastRequest", err)
+	}
+
+	return data, err
+}
+
+func instrumentedGetForecast(ctx *gin.Context) {
+	traceCtx, span := tracer.Start(ctx.Request.Context(), "getForecast")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("location", ctx.Param("location")),
+		attribute.String("method", ctx.Request.Method),
+		attribute.String("path", ctx.Request.URL.Path),
+	)
+
+	start := time.Now()
+	weatherRequestCounter.Add(traceCtx, 1,
+		metric.WithAttributes(attribute.Key("endpoint").String("getForecast")))
+	getForecast(ctx)
+	duration := time.Since(start).Seconds()
+	weatherRequestDuration.Record(traceCtx, duration,
+		metric.WithAttributes(attribute.Key("endpoint").String("getForecast")))
+
+	span.SetAttributes(attribute.Int("http.status_code", ctx.Writer.Status()))
+}
+
+func instrumentedGetForecastBatch(ctx *gin.Context) {
+	traceCtx, span := tracer.Start(ctx.Request.Context(), "getForecastBatch")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("method", ctx.Request.Method),
+		attribute.String("path", ctx.Request.URL.Path),
+	)
+
+	start := time.Now()
+	weatherRequestCounter.Add(traceCtx, 1,
+		metric.WithAttributes(attribute.Key("endpoint").String("getForecastBatch")))
+	getForecastBatch(ctx)
+	duration := time.Since(start).Seconds()
+	weatherRequestDuration.Record(traceCtx, duration,
+		metric.WithAttributes(attribute.Key("endpoint").String("getForecastBatch")))
+
+	span.SetAttributes(attribute.Int("http.status_code", ctx.Writer.Status()))
+}
@@ -0,0 +1,244 @@
+package weather
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// serveCannedForecast mimics the OpenWeatherMap 5 day / 3 hour forecast endpoint, the
+// temperature rising by one degree every slot. The cnt parameter limits the number of slots.
+func serveCannedForecast(w http.ResponseWriter, r *http.Request) {
+	city := r.URL.Query().Get("q")
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if city == "" || city == "Atlantis" {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"cod":"404","message":"city not found"}`)
+		return
+	}
+
+	start := time.Date(2025, 4, 13, 15, 0, 0, 0, time.UTC)
+
+	slots := 40
+	if cnt, err := strconv.Atoi(r.URL.Query().Get("cnt")); err == nil {
+		slots = min(cnt, slots)
+	}
+
+	entries := make([]string, 0, slots)
+	for i := 0; i < slots; i++ {
+		dt := start.Add(time.Duration(i) * 3 * time.Hour)
+		entries = append(entries, fmt.Sprintf(`{"dt":%d,"main":{"temp":%d},"weather":[{"id":500,"main":"Rain","description":"light rain"}],"dt_txt":%q}`,
+			dt.Unix(), 10+i, dt.Format(time.DateTime)))
+	}
+
+	fmt.Fprintf(w, `{"cod":"200","cnt":%d,"list":[%s],"city":{"name":%q,"country":"XX","timezone":0}}`,
+		slots, strings.Join(entries, ","), city)
+}
+
+func newForecastBatchContext(t *testing.T, cities []string) (*gin.Context, *httptest.ResponseRecorder) {
+	t.Helper()
+
+	body, _ := json.Marshal(batchRequest{Cities: cities})
+
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request, _ = http.NewRequest(http.MethodPost, "/forecast/batch", bytes.NewReader(body))
+	ctx.Request.Header.Set("Content-Type", "application/json")
+
+	return ctx, w
+}
+
+func TestForecastResponse(t *testing.T) {
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Params = []gin.Param{{Key: "location", Value: "Lisbon"}}
+	ctx.Request, _ = http.NewRequest(http.MethodGet, "/forecast/Lisbon", nil)
+
+	instrumentedGetForecast(ctx)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var data struct {
+		City     string           `json:"city"`
+		Forecast []map[string]any `json:"forecast"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+		t.Fatalf("Error unmarshalling JSON response: %v", err)
+	}
+
+	if data.City != "Lisbon" || len(data.Forecast) != 40 {
+		t.Errorf("Expected 40 slots for Lisbon, got %d for %q", len(data.Forecast), data.City)
+	}
+	if data.Forecast[0]["description"] != "light rain" {
+		t.Errorf("Unexpected first slot %v", data.Forecast[0])
+	}
+}
+
+func TestForecastDaysWithinHorizon(t *testing.T) {
+	request := func(days string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		ctx, _ := gin.CreateTestContext(w)
+		ctx.Params = []gin.Param{{Key: "location", Value: "Lisbon"}}
+		ctx.Request, _ = http.NewRequest(http.MethodGet, "/forecast/Lisbon?days="+days, nil)
+		getForecast(ctx)
+		return w
+	}
+
+	w := request("2")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var data struct {
+		Forecast []map[string]any `json:"forecast"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+		t.Fatalf("Error unmarshalling JSON response: %v", err)
+	}
+	if len(data.Forecast) != 2*forecastSlotsPerDay {
+		t.Errorf("Expected %d slots for 2 days, got %d", 2*forecastSlotsPerDay, len(data.Forecast))
+	}
+
+	for _, days := range []string{"0", "6", "-1", "two"} {
+		if w := request(days); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d for days=%s, got %d", http.StatusBadRequest, days, w.Code)
+		}
+	}
+}
+
+func TestNearestForecastEntry(t *testing.T) {
+	start := time.Date(2025, 4, 13, 15, 0, 0, 0, time.UTC)
+	entries := make([]ForecastEntry, 8)
+	for i := range entries {
+		entries[i] = ForecastEntry{Dt: int(start.Add(time.Duration(i) * forecastSlot).Unix())}
+	}
+
+	tests := []struct {
+		at   time.Time
+		slot int
+	}{
+		{start, 0},
+		{start.Add(80 * time.Minute), 0},
+		{start.Add(100 * time.Minute), 1},
+		{start.Add(7 * time.Hour), 2},
+		{start.Add(22*time.Hour + 30*time.Minute), 7},
+	}
+
+	for _, tt := range tests {
+		entry, ok := nearestForecastEntry(entries, tt.at)
+		if !ok || entry.Dt != entries[tt.slot].Dt {
+			t.Errorf("Expected slot %d for %s, got %v (%v)", tt.slot, tt.at.Format(time.RFC3339), entry.Dt, ok)
+		}
+	}
+
+	if _, ok := nearestForecastEntry(entries, start.Add(2*24*time.Hour)); ok {
+		t.Errorf("Expected no slot past the end of the forecast")
+	}
+}
+
+func TestWeatherAtWithinForecastWindow(t *testing.T) {
+	startMockUpstream(t, serveCannedForecast)
+
+	request := func(at time.Time) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		ctx, _ := gin.CreateTestContext(w)
+		ctx.Params = []gin.Param{{Key: "location", Value: "Lisbon"}}
+		ctx.Request, _ = http.NewRequest(http.MethodGet, "/weather/Lisbon?at="+url.QueryEscape(at.Format(time.RFC3339)), nil)
+		getWeatherInternational(ctx)
+		return w
+	}
+
+	now := time.Now()
+	for _, at := range []time.Time{now.Add(-time.Hour), now.Add(6 * 24 * time.Hour)} {
+		if w := request(at); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d for at=%s, got %d", http.StatusBadRequest, at.Format(time.RFC3339), w.Code)
+		}
+	}
+
+	// The canned forecast lies in the past, no slot is near a time in the window
+	if w := request(now.Add(time.Hour)); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d without a nearby slot, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestForecastBatchResponse(t *testing.T) {
+	ctx, w := newForecastBatchContext(t, []string{"Lisbon", "Atlantis", "Vienna"})
+
+	instrumentedGetForecastBatch(ctx)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var data []struct {
+		City     string           `json:"city"`
+		Error    string           `json:"error"`
+		Forecast []map[string]any `json:"forecast"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+		t.Fatalf("Error unmarshalling JSON response: %v", err)
+	}
+
+	if len(data) != 3 {
+		t.Fatalf("Expected 3 results, got %d", len(data))
+	}
+	if data[0].City != "Lisbon" || len(data[0].Forecast) != 40 {
+		t.Errorf("Unexpected first result %+v", data[0])
+	}
+	if data[1].City != "Atlantis" || data[1].Error == "" {
+		t.Errorf("Expected an error for Atlantis, got %+v", data[1])
+	}
+	if data[2].City != "Vienna" || len(data[2].Forecast) != 40 {
+		t.Errorf("Unexpected third result %+v", data[2])
+	}
+}
+
+// TestForecastBatchRespectsUpstreamSlots checks that a batch never has more upstream calls
+// in flight than there are upstream slots.
+func TestForecastBatchRespectsUpstreamSlots(t *testing.T) {
+	previous := upstreamSlots
+	upstreamSlots = make(chan struct{}, 2)
+	t.Cleanup(func() { upstreamSlots = previous })
+
+	var mutex sync.Mutex
+	inFlight, peak := 0, 0
+
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		inFlight++
+		peak = max(peak, inFlight)
+		mutex.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mutex.Lock()
+		inFlight--
+		mutex.Unlock()
+
+		serveCannedForecast(w, r)
+	})
+
+	ctx, w := newForecastBatchContext(t, []string{"Lisbon", "Vienna", "Madrid", "Oslo", "Rome", "Dublin"})
+	instrumentedGetForecastBatch(ctx)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if peak > 2 {
+		t.Errorf("Expected at most 2 concurrent upstream calls, got %d", peak)
+	}
+}
@@ -5,9 +5,14 @@ package weather
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"maps"
+	"math"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -17,6 +22,9 @@ import (
 	"go.opentelemetry.io/otel/metric"
 )
 
+// weatherApiHost is the OpenWeatherMap API host, overridden in tests to point at a mock upstream.
+var weatherApiHost = "http://api.openweathermap.org"
+
 type Coordinates struct {
 	Longitude float64 `json:"lon"`
 	Latitude  float64 `json:"lat"`
@@ -29,15 +37,17 @@ type Weather struct {
 	Icon        string `json:"icon"`
 }
 
+// Main holds the main readings of the upstream. TempMin, TempMax and FeelsLike are nil when the
+// upstream did not report them, as 0 is a valid temperature in metric and imperial units.
 type Main struct {
-	Temp      float64 `json:"temp"`
-	TempMin   float64 `json:"temp_min"`
-	TempMax   float64 `json:"temp_max"`
-	FeelsLike float64 `json:"feels_like"`
-	Pressure  float64 `json:"pressure"`
-	SeaLevel  float64 `json:"sea_level"`
-	GrndLevel float64 `json:"grnd_level"`
-	Humidity  int     `json:"humidity"`
+	Temp      float64  `json:"temp"`
+	TempMin   *float64 `json:"temp_min"`
+	TempMax   *float64 `json:"temp_max"`
+	FeelsLike *float64 `json:"feels_like"`
+	Pressure  float64  `json:"pressure"`
+	SeaLevel  float64  `json:"sea_level"`
+	GrndLevel float64  `json:"grnd_level"`
+	Humidity  int      `json:"humidity"`
 }
 
 type Wind struct {
@@ -84,52 +94,447 @@ type WeatherData struct {
 	Name       string      `json:"name"`
 	Cod        int         `json:"cod"`
 	Timezone   int         `json:"timezone"`
+
+	// Name of the fallback provider that served the data because the primary failed, empty otherwise
+	FallbackProvider string `json:"-"`
 }
 
 // sendWeatherRequest sends a GET request to the WeatherStack API to fetch the current weather data for a specified location.
 //
+// The upstream is queried in the given units, defaultUnits when empty, and the data it returns is
+// brought back to the standard units, see toStandardUnits.
+//
 // Parameters:
 // location (string): The international location for which to fetch the weather data.
+// units (string): The OpenWeatherMap unit system to query the upstream in: standard, metric or imperial.
 //
 // Return:
 // WeatherData: A struct containing the parsed weather data.
 // error: An error if any occurred during the request or response processing.
-func sendWeatherRequest(location string) (WeatherData, error) {
+func sendWeatherRequest(location string, units string) (WeatherData, error) {
+	return sendWeatherRequestContext(context.Background(), location, units)
+}
+
+// sendWeatherRequestContext is sendWeatherRequest bound to ctx.
+//
+// The request times out after the upstream client timeout, WeatherConfig.RequestTimeout by default, unless ctx carries
+// a deadline, which then replaces the default so callers can allow a slow location more time.
+// Once ctx is cancelled, as when the client of a handler goes away, the request is abandoned and
+// the returned error wraps context.Canceled.
+func sendWeatherRequestContext(ctx context.Context, location string, units string) (WeatherData, error) {
+	if config.DemoMode {
+		return DemoProvider{}.CurrentWeather(ctx, location)
+	}
+
 	var apiKey, err = parseApiKey()
 	if err != nil {
 		return WeatherData{}, fmt.Errorf("could not parse api key %v", err)
 	}
 
-	client := http.Client{Timeout: time.Duration(200) * time.Millisecond}
-
-	requestUrl := fmt.Sprintf("http://api.openweathermap.org/data/2.5/weather?q=%s&appid=%s", location, apiKey)
+	if units == "" {
+		units = defaultUnits
+	}
 
-	logger.Info("Making a GET request", "url", requestUrl)
+	requestUrl := fmt.Sprintf("%s/data/2.5/weather?q=%s&appid=%s&units=%s", weatherApiHost, location, apiKey, units)
 
-	resp, err := client.Get(requestUrl)
+	logger.Info("Making a GET request", "location", location, "units", units)
 
-	logger.Info("API response received", "status", resp)
+	release, err := acquireUpstream(ctx)
+	if err != nil {
+		return WeatherData{}, fmt.Errorf("weather request for %q cancelled: %w", location, err)
+	}
+	defer release()
 
+	resp, err := upstreamGet(ctx, requestUrl)
 	if err != nil {
+		if errors.Is(ctx.Err(), context.Canceled) {
+			return WeatherData{}, fmt.Errorf("weather request for %q cancelled: %w", location, ctx.Err())
+		}
 		if os.IsTimeout(err) {
-			return WeatherData{}, fmt.Errorf("failed to fetch weather data: %v", err)
+			return WeatherData{}, fmt.Errorf("failed to fetch weather data: %w", err)
 		}
 		return WeatherData{}, fmt.Errorf("failed to fetch weather data: %v", err)
 	}
 
+	logger.Info("API response received", "status", resp.StatusCode)
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		if body, err := readUpstreamBody(resp.Body); err == nil && isCityNotFound(body) {
+			return WeatherData{}, fmt.Errorf("weather API request for %q failed with %w: %w", location, upstreamStatusError(resp.StatusCode), ErrCityNotFound)
+		}
+	}
+
 	if resp.StatusCode != http.StatusOK {
-		return WeatherData{}, fmt.Errorf("weather API request failed to %s: %v", requestUrl, err)
+		return WeatherData{}, fmt.Errorf("weather API request failed with %w", upstreamStatusError(resp.StatusCode))
+	}
+
+	body, err := readUpstreamBody(resp.Body)
+	if err != nil {
+		return WeatherData{}, fmt.Errorf("failed to read weather data: %w", err)
+	}
+
+	if err := checkNotEmpty(body); err != nil {
+		return WeatherData{}, err
+	}
+
+	if err := checkContentType(resp.Header.Get("Content-Type"), body); err != nil {
+		return WeatherData{}, err
+	}
+
+	weatherData := WeatherData{}
+	err = json.Unmarshal(body, &weatherData)
+	if err != nil {
+		return WeatherData{}, describeDecodeError(err, body)
+	}
+
+	if err := checkMainPresent(body); err != nil {
+		return WeatherData{}, err
+	}
+
+	return toStandardUnits(weatherData, units), nil
+}
+
+// sendWeatherRequestByCoordinates sends a GET request to the OpenWeatherMap API to fetch the current weather data at a position.
+//
+// The units are handled as by sendWeatherRequest, and ctx as by sendWeatherRequestContext.
+//
+// Parameters:
+// ctx (context.Context): Cancels the request, as when the client of the handler goes away.
+// coordinates (Coordinates): The position for which to fetch the weather data.
+// units (string): The OpenWeatherMap unit system to query the upstream in: standard, metric or imperial.
+//
+// Return:
+// WeatherData: A struct containing the parsed weather data.
+// error: An error if any occurred during the request or response processing.
+func sendWeatherRequestByCoordinates(ctx context.Context, coordinates Coordinates, units string) (WeatherData, error) {
+	if config.DemoMode {
+		return DemoProvider{}.WeatherAt(coordinates), nil
+	}
+
+	var apiKey, err = parseApiKey()
+	if err != nil {
+		return WeatherData{}, fmt.Errorf("could not parse api key %v", err)
+	}
+
+	if units == "" {
+		units = defaultUnits
+	}
+
+	requestUrl := fmt.Sprintf("%s/data/2.5/weather?%s&appid=%s&units=%s", weatherApiHost, coordinates.query(), apiKey, units)
+
+	logger.Info("Making a GET request by coordinates", "lat", coordinates.Latitude, "lon", coordinates.Longitude)
+
+	release, err := acquireUpstream(ctx)
+	if err != nil {
+		return WeatherData{}, fmt.Errorf("weather request for %s cancelled: %w", coordinates.query(), err)
+	}
+	defer release()
+
+	resp, err := upstreamGet(ctx, requestUrl)
+	if err != nil {
+		if errors.Is(ctx.Err(), context.Canceled) {
+			return WeatherData{}, fmt.Errorf("weather request for %s cancelled: %w", coordinates.query(), ctx.Err())
+		}
+		return WeatherData{}, fmt.Errorf("failed to fetch weather data: %v", err)
 	}
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return WeatherData{}, fmt.Errorf("weather API request failed with %w", upstreamStatusError(resp.StatusCode))
+	}
+
+	body, err := readUpstreamBody(resp.Body)
+	if err != nil {
+		return WeatherData{}, fmt.Errorf("failed to read weather data: %w", err)
+	}
+
+	if err := checkNotEmpty(body); err != nil {
+		return WeatherData{}, err
+	}
+
+	if err := checkContentType(resp.Header.Get("Content-Type"), body); err != nil {
+		return WeatherData{}, err
+	}
+
 	weatherData := WeatherData{}
-	err = json.NewDecoder(resp.Body).Decode(&weatherData)
+	err = json.Unmarshal(body, &weatherData)
 	if err != nil {
-		return WeatherData{}, fmt.Errorf("error unmarshalling JSON response: %v", err)
+		return WeatherData{}, describeDecodeError(err, body)
+	}
+
+	if err := checkMainPresent(body); err != nil {
+		return WeatherData{}, err
+	}
+
+	return toStandardUnits(weatherData, units), nil
+}
+
+// checkMainPresent rejects a current weather payload without a main block. Decoded as is, such a
+// payload leaves WeatherData.Main zero valued and would be reported as a temperature of 0 Kelvin.
+//
+// Parameters:
+// body ([]byte): The payload, already known to decode into WeatherData.
+//
+// Return: nil when the main block is present, or an error wrapping ErrUpstreamResponse
+func checkMainPresent(body []byte) error {
+	var probe struct {
+		Main json.RawMessage `json:"main"`
+	}
+
+	if err := json.Unmarshal(body, &probe); err != nil {
+		return describeDecodeError(err, body)
+	}
+
+	if len(probe.Main) == 0 || string(probe.Main) == "null" {
+		return fmt.Errorf("%w: weather data without a main block", ErrUpstreamResponse)
 	}
 
-	return weatherData, nil
+	return nil
+}
+
+// describeDecodeError wraps a JSON decode error with the position and the field or token that failed,
+// so upstream schema drift can be diagnosed from the error alone.
+//
+// Parameters:
+// err (error): The error returned by json.Unmarshal.
+// body ([]byte): The payload that failed to decode.
+//
+// Return: the wrapped error, it also wraps ErrDecode
+func describeDecodeError(err error, body []byte) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case errors.As(err, &syntaxErr):
+		return fmt.Errorf("%w: syntax error at offset %d near %q: %w",
+			ErrDecode, syntaxErr.Offset, snippetAround(body, syntaxErr.Offset), err)
+	case errors.As(err, &typeErr):
+		return fmt.Errorf("%w: field %q expects %s but got JSON %s at offset %d: %w",
+			ErrDecode, typeErr.Field, typeErr.Type, typeErr.Value, typeErr.Offset, err)
+	}
+
+	return fmt.Errorf("%w: %w", ErrDecode, err)
+}
+
+// snippetAround returns up to 16 bytes of body on each side of offset.
+func snippetAround(body []byte, offset int64) string {
+	start := max(int(offset)-16, 0)
+	end := min(int(offset)+16, len(body))
+	if start > end {
+		start = end
+	}
+	return string(body[start:end])
+}
+
+// staleObservationWarning is the Warning header sent along an observation older than the configured threshold.
+const staleObservationWarning = `110 - "Response is Stale"`
+
+// weatherResponse builds the JSON body returned for a single location.
+//
+// Parameters:
+// weatherData (WeatherData): The weather data fetched for the location.
+// display (string): The temperature display mode, see setTemperatures.
+//
+// Return: the response body
+func weatherResponse(weatherData WeatherData, display string) gin.H {
+	response := gin.H{
+		"city":        weatherData.Name,
+		"country":     weatherData.Sys.Country,
+		"description": firstDescription(weatherData),
+	}
+
+	setTemperatures(response, weatherData.Main, display)
+	setWind(response, weatherData, display)
+	setPressure(response, weatherData.Main)
+
+	if weatherData.Main.Temp != 0 {
+		response["comfort"] = comfort(weatherData)
+	}
+
+	now := time.Now()
+
+	if isStaleObservation(weatherData, now) {
+		response["stale_observation"] = true
+	}
+
+	if weatherData.Dt != 0 {
+		response["age_seconds"] = observationAge(weatherData, now)
+	}
+
+	if weatherData.hasSunTimes() {
+		response["is_day"] = weatherData.IsDaytime()
+	}
+
+	if len(weatherData.Weather) > 0 {
+		if group := weatherData.Weather[0].ConditionGroup(); group != "" {
+			response["condition"] = group
+		}
+	}
+
+	maps.Copy(response, weatherData.Precipitation())
+
+	if config.ServedByField {
+		response["served_by"] = config.InstanceID
+	}
+
+	// Data from a fallback provider may be less reliable than the primary's
+	if weatherData.FallbackProvider != "" {
+		response["degraded"] = true
+		response["provider"] = weatherData.FallbackProvider
+	}
+
+	return response
+}
+
+// setPressure adds the atmospheric pressure at sea level and at ground level to response, in hPa
+// whatever the units, for the aviation and other users who need one or the other.
+//
+// OpenWeatherMap only reports sea_level and grnd_level for some locations. Its general pressure is
+// the sea level one, so it stands in for pressure_sea_level when sea_level is absent, while
+// pressure_ground_level is left out as it has no such substitute.
+func setPressure(response gin.H, main Main) {
+	if main.SeaLevel != 0 {
+		response["pressure_sea_level"] = main.SeaLevel
+	} else if main.Pressure != 0 {
+		response["pressure_sea_level"] = main.Pressure
+	}
+
+	if main.GrndLevel != 0 {
+		response["pressure_ground_level"] = main.GrndLevel
+	}
+}
+
+// LogValue makes WeatherData log as a group of its salient fields, the city, country, temperature
+// in Kelvin and condition group, rather than as the whole upstream struct. The condition is left
+// out when the upstream reported none.
+func (w WeatherData) LogValue() slog.Value {
+	attrs := []slog.Attr{
+		slog.String("city", w.Name),
+		slog.String("country", w.Sys.Country),
+		slog.Float64("temperature", w.Main.Temp),
+	}
+
+	if len(w.Weather) > 0 {
+		if group := w.Weather[0].ConditionGroup(); group != "" {
+			attrs = append(attrs, slog.String("condition", group))
+		}
+	}
+
+	return slog.GroupValue(attrs...)
+}
+
+// firstDescription returns the description of the first weather condition of wd, or an empty
+// string when the upstream reported none, which happens under load and used to panic the handlers.
+func firstDescription(wd WeatherData) string {
+	if len(wd.Weather) == 0 {
+		return ""
+	}
+	return wd.Weather[0].Description
+}
+
+// ConditionGroup maps the upstream condition code to its stable category: thunderstorm (2xx),
+// drizzle (3xx), rain (5xx), snow (6xx), atmosphere (7xx), clear (800) or clouds (801 to 804).
+// It returns an empty string for a code outside of these ranges.
+func (w Weather) ConditionGroup() string {
+	switch {
+	case w.ID >= 200 && w.ID < 300:
+		return "thunderstorm"
+	case w.ID >= 300 && w.ID < 400:
+		return "drizzle"
+	case w.ID >= 500 && w.ID < 600:
+		return "rain"
+	case w.ID >= 600 && w.ID < 700:
+		return "snow"
+	case w.ID >= 700 && w.ID < 800:
+		return "atmosphere"
+	case w.ID == 800:
+		return "clear"
+	case w.ID > 800 && w.ID < 900:
+		return "clouds"
+	}
+	return ""
+}
+
+// Precipitation returns the rain and snow volumes the upstream reported, keyed rain and snow, each
+// holding its populated 1h and 3h volumes in mm. A kind without any volume is left out, rather
+// than reported as zero, so clients can tell no rain from no data.
+func (w WeatherData) Precipitation() gin.H {
+	precipitation := gin.H{}
+
+	if volumes := precipitationVolumes(w.Rain.OneH, w.Rain.ThreeH); volumes != nil {
+		precipitation["rain"] = volumes
+	}
+	if volumes := precipitationVolumes(w.Snow.OneH, w.Snow.ThreeH); volumes != nil {
+		precipitation["snow"] = volumes
+	}
+
+	return precipitation
+}
+
+// precipitationVolumes keys the non-zero volumes by period, or returns nil when both are zero.
+func precipitationVolumes(oneHour, threeHours float64) gin.H {
+	if oneHour == 0 && threeHours == 0 {
+		return nil
+	}
+
+	volumes := gin.H{}
+	if oneHour != 0 {
+		volumes["1h"] = oneHour
+	}
+	if threeHours != 0 {
+		volumes["3h"] = threeHours
+	}
+	return volumes
+}
+
+// hasSunTimes reports whether the upstream gave both a sunrise and a sunset, the polar day and night
+// and some minimal responses give neither.
+func (w WeatherData) hasSunTimes() bool {
+	return w.Sys.Sunrise != 0 && w.Sys.Sunset != 0
+}
+
+// IsDaytime reports whether the observation time falls between sunrise, included, and sunset, excluded.
+// The current time stands in for a missing observation time, and it is always false when
+// the sunrise or the sunset is missing.
+func (w WeatherData) IsDaytime() bool {
+	if !w.hasSunTimes() {
+		return false
+	}
+
+	observed := int64(w.Dt)
+	if observed == 0 {
+		observed = time.Now().Unix()
+	}
+
+	return observed >= int64(w.Sys.Sunrise) && observed < int64(w.Sys.Sunset)
+}
+
+// observationAge returns how many whole seconds old the observation is at now, clamped to 0 when
+// the upstream clock is ahead of ours.
+func observationAge(weatherData WeatherData, now time.Time) int64 {
+	return max(now.Unix()-int64(weatherData.Dt), 0)
+}
+
+// isStaleObservation reports whether the observation is older than WeatherConfig.StaleObservationAge.
+//
+// Dt is a Unix timestamp, so it is compared to the current time directly: the city's timezone
+// offset only matters when displaying local times, not when computing an age.
+//
+// Parameters:
+// weatherData (WeatherData): The weather data to check.
+// now (time.Time): The current time.
+//
+// Return: true if the observation is stale, always false when the check is disabled or Dt is missing
+func isStaleObservation(weatherData WeatherData, now time.Time) bool {
+	if config.StaleObservationAge <= 0 || weatherData.Dt == 0 {
+		return false
+	}
+
+	observed := time.Unix(int64(weatherData.Dt), 0)
+	return now.Sub(observed) > config.StaleObservationAge
 }
 
 // getWeatherInternational retrieves the current weather data for a specified international location using the WeatherStack API.
@@ -139,32 +544,67 @@ func sendWeatherRequest(location string) (WeatherData, error) {
 //
 // Parameters:
 // ctx (gin.Context): The Gin context containing request and response objects. The location is extracted from the "location" parameter.
+// The optional "display" query parameter selects the temperature scale: standard (Kelvin), celsius (the default), fahrenheit or both.
+// The OpenWeatherMap "units" query parameter (standard, metric or imperial) selects the units the upstream is queried in, and the
+// temperature scale when display is absent, see parseTemperatureDisplay. A missing or unsupported unit falls back to metric, and
+// the units used are returned in the "units" field.
+// The optional "at" query parameter, an RFC 3339 time within the forecast window, returns the forecast slot nearest to it instead of the current weather.
 //
 // Return:
 // None. The function responds with an HTTP status code and a JSON object containing the weather data for the specified location.
+// If the upstream does not know the location, an HTTP 404 status code is returned with a hint to check its spelling.
 // If an error occurs during the request or response processing, an HTTP 500 status code is returned with an error message in the response body.
 func getWeatherInternational(ctx *gin.Context) {
 
 	city := ctx.Param("location")
 
+	units := parseUnits(ctx)
+
+	display, err := parseTemperatureDisplay(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	at, ok, err := forecastTime(ctx, time.Now())
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if ok {
+		getWeatherAt(ctx, city, at, display)
+		return
+	}
+
 	logger.Info("Processing city parameter", "city", city)
 
-	weatherData, err := instrumentedSendWeatherRequest(city)
+	weatherData, err := fetchWeatherSharedContext(ctx.Request.Context(), city, units, 0)
 
+	if errors.Is(err, ErrCityNotFound) {
+		ctx.JSON(http.StatusNotFound, cityNotFoundResponse(city))
+		return
+	}
 	if err != nil {
 		logger.Error("Error fetching weather data", "error", err)
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch weather data"})
+		ctx.JSON(http.StatusInternalServerError, failedFetchResponse("Failed to fetch weather data", err))
 		return
 	}
 
-	logger.Info("Weather data retrieved", "city", weatherData.Name)
+	logger.Info("Weather data retrieved", "weather", weatherData)
 
-	ctx.JSON(http.StatusOK, gin.H{
-		"city":        weatherData.Name,
-		"country":     weatherData.Sys.Country,
-		"temperature": fmt.Sprint(weatherData.Main.Temp),
-		// "description": weatherData.Weather[0].Description,
-	})
+	if notModifiedSince(ctx, weatherData) {
+		ctx.AbortWithStatus(http.StatusNotModified)
+		return
+	}
+
+	if isStaleObservation(weatherData, time.Now()) {
+		ctx.Header("Warning", staleObservationWarning)
+	}
+
+	response := weatherResponse(weatherData, display)
+	response["units"] = units
+
+	ctx.JSON(http.StatusOK, response)
 
 }
 
@@ -175,48 +615,113 @@ func getWeatherInternational(ctx *gin.Context) {
 // If an error occurs, it logs the error and returns an HTTP 500 status code with an error message in the response body.
 // If the request is successful, it decodes the JSON response and returns the weather data in the response body.
 //
+// With WeatherConfig.LocalCacheOnly, the last value fetched by refreshLocalWeather is served instead,
+// and an HTTP 503 status code is returned until the first background fetch has succeeded.
+//
+// With include_forecast=true, the forecast of the next few hours is fetched alongside the current
+// weather and added as forecast. A failed forecast fetch does not fail the request, the current
+// weather is returned with a forecast_error note instead.
+//
 // Parameters:
-// ctx (gin.Context): The Gin context containing request and response objects.
+// ctx (gin.Context): The Gin context containing request and response objects. The optional "display" query parameter selects the temperature scale.
+// The optional "units" query parameter is forwarded upstream and returned in the "units" field, as for getWeatherInternational.
 //
 // Return: weather data for the current location as a JSON string
 // None
 func getWeatherLocal(ctx *gin.Context) {
 
-	city := "Sydney"
-
-	logger.Info("Fetching local weather", "city", city)
+	city := localCity
+	units := parseUnits(ctx)
 
-	weatherData, err := instrumentedSendWeatherRequest(city)
+	display, err := parseTemperatureDisplay(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
+	include, err := includeForecast(ctx)
 	if err != nil {
-		logger.Error("Error fetching weather data", "error", err)
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch weather data"})
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	logger.Info("Weather data retrieved", "city", weatherData.Name)
+	// The forecast is fetched concurrently with the current weather and merged in once both are done
+	var forecastWait sync.WaitGroup
+	var forecast []gin.H
+	var forecastErr error
+	if include {
+		forecastWait.Add(1)
+		go func() {
+			defer forecastWait.Done()
+			forecast, forecastErr = fetchShortForecast(ctx.Request.Context(), city, display)
+		}()
+	}
 
-	ctx.JSON(http.StatusOK, gin.H{
-		"city":        weatherData.Name,
-		"country":     weatherData.Sys.Country,
-		"temperature": fmt.Sprint(weatherData.Main.Temp),
-		// "description": weatherData.Weather[0].Description,
-	})
+	var weatherData WeatherData
+
+	if config.LocalCacheOnly {
+		last := lastLocalWeather.Load()
+		if last == nil {
+			ctx.JSON(http.StatusServiceUnavailable, gin.H{"error": "Local weather is not available yet"})
+			return
+		}
+		weatherData = *last
+	} else {
+		logger.Info("Fetching local weather", "city", city)
+
+		weatherData, err = fetchWeatherSharedContext(ctx.Request.Context(), city, units, 0)
+
+		if errors.Is(err, ErrCityNotFound) {
+			ctx.JSON(http.StatusNotFound, cityNotFoundResponse(city))
+			return
+		}
+		if err != nil {
+			logger.Error("Error fetching weather data", "error", err)
+			ctx.JSON(http.StatusInternalServerError, failedFetchResponse("Failed to fetch weather data", err))
+			return
+		}
+	}
+
+	logger.Info("Weather data retrieved", "weather", weatherData)
+
+	if notModifiedSince(ctx, weatherData) {
+		ctx.AbortWithStatus(http.StatusNotModified)
+		return
+	}
+
+	if isStaleObservation(weatherData, time.Now()) {
+		ctx.Header("Warning", staleObservationWarning)
+	}
+
+	response := weatherResponse(weatherData, display)
+	response["units"] = units
+
+	if include {
+		forecastWait.Wait()
+		if forecastErr != nil {
+			logger.Warn("Error fetching the short forecast", "city", city, "error", forecastErr)
+			response["forecast_error"] = "Failed to fetch forecast data"
+		} else {
+			response["forecast"] = forecast
+		}
+	}
+
+	ctx.JSON(http.StatusOK, response)
 
 }
 
-func stressTestHelper0(location string, sq *SharedQueue) error {
+func stressTestHelper0(ctx context.Context, location string, units string, sq *SharedQueue[WeatherData]) error {
 
-	weatherData, err := instrumentedSendWeatherRequest(location)
+	weatherData, err := instrumentedSendWeatherRequestContext(ctx, location, units)
 
 	if err != nil {
-		logger.Info("Pushing empty data due to error", "location", location)
+		stressLogger.Info("Pushing empty data due to error", "location", location)
 		sq.Push(weatherData)
-		logger.Error("Error fetching weather data", "location", location, "error", err)
+		stressLogger.Error("Error fetching weather data", "location", location, "error", err)
 		return err
 	}
 
-	logger.Info("Pushing weather data", "location", location)
+	stressLogger.Info("Pushing weather data", "location", location)
 	sq.Push(weatherData)
 
 	return nil
@@ -233,9 +738,12 @@ Parameters:
 - ctx: The Gin context used to handle the HTTP request and response.
 
 The function logs the weather data for each city and sends a JSON response with
-the city name, country, temperature, and weather description.
+the city name, country, temperature, and weather description. The optional units
+query parameter selects the units the upstream is queried and the temperatures returned in.
 */
 func getWeatherStressTest0(ctx *gin.Context) {
+	units := parseUnits(ctx)
+
 	var wg sync.WaitGroup
 
 	cities := []string{"Bengaluru", "New%20York", "Tokyo", "London", "Paris", "Sydney", "Berlin", "Moscow", "Cairo", "Rio%20de%20Janeiro", "Miami", "Sao%20Paulo", "Madrid", "Barcelona", "Lisbon", "Vienna", "Buenos%20Aires", "Bangkok", "Singapore", "San%20Francisco", "Shanghai", "Mumbai", "Hong%20Kong"}
@@ -247,22 +755,29 @@ func getWeatherStressTest0(ctx *gin.Context) {
 	// 	result = append(result, cities...)
 	// }
 
-	sq := &SharedQueue{}
+	sq := &SharedQueue[WeatherData]{}
+	requestCtx := ctx.Request.Context()
 
 	for _, city := range cities {
 		wg.Add(1)
 		go func(city string) {
 			defer wg.Done()
-			err := stressTestHelper0(city, sq)
+			err := stressTestHelper0(requestCtx, city, units, sq)
 			if err != nil {
-				logger.Error("Weather fetch failed", "city", city)
+				stressLogger.Error("Weather fetch failed", "city", city)
 			}
 		}(city)
 	}
 
-	// Barrier: Block until all goroutines are done, then continue, will block on long running goroutines
+	// Barrier: Block until all goroutines are done, then continue, will block on long running goroutines.
+	// A cancelled request aborts the fetches in flight, so the barrier lifts right away.
 	wg.Wait()
 
+	if requestCtx.Err() != nil {
+		logger.Info("Client went away, dropping stress test 0 results")
+		return
+	}
+
 	var stressResponse []gin.H
 
 	logger.Info("Processing stress test 0 results")
@@ -271,35 +786,36 @@ func getWeatherStressTest0(ctx *gin.Context) {
 		stressResponse = append(stressResponse, gin.H{
 			"city":        data.Name,
 			"country":     data.Sys.Country,
-			"temperature": fmt.Sprint(data.Main.Temp),
-			// "description": data.Weather[0].Description,
+			"temperature": displayTemperature(data.Main.Temp, unitsDisplays[units]),
+			"description": firstDescription(data),
 		})
 
-		logger.Info("Result", "city", data.Name, "country", data.Sys.Country, "temperature", fmt.Sprint(data.Main.Temp))
+		stressLogger.Info("Result", "weather", data)
 	}
 
 	ctx.JSON(http.StatusOK, stressResponse)
 
 }
 
-func stressTestHelper1(location string, c chan WeatherData) error {
+func stressTestHelper1(ctx context.Context, location string, units string, c chan WeatherData) error {
 
-	weatherData, err := instrumentedSendWeatherRequest(location)
+	weatherData, err := instrumentedSendWeatherRequestContext(ctx, location, units)
 
 	if err != nil {
 		c <- weatherData
-		logger.Info("Pushing empty data due to error", "location", location)
-		logger.Error("Error fetching weather data", "location", location, "error", err)
+		stressLogger.Info("Pushing empty data due to error", "location", location)
+		stressLogger.Error("Error fetching weather data", "location", location, "error", err)
 		return err
 	}
 
-	logger.Info("Pushing weather data", "location", location)
+	stressLogger.Info("Pushing weather data", "location", location)
 	c <- weatherData
 	return nil
 
 }
 
 func getWeatherStressTest1(ctx *gin.Context) {
+	units := parseUnits(ctx)
 
 	cities := []string{"Bengaluru", "New%20York", "Tokyo", "London", "Paris", "Sydney", "Berlin", "Moscow", "Cairo", "Rio%20de%20Janeiro", "Miami", "Sao%20Paulo", "Madrid", "Barcelona", "Lisbon", "Vienna", "Buenos%20Aires", "Bangkok", "Singapore", "San%20Francisco", "Shanghai", "Mumbai", "Hong%20Kong"}
 
@@ -310,14 +826,16 @@ func getWeatherStressTest1(ctx *gin.Context) {
 	// 	result = append(result, cities...)
 	// }
 
+	// Buffered for every city, so the producers never block once the consumer has given up.
+	// It is never closed: a producer may still be sending after a cancelled request returned.
 	channel := make(chan WeatherData, len(cities))
-	defer close(channel)
+	requestCtx := ctx.Request.Context()
 
 	for _, city := range cities {
 		go func(city string) {
-			err := stressTestHelper1(city, channel)
+			err := stressTestHelper1(requestCtx, city, units, channel)
 			if err != nil {
-				logger.Error("Weather fetch failed", "city", city)
+				stressLogger.Error("Weather fetch failed", "city", city)
 			}
 		}(city)
 	}
@@ -330,34 +848,40 @@ func getWeatherStressTest1(ctx *gin.Context) {
 		// CSP Advanatage: No barrier, all the channel slots are polled for data and all
 		// the goroutines which are done are processed immediately and other long running
 		// goroutines don't block while fetching the results
-		data := <-channel
+		var data WeatherData
+		select {
+		case data = <-channel:
+		case <-requestCtx.Done():
+			logger.Info("Client went away, abandoning stress test 1")
+			return
+		}
 
 		stressResponse = append(stressResponse, gin.H{
 			"city":        data.Name,
 			"country":     data.Sys.Country,
-			"temperature": fmt.Sprint(data.Main.Temp),
-			// "description": data.Weather[0].Description,
+			"temperature": displayTemperature(data.Main.Temp, unitsDisplays[units]),
+			"description": firstDescription(data),
 		})
 
-		logger.Info("Result", "city", data.Name, "country", data.Sys.Country, "temperature", fmt.Sprint(data.Main.Temp))
+		stressLogger.Info("Result", "weather", data)
 	}
 
 	ctx.JSON(http.StatusOK, stressResponse)
 
 }
 
-func stressTestHelper2(location string, sq *SharedQueue) error {
+func stressTestHelper2(ctx context.Context, location string, units string, sq *SharedQueue[WeatherData]) error {
 
-	weatherData, err := instrumentedSendWeatherRequest(location)
+	weatherData, err := instrumentedSendWeatherRequestContext(ctx, location, units)
 
 	if err != nil {
-		logger.Info("Pushing empty data due to error", "location", location)
+		stressLogger.Info("Pushing empty data due to error", "location", location)
 		sq.Push(weatherData)
-		logger.Error("Error fetching weather data", "location", location, "error", err)
+		stressLogger.Error("Error fetching weather data", "location", location, "error", err)
 		return err
 	}
 
-	logger.Info("Pushing weather data", "location", location)
+	stressLogger.Info("Pushing weather data", "location", location)
 	sq.Push(weatherData)
 
 	return nil
@@ -367,6 +891,7 @@ func stressTestHelper2(location string, sq *SharedQueue) error {
 // Barrier till buffer is full, and then drain.
 // Excellent work, works at scale!
 func getWeatherStressTest2(ctx *gin.Context) {
+	units := parseUnits(ctx)
 
 	// cities := []string{"Bengaluru", "New%20York", "Tokyo", "London", "Paris", "Sydney", "Berlin", "Moscow", "Cairo", "Rio%20de%20Janeiro", "Miami", "Sao%20Paulo", "Madrid", "Barcelona", "Lisbon", "Vienna", "Buenos%20Aires", "Bangkok", "Singapore", "San%20Francisco", "Shanghai", "Mumbai", "Hong%20Kong"}
 
@@ -380,52 +905,56 @@ func getWeatherStressTest2(ctx *gin.Context) {
 	}
 
 	cities := result
-	sq := &SharedQueue{}
+	sq := &SharedQueue[WeatherData]{}
+	requestCtx := ctx.Request.Context()
 
 	for _, city := range cities {
 		go func(city string) {
-			err := stressTestHelper2(city, sq)
+			err := stressTestHelper2(requestCtx, city, units, sq)
 			if err != nil {
-				logger.Error("Weather fetch failed", "city", city)
+				stressLogger.Error("Weather fetch failed", "city", city)
 			}
 		}(city)
 	}
 
-	results := sq.GetAllBlocking(len(cities))
+	// Barrier till the buffer is full, lifted early when the client goes away
+	if err := sq.WaitForContext(requestCtx, len(cities)); err != nil {
+		logger.Info("Client went away, abandoning stress test 2")
+		return
+	}
+	results := sq.GetAll()
 
 	var stressResponse []gin.H
 
 	logger.Info("Processing stress test 2 results")
 	for _, data := range results {
 
-		// description produces a BoundsError which is not in the scope of what I'm trying to do here
 		stressResponse = append(stressResponse, gin.H{
 			"city":        data.Name,
 			"country":     data.Sys.Country,
-			"temperature": fmt.Sprint(data.Main.Temp),
-			// "description": data.Weather[0].Description,
+			"temperature": displayTemperature(data.Main.Temp, unitsDisplays[units]),
+			"description": firstDescription(data),
 		})
 
-		// logger.Info("City: ", data.Name, " Country: ", data.Sys.Country, " Temperature: ", fmt.Sprint(data.Main.Temp), " Description: ", data.Weather[0].Description)
-		logger.Info("Result", "city", data.Name, "country", data.Sys.Country, "temperature", fmt.Sprint(data.Main.Temp))
+		stressLogger.Info("Result", "weather", data)
 	}
 
 	ctx.JSON(http.StatusOK, stressResponse)
 
 }
 
-func stressTestHelper3(location string, sq *SharedQueue) error {
+func stressTestHelper3(ctx context.Context, location string, units string, sq *SharedQueue[WeatherData]) error {
 
-	weatherData, err := instrumentedSendWeatherRequest(location)
+	weatherData, err := instrumentedSendWeatherRequestContext(ctx, location, units)
 
 	if err != nil {
-		logger.Info("Pushing empty data due to error", "location", location)
+		stressLogger.Info("Pushing empty data due to error", "location", location)
 		sq.FastPush(weatherData)
-		logger.Error("Error fetching weather data", "location", location, "error", err)
+		stressLogger.Error("Error fetching weather data", "location", location, "error", err)
 		return err
 	}
 
-	logger.Info("Pushing weather data", "location", location)
+	stressLogger.Info("Pushing weather data", "location", location)
 	sq.FastPush(weatherData)
 
 	return nil
@@ -435,6 +964,7 @@ func stressTestHelper3(location string, sq *SharedQueue) error {
 // Barrier till the first element is present, keep draining the queue while producer is pushing data.
 // Excellent work, works at scale!
 func getWeatherStressTest3(ctx *gin.Context) {
+	units := parseUnits(ctx)
 
 	// cities := []string{"Bengaluru", "New%20York", "Tokyo", "London", "Paris", "Sydney", "Berlin", "Moscow", "Cairo", "Rio%20de%20Janeiro", "Miami", "Sao%20Paulo", "Madrid", "Barcelona", "Lisbon", "Vienna", "Buenos%20Aires", "Bangkok", "Singapore", "San%20Francisco", "Shanghai", "Mumbai", "Hong%20Kong"}
 
@@ -451,19 +981,21 @@ func getWeatherStressTest3(ctx *gin.Context) {
 
 	// cities := []string{"Lisbon", "Vienna", "Tokyo", "London", "Paris"}
 
-	sq := &SharedQueue{notify: true}
+	sq := &SharedQueue[WeatherData]{notify: true}
+	requestCtx := ctx.Request.Context()
 
 	for _, city := range cities {
 		go func(city string) {
-			err := stressTestHelper3(city, sq)
+			err := stressTestHelper3(requestCtx, city, units, sq)
 			if err != nil {
-				logger.Error("Weather fetch failed", "city", city)
+				stressLogger.Error("Weather fetch failed", "city", city)
 			}
 		}(city)
 	}
 
-	channel := make(chan WeatherData, 1)
-	defer close(channel)
+	// Buffered for every city, so the consumer goroutines can still hand over what they pop once the
+	// handler gave up on a cancelled request. It is never closed for the same reason.
+	channel := make(chan WeatherData, len(cities))
 
 	// Handle panic for consumer goroutine
 	defer func() {
@@ -479,44 +1011,76 @@ func getWeatherStressTest3(ctx *gin.Context) {
 	logger.Info("Processing stress test 3 results")
 	for i := 0; i < len(cities); i++ {
 
-		logger.Debug("Queue iteration", "iteration", i, "queueSize", len(sq.data))
+		stressLogger.Debug("Queue iteration", "iteration", i, "queueSize", sq.GetLength())
 
-		data := <-channel
+		var data WeatherData
+		select {
+		case data = <-channel:
+		case <-requestCtx.Done():
+			logger.Info("Client went away, abandoning stress test 3")
+			return
+		}
 
 		stressResponse = append(stressResponse, gin.H{
 			"city":        data.Name,
 			"country":     data.Sys.Country,
-			"temperature": fmt.Sprint(data.Main.Temp),
-			// "description": fmt.Sprint(data.Weather[0].Description),
+			"temperature": displayTemperature(data.Main.Temp, unitsDisplays[units]),
+			"description": firstDescription(data),
 		})
 
-		logger.Info("Result", "city", data.Name, "country", data.Sys.Country, "temperature", fmt.Sprint(data.Main.Temp))
+		stressLogger.Info("Result", "weather", data)
 
-		logger.Debug("Queue post-iteration", "iteration", i, "queueSize", len(sq.data))
+		stressLogger.Debug("Queue post-iteration", "iteration", i, "queueSize", sq.GetLength())
 	}
 
 	ctx.JSON(http.StatusOK, stressResponse)
 
 }
 
-func instrumentedSendWeatherRequest(location string) (WeatherData, error) {
-	ctx, span := tracer.Start(context.Background(), "sendWeatherRequest")
+func instrumentedSendWeatherRequestContext(parent context.Context, location string, units string) (WeatherData, error) {
+	ctx, span := tracer.Start(parent, "sendWeatherRequest")
 	defer span.End()
 
 	span.SetAttributes(
 		attribute.String("location", location),
+		attribute.String("units", units),
 	)
 
 	start := time.Now()
 	weatherRequestCounter.Add(ctx, 1,
 		metric.WithAttributes(attribute.Key("endpoint").String("sendWeatherRequest")))
-	data, err := sendWeatherRequest(location)
+	data, err := sendWeatherRequestContext(ctx, location, units)
 	duration := time.Since(start).Seconds()
 	weatherRequestDuration.Record(ctx, duration,
 		metric.WithAttributes(attribute.Key("endpoint").String("sendWeatherRequest")))
 
 	if err != nil {
-		span.RecordError(err)
+		recordUpstreamError(ctx, span, "sendWeatherRequest", err)
+	}
+
+	return data, err
+}
+
+func instrumentedSendWeatherRequestByCoordinates(ctx context.Context, coordinates Coordinates, units string) (WeatherData, error) {
+	ctx, span := tracer.Start(ctx, "sendWeatherRequestByCoordinates")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.Float64("lat", coordinates.Latitude),
+		attribute.Float64("lon", coordinates.Longitude),
+		attribute.String("units", units),
+	)
+
+	start := time.Now()
+	weatherRequestCounter.Add(ctx, 1,
+		metric.WithAttributes(attribute.Key("endpoint").String("sendWeatherRequestByCoordinates")))
+	data, err := sendWeatherRequestByCoordinates(ctx, coordinates, units)
+	duration := time.Since(start).Seconds()
+	weatherRequestDuration.Record(ctx, duration,
+		metric.WithAttributes(attribute.Key("endpoint").String("sendWeatherRequestByCoordinates")))
+
+	if err != nil {
+		recordUpstreamError(ctx, span, "sendWeatherRequestByCoordinates", err)
 	}
 
 	return data, err
@@ -549,7 +1113,7 @@ func instrumentedGetWeatherLocal(ctx *gin.Context) {
 	defer span.End()
 
 	span.SetAttributes(
-		attribute.String("location", "Sydney"),
+		attribute.String("location", localCity),
 		attribute.String("method", ctx.Request.Method),
 		attribute.String("path", ctx.Request.URL.Path),
 	)
@@ -645,22 +1209,112 @@ func instrumentedGetWeatherStressTest3(ctx *gin.Context) {
 	span.SetAttributes(attribute.Int("http.status_code", ctx.Writer.Status()))
 }
 
-// ParseApiKey reads the API key from a file and returns it.
+// coordinatePrecision is the number of decimals coordinates are rounded to, about 10cm at the equator.
+const coordinatePrecision = 6
+
+// parseCoordinates reads the lat and lon query parameters of the request.
+//
+// The values are rounded to coordinatePrecision decimals, so inputs with excessive precision
+// or in scientific notation all lead to the same, plain decimal, upstream query.
+//
+// Parameters:
+// ctx (gin.Context): The Gin context containing request and response objects.
+//
+// Return:
+// Coordinates: The parsed position.
+// error: An error describing the first missing, malformed, non finite or out of range parameter.
+func parseCoordinates(ctx *gin.Context) (Coordinates, error) {
+	lat, err := strconv.ParseFloat(ctx.Query("lat"), 64)
+	if err != nil || math.IsNaN(lat) || lat < -90 || lat > 90 {
+		return Coordinates{}, fmt.Errorf("lat must be a number between -90 and 90")
+	}
+
+	lon, err := strconv.ParseFloat(ctx.Query("lon"), 64)
+	if err != nil || math.IsNaN(lon) || lon < -180 || lon > 180 {
+		return Coordinates{}, fmt.Errorf("lon must be a number between -180 and 180")
+	}
+
+	return Coordinates{Latitude: roundCoordinate(lat), Longitude: roundCoordinate(lon)}, nil
+}
+
+// roundCoordinate rounds a latitude or longitude to coordinatePrecision decimals.
+func roundCoordinate(value float64) float64 {
+	scale := math.Pow10(coordinatePrecision)
+	return math.Round(value*scale) / scale
+}
+
+// query renders the coordinates as the lat and lon parameters of an upstream URL,
+// always in plain decimal notation.
+func (c Coordinates) query() string {
+	return "lat=" + strconv.FormatFloat(c.Latitude, 'f', -1, 64) + "&lon=" + strconv.FormatFloat(c.Longitude, 'f', -1, 64)
+}
+
+// notModifiedSince sets the Last-Modified and ETag headers from the observation time of the weather data
+// and reports whether the client already holds that observation according to If-None-Match or,
+// when the client sent no If-None-Match, If-Modified-Since.
+//
+// The observation time (Dt) is used rather than the time of the request, so a client polling
+// between two upstream measurements keeps getting 304 Not Modified. The ETag is derived from Dt
+// alone for the same reason, it stays the same across refreshes returning the same observation.
+//
+// Parameters:
+// ctx (gin.Context): The Gin context containing request and response objects.
+// weatherData (WeatherData): The weather data about to be returned.
+//
+// Return: true if the handler should respond with 304 Not Modified
+func notModifiedSince(ctx *gin.Context, weatherData WeatherData) bool {
+	if weatherData.Dt == 0 {
+		return false
+	}
+
+	observed := time.Unix(int64(weatherData.Dt), 0).UTC()
+	ctx.Header("Last-Modified", observed.Format(http.TimeFormat))
+
+	etag := observationETag(weatherData)
+	ctx.Header("ETag", etag)
+
+	if ifNoneMatch := ctx.GetHeader("If-None-Match"); ifNoneMatch != "" {
+		return etagMatches(ifNoneMatch, etag)
+	}
+
+	since, err := http.ParseTime(ctx.GetHeader("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+
+	return !observed.After(since)
+}
+
+// observationETag returns the ETag of an observation. It is weak since the body also depends on
+// the time of the request, through the staleness flag, while the observation stays the same.
+func observationETag(weatherData WeatherData) string {
+	return `W/"` + strconv.Itoa(weatherData.Dt) + `"`
+}
+
+// etagMatches reports whether the If-None-Match header lists etag, or is "*", using the weak comparison.
+func etagMatches(ifNoneMatch string, etag string) bool {
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseApiKey returns the OpenWeatherMap API key.
 //
-// The function opens the file "./api.key" and reads its contents.
-// If the file cannot be opened or read, an error is returned.
+// It is a shorthand for apiKeyFor(providerOpenWeather), kept for the fetchers that only talk to OpenWeatherMap.
 //
 // Parameters:
 // None
 //
 // Return: the api key as a string
 func parseApiKey() (string, error) {
-	// Parse API key from file and return it
-	file, err := os.ReadFile("./api.key")
-	if err != nil {
-		return "", err
-	}
-	return strings.TrimSpace(string(file)), nil
+	return apiKeyFor(providerOpenWeather)
 }
 
 // HandleDefaultRoute handles the default route of the application.
@@ -0,0 +1,29 @@
+package weather
+
+import (
+	"os"
+
+	"github.com/gin-gonic/gin"
+)
+
+// servedByHeader names the instance that served a response.
+const servedByHeader = "X-Served-By"
+
+// hostnameInstanceID is the instance ID used when WEATHER_INSTANCE_ID is not set, the host name
+// or "unknown" if it cannot be read.
+func hostnameInstanceID() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return "unknown"
+	}
+	return hostname
+}
+
+// servedByMiddleware tags every response with the ID of this instance, so a response can be traced
+// back to the instance that served it, and differences between instance caches can be spotted.
+func servedByMiddleware(instanceID string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header(servedByHeader, instanceID)
+		c.Next()
+	}
+}
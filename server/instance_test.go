@@ -0,0 +1,56 @@
+package weather
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestServedByMiddleware(t *testing.T) {
+	router := gin.New()
+	router.Use(servedByMiddleware("weather-2"))
+	router.GET("/", getHandleDefaultRoute)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	router.ServeHTTP(w, req)
+
+	if got := w.Header().Get(servedByHeader); got != "weather-2" {
+		t.Errorf("Expected %s to be weather-2, got %q", servedByHeader, got)
+	}
+}
+
+func TestLoadConfigInstanceID(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Skipf("No host name to compare with: %v", err)
+	}
+
+	t.Setenv("WEATHER_INSTANCE_ID", "")
+	if cfg, err := loadConfig(); err != nil || cfg.InstanceID != hostname {
+		t.Errorf("Expected the host name %q by default, got %q (%v)", hostname, cfg.InstanceID, err)
+	}
+
+	t.Setenv("WEATHER_INSTANCE_ID", "weather-2")
+	if cfg, err := loadConfig(); err != nil || cfg.InstanceID != "weather-2" {
+		t.Errorf("Expected weather-2, got %q (%v)", cfg.InstanceID, err)
+	}
+}
+
+func TestWeatherResponseServedBy(t *testing.T) {
+	previous := config
+	t.Cleanup(func() { config = previous })
+	config.InstanceID = "weather-2"
+
+	if _, ok := weatherResponse(sampleWeatherData("Sydney"), displayStandard)["served_by"]; ok {
+		t.Error("Expected no served_by field unless enabled")
+	}
+
+	config.ServedByField = true
+	if got := weatherResponse(sampleWeatherData("Sydney"), displayStandard)["served_by"]; got != "weather-2" {
+		t.Errorf("Expected served_by to be weather-2, got %v", got)
+	}
+}
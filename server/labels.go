@@ -0,0 +1,71 @@
+package weather
+
+import "sync"
+
+// otherLabel replaces every label value outside the top values of a labelLimiter.
+const otherLabel = "other"
+
+// maxLabelValues is the number of distinct cities, or countries, that get their own metric series.
+const maxLabelValues = 50
+
+// labelCandidates is the number of values a labelLimiter counts per value it labels.
+const labelCandidates = 4
+
+/*
+labelLimiter bounds the values a metric label takes, so a label fed from client input such as a
+city cannot create an unbounded number of series and overwhelm the Prometheus scrape.
+
+Each call to Label counts one occurrence of its value, and only the limit most frequent values
+keep their own series, every other value being reported as "other". The counts are kept for at
+most labelCandidates times limit values: when full, the least frequent one is forgotten to make
+room for a new value, which starts from a single occurrence, so a burst of one-off values cannot
+push the popular ones out.
+*/
+type labelLimiter struct {
+	mutex  sync.Mutex
+	limit  int
+	counts map[string]int
+}
+
+// Shared by every metric labelled with a city or a country, so they all agree on the tracked values.
+var (
+	cityLabels    = newLabelLimiter(maxLabelValues)
+	countryLabels = newLabelLimiter(maxLabelValues)
+)
+
+func newLabelLimiter(limit int) *labelLimiter {
+	return &labelLimiter{
+		limit:  limit,
+		counts: make(map[string]int, labelCandidates*limit),
+	}
+}
+
+// Label counts an occurrence of value and returns it if it is one of the limit most frequent values,
+// ties included, and "other" otherwise.
+func (l *labelLimiter) Label(value string) string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if _, ok := l.counts[value]; !ok && len(l.counts) >= labelCandidates*l.limit {
+		least := ""
+		for candidate, count := range l.counts {
+			if least == "" || count < l.counts[least] {
+				least = candidate
+			}
+		}
+		delete(l.counts, least)
+	}
+	l.counts[value]++
+
+	count, above := l.counts[value], 0
+	for _, other := range l.counts {
+		if other > count {
+			above++
+		}
+	}
+	if above >= l.limit {
+		return otherLabel
+	}
+
+	return value
+}
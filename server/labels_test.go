@@ -0,0 +1,75 @@
+package weather
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestLabelLimiterMapsOverflowToOther(t *testing.T) {
+	limiter := newLabelLimiter(3)
+
+	for _, city := range []string{"Tokyo", "London", "Tokyo", "Cairo", "London", "Cairo"} {
+		if got := limiter.Label(city); got != city {
+			t.Errorf("Expected %s to be tracked, got %s", city, got)
+		}
+	}
+
+	if got := limiter.Label("Paris"); got != otherLabel {
+		t.Errorf("Expected the 4th distinct value to map to %s, got %s", otherLabel, got)
+	}
+	if got := limiter.Label("London"); got != "London" {
+		t.Errorf("Expected a tracked value to keep its label, got %s", got)
+	}
+}
+
+func TestLabelLimiterKeepsTopValues(t *testing.T) {
+	limiter := newLabelLimiter(2)
+
+	limiter.Label("Tokyo")
+	limiter.Label("London")
+
+	// Paris overtakes London, which then falls out of the top 2
+	for range 3 {
+		limiter.Label("Paris")
+	}
+	limiter.Label("Tokyo")
+	limiter.Label("Tokyo")
+
+	if got := limiter.Label("Paris"); got != "Paris" {
+		t.Errorf("Expected the most frequent value to keep its label, got %s", got)
+	}
+	if got := limiter.Label("London"); got != otherLabel {
+		t.Errorf("Expected a value outside the top 2 to map to %s, got %s", otherLabel, got)
+	}
+
+	// One-off values never evict the popular ones
+	for i := range 100 {
+		limiter.Label(fmt.Sprint("city", i))
+	}
+	if got := limiter.Label("Paris"); got != "Paris" {
+		t.Errorf("Expected the most frequent value to survive one-off values, got %s", got)
+	}
+	if len(limiter.counts) > labelCandidates*2 {
+		t.Errorf("Expected at most %d counted values, got %d", labelCandidates*2, len(limiter.counts))
+	}
+}
+
+// TestLabelLimiterConcurrent checks the counts stay bounded under concurrent use, run it with -race.
+func TestLabelLimiterConcurrent(t *testing.T) {
+	limiter := newLabelLimiter(10)
+
+	var wg sync.WaitGroup
+	for i := range 100 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			limiter.Label(fmt.Sprint("city", i))
+		}()
+	}
+	wg.Wait()
+
+	if len(limiter.counts) != labelCandidates*10 {
+		t.Errorf("Expected %d counted values, got %d", labelCandidates*10, len(limiter.counts))
+	}
+}
@@ -0,0 +1,103 @@
+package weather
+
+import (
+	"cmp"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxLastSeenCities bounds the number of cities lastSeenTracker remembers, the least recently fetched
+// one is forgotten to make room for a new one.
+const maxLastSeenCities = 500
+
+// lastSeenTracker remembers when the weather of each city was last fetched successfully from
+// upstream, for the staleness dashboard served on /last-seen.
+type lastSeenTracker struct {
+	mutex sync.Mutex
+	seen  map[string]time.Time
+	limit int
+
+	// Clock, replaced in tests
+	now func() time.Time
+}
+
+// lastSeen is fed by every successful upstream fetch of the current weather by location, keyed by normalized location.
+var lastSeen = newLastSeenTracker(maxLastSeenCities)
+
+func newLastSeenTracker(limit int) *lastSeenTracker {
+	return &lastSeenTracker{
+		seen:  make(map[string]time.Time),
+		limit: limit,
+		now:   time.Now,
+	}
+}
+
+// Record sets the last successful fetch of key to now, forgetting the least recently fetched city
+// when the tracker is full.
+func (l *lastSeenTracker) Record(key string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if _, ok := l.seen[key]; !ok && len(l.seen) >= l.limit {
+		oldest := ""
+		for city, seen := range l.seen {
+			if oldest == "" || seen.Before(l.seen[oldest]) {
+				oldest = city
+			}
+		}
+		delete(l.seen, oldest)
+	}
+
+	l.seen[key] = l.now()
+}
+
+// lastSeenEntry is the last successful fetch of one city.
+type lastSeenEntry struct {
+	city string
+	seen time.Time
+}
+
+// Entries returns the last successful fetch of every city tracked, the most recent first.
+func (l *lastSeenTracker) Entries() []lastSeenEntry {
+	l.mutex.Lock()
+	entries := make([]lastSeenEntry, 0, len(l.seen))
+	for city, seen := range l.seen {
+		entries = append(entries, lastSeenEntry{city: city, seen: seen})
+	}
+	l.mutex.Unlock()
+
+	slices.SortFunc(entries, func(a, b lastSeenEntry) int {
+		return cmp.Or(b.seen.Compare(a.seen), cmp.Compare(a.city, b.city))
+	})
+
+	return entries
+}
+
+// getLastSeen handles the /last-seen route.
+// It responds with the time of the last successful upstream fetch of each city, the most recent first,
+// along with its age in seconds.
+//
+// Parameters:
+// ctx (gin.Context): The Gin context containing request and response objects.
+//
+// Return:
+// None
+func getLastSeen(ctx *gin.Context) {
+	now := lastSeen.now()
+
+	entries := lastSeen.Entries()
+	response := make([]gin.H, 0, len(entries))
+	for _, entry := range entries {
+		response = append(response, gin.H{
+			"city":        entry.city,
+			"last_seen":   entry.seen.UTC().Format(time.RFC3339),
+			"age_seconds": int64(now.Sub(entry.seen).Seconds()),
+		})
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
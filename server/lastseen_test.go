@@ -0,0 +1,71 @@
+package weather
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestFetchUpdatesLastSeen(t *testing.T) {
+	previous := lastSeen
+	t.Cleanup(func() { lastSeen = previous })
+
+	now := time.Date(2025, 4, 13, 15, 0, 0, 0, time.UTC)
+	lastSeen = newLastSeenTracker(maxLastSeenCities)
+	lastSeen.now = func() time.Time { return now }
+
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("q") == "Atlantis" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"name": "Tokyo", "main": {"temp": 290}}`))
+	})
+
+	fetchWeatherShared("Tokyo")
+	now = now.Add(time.Minute)
+	fetchWeatherShared("Atlantis")
+
+	router := gin.New()
+	router.GET("/last-seen", getLastSeen)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/last-seen", nil)
+	router.ServeHTTP(w, req)
+
+	var data []struct {
+		City       string `json:"city"`
+		LastSeen   string `json:"last_seen"`
+		AgeSeconds int64  `json:"age_seconds"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+		t.Fatalf("Error unmarshalling JSON response: %v", err)
+	}
+
+	if len(data) != 1 {
+		t.Fatalf("Expected only the successful fetch to be tracked, got %+v", data)
+	}
+	if data[0].City != "tokyo" || data[0].LastSeen != "2025-04-13T15:00:00Z" || data[0].AgeSeconds != 60 {
+		t.Errorf("Unexpected last seen entry %+v", data[0])
+	}
+}
+
+func TestLastSeenForgetsLeastRecent(t *testing.T) {
+	now := time.Date(2025, 4, 13, 15, 0, 0, 0, time.UTC)
+	tracker := newLastSeenTracker(2)
+	tracker.now = func() time.Time { return now }
+
+	for _, city := range []string{"london", "paris", "london", "berlin"} {
+		tracker.Record(city)
+		now = now.Add(time.Second)
+	}
+
+	entries := tracker.Entries()
+	if len(entries) != 2 || entries[0].city != "berlin" || entries[1].city != "london" {
+		t.Errorf("Expected berlin then london, got %+v", entries)
+	}
+}
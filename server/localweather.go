@@ -0,0 +1,42 @@
+package weather
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// localCity is the city served by the /weather route.
+const localCity = "Sydney"
+
+// lastLocalWeather is the last weather of localCity fetched by the background refresher,
+// nil until its first successful fetch.
+var lastLocalWeather atomic.Pointer[WeatherData]
+
+/*
+refreshLocalWeather keeps lastLocalWeather up to date for the cache-only mode of /weather,
+see WeatherConfig.LocalCacheOnly.
+
+It fetches the weather of localCity right away and then every interval, straight from the
+upstream API so that a longer WeatherConfig.CacheTTL never holds the refresh back. A failed
+fetch keeps the previous value, which then simply gets older. It returns when ctx is done.
+*/
+func refreshLocalWeather(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		weatherData, err := instrumentedSendWeatherRequestContext(ctx, localCity, defaultUnits)
+		if err != nil {
+			logger.Error("Error refreshing local weather", "city", localCity, "error", err)
+		} else {
+			lastLocalWeather.Store(&weatherData)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package weather
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func getLocal(t *testing.T) *httptest.ResponseRecorder {
+	return getLocalWith(t, "")
+}
+
+// getLocalWith requests the local weather with the given query string.
+func getLocalWith(t *testing.T, query string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request, _ = http.NewRequest(http.MethodGet, "/weather?"+query, nil)
+
+	getWeatherLocal(ctx)
+
+	return w
+}
+
+func TestWeatherLocalCacheOnly(t *testing.T) {
+	previousConfig := config
+	config.LocalCacheOnly = true
+	t.Cleanup(func() {
+		config = previousConfig
+		lastLocalWeather.Store(nil)
+	})
+
+	var calls atomic.Int32
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		fmt.Fprintf(w, `{"name":%q,"main":{"temp":295}}`, r.URL.Query().Get("q"))
+	})
+
+	if w := getLocal(t); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d before the first refresh, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+	if calls.Load() != 0 {
+		t.Fatalf("Expected no upstream call from the handler, got %d", calls.Load())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		refreshLocalWeather(ctx, time.Hour)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	waitUntil(t, func() bool { return lastLocalWeather.Load() != nil })
+
+	for range 3 {
+		if w := getLocal(t); w.Code != http.StatusOK {
+			t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Errorf("Expected the refresher's single upstream call, got %d", calls.Load())
+	}
+}
+
+func TestWeatherLocalIncludeForecast(t *testing.T) {
+	startMockUpstream(t, serveCannedUpstream)
+
+	w := getLocalWith(t, "include_forecast=true")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var response struct {
+		City     string           `json:"city"`
+		Forecast []map[string]any `json:"forecast"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Error unmarshalling response: %v", err)
+	}
+
+	if response.City != localCity {
+		t.Errorf("Expected the current weather of %s, got %q", localCity, response.City)
+	}
+	if len(response.Forecast) != shortForecastSlots {
+		t.Errorf("Expected %d forecast slots, got %d", shortForecastSlots, len(response.Forecast))
+	}
+
+	if w := getLocal(t); strings.Contains(w.Body.String(), "forecast") {
+		t.Errorf("Expected no forecast unless asked for, got %s", w.Body.String())
+	}
+	if w := getLocalWith(t, "include_forecast=maybe"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an invalid include_forecast, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+// TestWeatherLocalIncludeForecastDisplay checks that the forecast entries use the same scale as the
+// current temperature. The first canned forecast slot is at 10 K.
+func TestWeatherLocalIncludeForecastDisplay(t *testing.T) {
+	startMockUpstream(t, serveCannedUpstream)
+
+	for _, tt := range []struct {
+		query    string
+		expected map[string]string
+	}{
+		{"include_forecast=true", map[string]string{"temperature": displayTemperature(10, displayCelsius)}},
+		{"include_forecast=true&units=imperial", map[string]string{"temperature": displayTemperature(10, displayFahrenheit)}},
+		{"include_forecast=true&display=both", map[string]string{
+			"temperature_c": displayTemperature(10, displayCelsius),
+			"temperature_f": displayTemperature(10, displayFahrenheit),
+		}},
+	} {
+		w := getLocalWith(t, tt.query)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected status %d, got %d", tt.query, http.StatusOK, w.Code)
+		}
+
+		var response map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("%s: error unmarshalling response: %v", tt.query, err)
+		}
+		forecast, _ := response["forecast"].([]any)
+		if len(forecast) == 0 {
+			t.Fatalf("%s: expected forecast entries, got %v", tt.query, response)
+		}
+
+		for field, expected := range tt.expected {
+			if _, ok := response[field]; !ok {
+				t.Errorf("%s: expected the current weather to have %s, got %v", tt.query, field, response)
+			}
+			if got := forecast[0].(map[string]any)[field]; got != expected {
+				t.Errorf("%s: expected the forecast %s %s, got %v", tt.query, field, expected, got)
+			}
+		}
+	}
+}
+
+func TestWeatherLocalIncludeForecastFailure(t *testing.T) {
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/data/2.5/forecast" {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		serveCannedWeather(w, r)
+	})
+
+	w := getLocalWith(t, "include_forecast=true")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected the current weather despite the forecast failure, got status %d", w.Code)
+	}
+
+	var response map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Error unmarshalling response: %v", err)
+	}
+
+	if response["city"] != localCity {
+		t.Errorf("Expected the current weather of %s, got %v", localCity, response)
+	}
+	if _, ok := response["forecast_error"]; !ok {
+		t.Errorf("Expected a forecast_error note, got %v", response)
+	}
+	if _, ok := response["forecast"]; ok {
+		t.Errorf("Expected no forecast after a failure, got %v", response["forecast"])
+	}
+}
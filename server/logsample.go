@@ -0,0 +1,36 @@
+package weather
+
+import "sync/atomic"
+
+// sampledLogger logs only one in every WeatherConfig.StressLogSampleRate of its Info and Debug records,
+// so handlers logging a line per city stay readable at scale. Error records are always logged.
+//
+// It writes through the package logger at call time, since logger is only set up by WeatherServer.
+type sampledLogger struct {
+	records atomic.Int64
+}
+
+// stressLogger is shared by the stress and batch handlers for their per-city records.
+var stressLogger = &sampledLogger{}
+
+// sampled counts a record and reports whether it is one of those logged.
+func (s *sampledLogger) sampled() bool {
+	rate := int64(config.StressLogSampleRate)
+	return rate <= 1 || (s.records.Add(1)-1)%rate == 0
+}
+
+func (s *sampledLogger) Debug(msg string, args ...any) {
+	if s.sampled() {
+		logger.Debug(msg, args...)
+	}
+}
+
+func (s *sampledLogger) Info(msg string, args ...any) {
+	if s.sampled() {
+		logger.Info(msg, args...)
+	}
+}
+
+func (s *sampledLogger) Error(msg string, args ...any) {
+	logger.Error(msg, args...)
+}
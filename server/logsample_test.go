@@ -0,0 +1,39 @@
+package weather
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSampledLoggerKeepsErrors(t *testing.T) {
+	previousConfig, previousLogger := config, logger
+	t.Cleanup(func() { config, logger = previousConfig, previousLogger })
+
+	var output bytes.Buffer
+	logger = slog.New(slog.NewTextHandler(&output, nil))
+	config.StressLogSampleRate = 3
+
+	sampled := &sampledLogger{}
+	for range 9 {
+		sampled.Info("Result")
+		sampled.Error("Weather fetch failed")
+	}
+
+	if results := strings.Count(output.String(), "msg=Result"); results != 3 {
+		t.Errorf("Expected 1 in 3 info records to be logged, got %d of 9", results)
+	}
+	if failures := strings.Count(output.String(), `msg="Weather fetch failed"`); failures != 9 {
+		t.Errorf("Expected every error record to be logged, got %d of 9", failures)
+	}
+
+	output.Reset()
+	config.StressLogSampleRate = 1
+	for range 4 {
+		sampled.Info("Result")
+	}
+	if results := strings.Count(output.String(), "msg=Result"); results != 4 {
+		t.Errorf("Expected every record to be logged with a rate of 1, got %d of 4", results)
+	}
+}
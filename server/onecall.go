@@ -0,0 +1,267 @@
+package weather
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+type Alert struct {
+	SenderName  string   `json:"sender_name"`
+	Event       string   `json:"event"`
+	Start       int      `json:"start"`
+	End         int      `json:"end"`
+	Description string   `json:"description"`
+	Tags        []string `json:"tags"`
+}
+
+type OneCallCurrent struct {
+	Dt        int       `json:"dt"`
+	Sunrise   int       `json:"sunrise"`
+	Sunset    int       `json:"sunset"`
+	Temp      float64   `json:"temp"`
+	FeelsLike float64   `json:"feels_like"`
+	Pressure  float64   `json:"pressure"`
+	Humidity  int       `json:"humidity"`
+	Uvi       float64   `json:"uvi"`
+	Weather   []Weather `json:"weather"`
+}
+
+type OneCallData struct {
+	Latitude       float64        `json:"lat"`
+	Longitude      float64        `json:"lon"`
+	Timezone       string         `json:"timezone"`
+	TimezoneOffset int            `json:"timezone_offset"`
+	Current        OneCallCurrent `json:"current"`
+	Alerts         []Alert        `json:"alerts"`
+}
+
+// sendOneCallRequest sends a GET request to the OpenWeatherMap One Call API for the given coordinates.
+//
+// Only the current conditions and the alerts are requested, the minutely, hourly and daily
+// forecasts are excluded to keep the payload small.
+//
+// Parameters:
+// ctx (context.Context): Cancels the request, as when the client of the handler goes away.
+// coordinates (Coordinates): The position to fetch the data for.
+//
+// Return:
+// OneCallData: A struct containing the parsed One Call data.
+// error: An error if any occurred during the request or response processing.
+func sendOneCallRequest(ctx context.Context, coordinates Coordinates) (OneCallData, error) {
+	if config.DemoMode {
+		return DemoProvider{}.OneCall(coordinates), nil
+	}
+
+	var apiKey, err = parseApiKey()
+	if err != nil {
+		return OneCallData{}, fmt.Errorf("could not parse api key %v", err)
+	}
+
+	requestUrl := fmt.Sprintf("%s/data/3.0/onecall?%s&exclude=minutely,hourly,daily&appid=%s",
+		weatherApiHost, coordinates.query(), apiKey)
+
+	logger.Info("Making a One Call GET request", "lat", coordinates.Latitude, "lon", coordinates.Longitude)
+
+	release, err := acquireUpstream(ctx)
+	if err != nil {
+		return OneCallData{}, fmt.Errorf("one call request for %s cancelled: %w", coordinates.query(), err)
+	}
+	defer release()
+
+	resp, err := upstreamGet(ctx, requestUrl)
+	if err != nil {
+		if errors.Is(ctx.Err(), context.Canceled) {
+			return OneCallData{}, fmt.Errorf("one call request for %s cancelled: %w", coordinates.query(), ctx.Err())
+		}
+		return OneCallData{}, fmt.Errorf("failed to fetch one call data: %v", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return OneCallData{}, fmt.Errorf("one call API request failed with %w", upstreamStatusError(resp.StatusCode))
+	}
+
+	body, err := readUpstreamBody(resp.Body)
+	if err != nil {
+		return OneCallData{}, fmt.Errorf("failed to read one call data: %w", err)
+	}
+
+	if err := checkNotEmpty(body); err != nil {
+		return OneCallData{}, err
+	}
+
+	if err := checkContentType(resp.Header.Get("Content-Type"), body); err != nil {
+		return OneCallData{}, err
+	}
+
+	oneCallData := OneCallData{}
+	err = json.Unmarshal(body, &oneCallData)
+	if err != nil {
+		return OneCallData{}, describeDecodeError(err, body)
+	}
+
+	return oneCallData, nil
+}
+
+// getWeatherAlerts returns the severe weather alerts issued for the coordinates given by the lat and lon query parameters.
+//
+// Parameters:
+// ctx (gin.Context): The Gin context containing request and response objects.
+//
+// Return:
+// None. The function responds with a JSON array of alerts, empty when there are none.
+// If the coordinates are invalid an HTTP 400 status code is returned, and an HTTP 500 status code if the fetch fails.
+func getWeatherAlerts(ctx *gin.Context) {
+
+	coordinates, err := parseCoordinates(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	oneCallData, err := instrumentedSendOneCallRequest(ctx.Request.Context(), coordinates)
+	if err != nil {
+		logger.Error("Error fetching weather alerts", "error", err)
+		ctx.JSON(http.StatusInternalServerError, failedFetchResponse("Failed to fetch weather alerts", err))
+		return
+	}
+
+	alerts := make([]gin.H, 0, len(oneCallData.Alerts))
+	for _, alert := range oneCallData.Alerts {
+		alerts = append(alerts, gin.H{
+			"event":       alert.Event,
+			"description": alert.Description,
+			"start":       alert.Start,
+			"end":         alert.End,
+			"sender":      alert.SenderName,
+		})
+	}
+
+	ctx.JSON(http.StatusOK, alerts)
+
+}
+
+// uvRiskCategory maps a UV index to the WHO exposure category.
+//
+// Parameters:
+// uvi (float64): The UV index.
+//
+// Return: one of "low", "moderate", "high", "very high" or "extreme"
+func uvRiskCategory(uvi float64) string {
+	switch {
+	case uvi < 3:
+		return "low"
+	case uvi < 6:
+		return "moderate"
+	case uvi < 8:
+		return "high"
+	case uvi < 11:
+		return "very high"
+	default:
+		return "extreme"
+	}
+}
+
+// getUVIndex returns the current UV index and its risk category for the coordinates given by the lat and lon query parameters.
+//
+// Parameters:
+// ctx (gin.Context): The Gin context containing request and response objects.
+//
+// Return:
+// None. The function responds with the UV index as JSON.
+// If the coordinates are invalid an HTTP 400 status code is returned, and an HTTP 500 status code if the fetch fails.
+func getUVIndex(ctx *gin.Context) {
+
+	coordinates, err := parseCoordinates(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	oneCallData, err := instrumentedSendOneCallRequest(ctx.Request.Context(), coordinates)
+	if err != nil {
+		logger.Error("Error fetching UV index", "error", err)
+		ctx.JSON(http.StatusInternalServerError, failedFetchResponse("Failed to fetch UV index", err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"uvi":  oneCallData.Current.Uvi,
+		"risk": uvRiskCategory(oneCallData.Current.Uvi),
+		"time": oneCallData.Current.Dt,
+	})
+
+}
+
+func instrumentedSendOneCallRequest(ctx context.Context, coordinates Coordinates) (OneCallData, error) {
+	ctx, span := tracer.Start(ctx, "sendOneCallRequest")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.Float64("lat", coordinates.Latitude),
+		attribute.Float64("lon", coordinates.Longitude),
+	)
+
+	start := time.Now()
+	weatherRequestCounter.Add(ctx, 1,
+		metric.WithAttributes(attribute.Key("endpoint").String("sendOneCallRequest")))
+	data, err := sendOneCallRequest(ctx, coordinates)
+	duration := time.Since(start).Seconds()
+	weatherRequestDuration.Record(ctx, duration,
+		metric.WithAttributes(attribute.Key("endpoint").String("sendOneCallRequest")))
+
+	if err != nil {
+		recordUpstreamError(ctx, span, "sendOneCallRequest", err)
+	}
+
+	return data, err
+}
+
+func instrumentedGetWeatherAlerts(ctx *gin.Context) {
+	traceCtx, span := tracer.Start(ctx.Request.Context(), "getWeatherAlerts")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("method", ctx.Request.Method),
+		attribute.String("path", ctx.Request.URL.Path),
+	)
+
+	start := time.Now()
+	weatherRequestCounter.Add(traceCtx, 1,
+		metric.WithAttributes(attribute.Key("endpoint").String("getWeatherAlerts")))
+	getWeatherAlerts(ctx)
+	duration := time.Since(start).Seconds()
+	weatherRequestDuration.Record(traceCtx, duration,
+		metric.WithAttributes(attribute.Key("endpoint").String("getWeatherAlerts")))
+
+	span.SetAttributes(attribute.Int("http.status_code", ctx.Writer.Status()))
+}
+
+func instrumentedGetUVIndex(ctx *gin.Context) {
+	traceCtx, span := tracer.Start(ctx.Request.Context(), "getUVIndex")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("method", ctx.Request.Method),
+		attribute.String("path", ctx.Request.URL.Path),
+	)
+
+	start := time.Now()
+	weatherRequestCounter.Add(traceCtx, 1,
+		metric.WithAttributes(attribute.Key("endpoint").String("getUVIndex")))
+	getUVIndex(ctx)
+	duration := time.Since(start).Seconds()
+	weatherRequestDuration.Record(traceCtx, duration,
+		metric.WithAttributes(attribute.Key("endpoint").String("getUVIndex")))
+
+	span.SetAttributes(attribute.Int("http.status_code", ctx.Writer.Status()))
+}
@@ -0,0 +1,173 @@
+package weather
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// startMockOneCall serves body for the One Call endpoint and checks the requested coordinates.
+func startMockOneCall(t *testing.T, body string) {
+	t.Helper()
+
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/data/3.0/onecall" {
+			t.Errorf("Unexpected upstream path %s", r.URL.Path)
+		}
+		if r.URL.Query().Get("lat") != "35.68" || r.URL.Query().Get("lon") != "139.69" {
+			t.Errorf("Unexpected upstream coordinates %s", r.URL.RawQuery)
+		}
+		fmt.Fprint(w, body)
+	})
+}
+
+func getAlerts(t *testing.T, query string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request, _ = http.NewRequest(http.MethodGet, "/alerts/coords?"+query, nil)
+
+	instrumentedGetWeatherAlerts(ctx)
+
+	return w
+}
+
+func TestWeatherAlertsResponse(t *testing.T) {
+	startMockOneCall(t, `{
+		"lat": 35.68, "lon": 139.69, "timezone": "Asia/Tokyo",
+		"current": {"dt": 1744556400, "temp": 18.2, "uvi": 4.1},
+		"alerts": [{
+			"sender_name": "Japan Meteorological Agency",
+			"event": "Heavy rain warning",
+			"start": 1744556400,
+			"end": 1744599600,
+			"description": "Heavy rain expected in the Tokyo area.",
+			"tags": ["Rain"]
+		}]
+	}`)
+
+	w := getAlerts(t, "lat=35.68&lon=139.69")
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var data []map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+		t.Fatalf("Error unmarshalling JSON response: %v", err)
+	}
+
+	if len(data) != 1 {
+		t.Fatalf("Expected 1 alert, got %d", len(data))
+	}
+
+	alert := data[0]
+	if alert["event"] != "Heavy rain warning" ||
+		alert["sender"] != "Japan Meteorological Agency" ||
+		alert["description"] != "Heavy rain expected in the Tokyo area." ||
+		alert["start"] != float64(1744556400) ||
+		alert["end"] != float64(1744599600) {
+		t.Errorf("Unexpected alert %v", alert)
+	}
+}
+
+func TestWeatherAlertsEmpty(t *testing.T) {
+	startMockOneCall(t, `{"lat": 35.68, "lon": 139.69, "current": {"dt": 1744556400}}`)
+
+	w := getAlerts(t, "lat=35.68&lon=139.69")
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if w.Body.String() != "[]" {
+		t.Errorf("Expected an empty array, got %s", w.Body.String())
+	}
+}
+
+func TestWeatherAlertsInvalidCoordinates(t *testing.T) {
+	for _, query := range []string{"", "lat=35.68", "lat=abc&lon=139.69", "lat=91&lon=0", "lat=0&lon=-181",
+		"lat=NaN&lon=0", "lat=0&lon=nan", "lat=Inf&lon=0", "lat=0&lon=-Inf"} {
+		if w := getAlerts(t, query); w.Code != http.StatusBadRequest {
+			t.Errorf("Query %q: expected status %d, got %d", query, http.StatusBadRequest, w.Code)
+		}
+	}
+}
+
+func TestWeatherAlertsNormalizesCoordinates(t *testing.T) {
+	startMockOneCall(t, `{"lat":35.68,"lon":139.69}`)
+
+	for _, query := range []string{"lat=35.680000000001&lon=139.6899999999999", "lat=3.568e1&lon=1.3969E2"} {
+		if w := getAlerts(t, query); w.Code != http.StatusOK {
+			t.Errorf("Query %q: expected status %d, got %d", query, http.StatusOK, w.Code)
+		}
+	}
+}
+
+func TestCoordinatesQuery(t *testing.T) {
+	tests := []struct {
+		coordinates Coordinates
+		expected    string
+	}{
+		{Coordinates{Latitude: 35.68, Longitude: 139.69}, "lat=35.68&lon=139.69"},
+		{Coordinates{Latitude: roundCoordinate(1e-7), Longitude: roundCoordinate(-1.23456789)}, "lat=0&lon=-1.234568"},
+		{Coordinates{Latitude: 0.000001, Longitude: 1e2}, "lat=0.000001&lon=100"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.coordinates.query(); got != tt.expected {
+			t.Errorf("query() = %q, expected %q", got, tt.expected)
+		}
+	}
+}
+
+func TestUVRiskCategory(t *testing.T) {
+	tests := []struct {
+		uvi      float64
+		expected string
+	}{
+		{0, "low"},
+		{2.99, "low"},
+		{3, "moderate"},
+		{5.9, "moderate"},
+		{6, "high"},
+		{7.99, "high"},
+		{8, "very high"},
+		{10.9, "very high"},
+		{11, "extreme"},
+		{14.2, "extreme"},
+	}
+
+	for _, tt := range tests {
+		if got := uvRiskCategory(tt.uvi); got != tt.expected {
+			t.Errorf("uvRiskCategory(%v) = %q, expected %q", tt.uvi, got, tt.expected)
+		}
+	}
+}
+
+func TestUVIndexResponse(t *testing.T) {
+	startMockOneCall(t, `{"lat": 35.68, "lon": 139.69, "current": {"dt": 1744556400, "uvi": 6.5}}`)
+
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request, _ = http.NewRequest(http.MethodGet, "/uv/coords?lat=35.68&lon=139.69", nil)
+
+	instrumentedGetUVIndex(ctx)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var data map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+		t.Fatalf("Error unmarshalling JSON response: %v", err)
+	}
+
+	if data["uvi"] != 6.5 || data["risk"] != "high" {
+		t.Errorf("Unexpected UV response %v", data)
+	}
+}
@@ -0,0 +1,55 @@
+package weather
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+preloadCities fills the weather cache with cities at startup, so their first requests are
+served from the cache instead of all calling the upstream API at once.
+
+At most concurrency cities are fetched at a time, and each fetch starts stagger after the
+previous one, so a long list cannot burst the upstream and trip its rate limit at boot.
+Starting new fetches stops once ctx is done. It returns the number of cities that failed to load.
+*/
+func preloadCities(ctx context.Context, cities []string, concurrency int, stagger time.Duration) int {
+	slots := make(chan struct{}, max(concurrency, 1))
+
+	var wg sync.WaitGroup
+	var failed atomic.Int32
+
+	for i, city := range cities {
+		if i > 0 && stagger > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(stagger):
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+		case slots <- struct{}{}:
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			if _, err := fetchWeatherShared(city); err != nil {
+				logger.Error("Failed to preload city", "city", city, "error", err)
+				failed.Add(1)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return int(failed.Load())
+}
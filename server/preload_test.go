@@ -0,0 +1,90 @@
+package weather
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestPreloadCitiesRespectsConcurrency checks that preloading never has more fetches in flight than
+// its concurrency limit, and still loads every city into the cache.
+func TestPreloadCitiesRespectsConcurrency(t *testing.T) {
+	withCache(t, time.Minute, false)
+
+	var inFlight, peak atomic.Int32
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		current := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			previous := peak.Load()
+			if current <= previous || peak.CompareAndSwap(previous, current) {
+				break
+			}
+		}
+
+		time.Sleep(20 * time.Millisecond)
+		fmt.Fprintf(w, `{"name": %q, "main": {"temp": 20}}`, r.URL.Query().Get("q"))
+	})
+
+	cities := []string{"London", "Paris", "Berlin", "Madrid", "Lisbon", "Vienna", "Tokyo", "Cairo"}
+
+	if failed := preloadCities(context.Background(), cities, 2, 0); failed != 0 {
+		t.Fatalf("Expected every city to load, %d failed", failed)
+	}
+
+	if peak.Load() > 2 {
+		t.Errorf("Expected at most 2 fetches in flight, got %d", peak.Load())
+	}
+	if currentWeatherCache.Len() != len(cities) {
+		t.Errorf("Expected %d cached cities, got %d", len(cities), currentWeatherCache.Len())
+	}
+}
+
+func TestPreloadCitiesStaggersFetches(t *testing.T) {
+	withCache(t, time.Minute, false)
+
+	var mutex sync.Mutex
+	var starts []time.Time
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		starts = append(starts, time.Now())
+		mutex.Unlock()
+		fmt.Fprintf(w, `{"name": %q, "main": {"temp": 20}}`, r.URL.Query().Get("q"))
+	})
+
+	preloadCities(context.Background(), []string{"London", "Paris", "Berlin"}, 1, 30*time.Millisecond)
+
+	if len(starts) != 3 {
+		t.Fatalf("Expected 3 fetches, got %d", len(starts))
+	}
+	// The gaps are measured as the requests reach the upstream, a few milliseconds of scheduling
+	// jitter shorten them now and then
+	for i := 1; i < len(starts); i++ {
+		if gap := starts[i].Sub(starts[i-1]); gap < 25*time.Millisecond {
+			t.Errorf("Expected fetch %d to start about 30ms after the previous one, got %v", i, gap)
+		}
+	}
+}
+
+func TestPreloadCitiesStopsWhenCancelled(t *testing.T) {
+	withCache(t, time.Minute, false)
+
+	var calls atomic.Int32
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		fmt.Fprintf(w, `{"name": %q, "main": {"temp": 20}}`, r.URL.Query().Get("q"))
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	preloadCities(ctx, []string{"London", "Paris"}, 1, time.Hour)
+
+	if calls.Load() != 0 {
+		t.Errorf("Expected no fetch once cancelled, got %d", calls.Load())
+	}
+}
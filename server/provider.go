@@ -0,0 +1,140 @@
+package weather
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Errors returned by Provider.Ping, so the callers can tell a misconfiguration from an outage.
+var (
+	// The provider rejected the API key
+	ErrProviderUnauthorized = errors.New("provider rejected the api key")
+	// The provider is throttling this service
+	ErrProviderRateLimited = errors.New("provider rate limit exceeded")
+	// The provider could not be reached or failed to answer
+	ErrProviderUnavailable = errors.New("provider unavailable")
+)
+
+// Provider is an upstream source of weather data.
+type Provider interface {
+	// Name identifies the provider, it is also the name its API key is looked up under
+	Name() string
+
+	// CurrentWeather fetches the current weather for a location.
+	// A deadline on ctx replaces the provider's default timeout.
+	CurrentWeather(ctx context.Context, location string) (WeatherData, error)
+
+	// Ping checks that the provider is reachable and accepts the API key with the cheapest call it offers.
+	// It returns nil when healthy, or an error wrapping one of the ErrProvider errors.
+	Ping(ctx context.Context) error
+}
+
+// weatherProviders is the chain the current weather by location is fetched from, primary first,
+// see currentWeatherWithFallback.
+var weatherProviders = []Provider{openWeatherProvider{}}
+
+// openWeatherProvider is the OpenWeatherMap API.
+type openWeatherProvider struct{}
+
+// pingLocation is the city requested by openWeatherProvider.Ping, any city known to OpenWeatherMap does.
+const pingLocation = "London"
+
+func (openWeatherProvider) Name() string {
+	return providerOpenWeather
+}
+
+// CurrentWeather queries the upstream in the units asked for on ctx, see withUnits.
+func (openWeatherProvider) CurrentWeather(ctx context.Context, location string) (WeatherData, error) {
+	return instrumentedSendWeatherRequestContext(ctx, location, unitsFrom(ctx))
+}
+
+// Ping requests the current weather of a well known city, the smallest payload the API serves,
+// and classifies the status code without decoding the body.
+func (p openWeatherProvider) Ping(ctx context.Context) error {
+	apiKey, err := apiKeyFor(p.Name())
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrProviderUnauthorized, err)
+	}
+
+	requestUrl := fmt.Sprintf("%s/data/2.5/weather?q=%s&appid=%s", weatherApiHost, pingLocation, apiKey)
+
+	release, err := acquireUpstream(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	resp, err := upstreamGet(ctx, requestUrl)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrProviderUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	return classifyProviderStatus(resp.StatusCode)
+}
+
+// providerTimeout returns the upstream timeout configured for the named provider, or 0 to keep its default.
+func providerTimeout(name string) time.Duration {
+	return config.ProviderTimeouts[strings.ToLower(name)]
+}
+
+/*
+currentWeatherWithFallback asks each provider in turn for the current weather of location and
+returns the first successful answer.
+
+Every provider runs under its own configured timeout, see WeatherConfig.ProviderTimeouts, rather
+than a deadline shared by the chain: a slow fallback is given its own time even after a fast
+primary timed out, and a primary is never granted the fallback's longer allowance.
+
+Data served by any provider but the first is marked with that provider's name in
+WeatherData.FallbackProvider, so the responses can flag it as degraded.
+
+It returns the errors of every provider joined when they all fail.
+*/
+func currentWeatherWithFallback(ctx context.Context, providers []Provider, location string) (WeatherData, error) {
+	var errs []error
+
+	for i, provider := range providers {
+		data, err := currentWeatherWithin(ctx, provider, location)
+		if err == nil {
+			if i > 0 {
+				data.FallbackProvider = provider.Name()
+			}
+			return data, nil
+		}
+
+		logger.Error("Provider failed, falling back", "provider", provider.Name(), "location", location, "error", err)
+		errs = append(errs, fmt.Errorf("%s: %w", provider.Name(), err))
+	}
+
+	return WeatherData{}, errors.Join(errs...)
+}
+
+// currentWeatherWithin calls provider bounded by its configured timeout, if any.
+func currentWeatherWithin(ctx context.Context, provider Provider, location string) (WeatherData, error) {
+	if timeout := providerTimeout(provider.Name()); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	return provider.CurrentWeather(ctx, location)
+}
+
+// classifyProviderStatus maps the status code of an upstream response to nil or one of the ErrProvider errors.
+func classifyProviderStatus(status int) error {
+	switch {
+	case status == http.StatusOK:
+		return nil
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return fmt.Errorf("%w: status %d", ErrProviderUnauthorized, status)
+	case status == http.StatusTooManyRequests:
+		return fmt.Errorf("%w: status %d", ErrProviderRateLimited, status)
+	default:
+		return fmt.Errorf("%w: status %d", ErrProviderUnavailable, status)
+	}
+}
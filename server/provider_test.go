@@ -0,0 +1,262 @@
+package weather
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestOpenWeatherProviderPing(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		want   error
+	}{
+		{"healthy", http.StatusOK, nil},
+		{"unauthorized", http.StatusUnauthorized, ErrProviderUnauthorized},
+		{"rate limited", http.StatusTooManyRequests, ErrProviderRateLimited},
+		{"unavailable", http.StatusServiceUnavailable, ErrProviderUnavailable},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.status)
+			})
+
+			err := openWeatherProvider{}.Ping(context.Background())
+			if test.want == nil && err != nil {
+				t.Errorf("Expected a healthy provider, got %v", err)
+			}
+			if test.want != nil && !errors.Is(err, test.want) {
+				t.Errorf("Expected %v, got %v", test.want, err)
+			}
+		})
+	}
+}
+
+func TestOpenWeatherProviderPingUnreachable(t *testing.T) {
+	upstream := startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {})
+	upstream.Close()
+
+	if err := (openWeatherProvider{}).Ping(context.Background()); !errors.Is(err, ErrProviderUnavailable) {
+		t.Errorf("Expected %v, got %v", ErrProviderUnavailable, err)
+	}
+}
+
+// fakeProvider answers after delay, or fails with the context error if its deadline comes first.
+type fakeProvider struct {
+	name  string
+	delay time.Duration
+}
+
+func (p fakeProvider) Name() string {
+	return p.name
+}
+
+func (p fakeProvider) CurrentWeather(ctx context.Context, location string) (WeatherData, error) {
+	select {
+	case <-time.After(p.delay):
+		return WeatherData{Name: location + " from " + p.name}, nil
+	case <-ctx.Done():
+		return WeatherData{}, ctx.Err()
+	}
+}
+
+func (p fakeProvider) Ping(ctx context.Context) error {
+	return nil
+}
+
+// recordedCall is an upstream call seen by RecordingProvider.
+type recordedCall struct {
+	location string
+	at       time.Time
+}
+
+// RecordingProvider wraps a Provider and records every CurrentWeather call made through it, so
+// tests can assert on which upstream calls the handlers actually made.
+type RecordingProvider struct {
+	Provider
+
+	mutex sync.Mutex
+	calls []recordedCall
+}
+
+func (p *RecordingProvider) CurrentWeather(ctx context.Context, location string) (WeatherData, error) {
+	p.mutex.Lock()
+	p.calls = append(p.calls, recordedCall{location: location, at: time.Now()})
+	p.mutex.Unlock()
+
+	return p.Provider.CurrentWeather(ctx, location)
+}
+
+// Calls returns the calls recorded so far, in the order they were made.
+func (p *RecordingProvider) Calls() []recordedCall {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return slices.Clone(p.calls)
+}
+
+// withRecordingProvider serves the current weather from a RecordingProvider wrapping inner, with
+// the cache disabled so every lookup reaches the provider, for the duration of the test.
+func withRecordingProvider(t *testing.T, inner Provider) *RecordingProvider {
+	t.Helper()
+
+	previousConfig, previousProviders := config, weatherProviders
+	t.Cleanup(func() { config, weatherProviders = previousConfig, previousProviders })
+
+	recorder := &RecordingProvider{Provider: inner}
+	weatherProviders = []Provider{recorder}
+	config.CacheTTL = 0
+
+	return recorder
+}
+
+// TestBatchDedupesRepeatedCities checks that a batch asking for the same city several times, in
+// any spelling, makes a single upstream call for it.
+func TestBatchDedupesRepeatedCities(t *testing.T) {
+	recorder := withRecordingProvider(t, fakeProvider{name: "primary", delay: 20 * time.Millisecond})
+
+	ctx, w := newBatchContext(t, []string{"Reykjavik", "Quito", "reykjavik", " REYKJAVIK", "Quito"})
+	getWeatherBatch(ctx)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+
+	calls := make(map[string]int)
+	for _, call := range recorder.Calls() {
+		calls[normalizeLocation(call.location)]++
+	}
+	if len(calls) != 2 || calls["reykjavik"] != 1 || calls["quito"] != 1 {
+		t.Errorf("Expected a single upstream call for each of the 2 cities, got %v", recorder.Calls())
+	}
+}
+
+func TestCurrentWeatherFallbackAppliesEachProviderTimeout(t *testing.T) {
+	previous := config
+	t.Cleanup(func() { config = previous })
+
+	config.ProviderTimeouts = map[string]time.Duration{
+		"primary":  20 * time.Millisecond,
+		"fallback": time.Second,
+	}
+
+	// The fallback takes longer than the primary's timeout, so it only succeeds with its own
+	providers := []Provider{
+		fakeProvider{name: "primary", delay: time.Second},
+		fakeProvider{name: "fallback", delay: 100 * time.Millisecond},
+	}
+
+	start := time.Now()
+	data, err := currentWeatherWithFallback(context.Background(), providers, "Tokyo")
+	elapsed := time.Since(start)
+
+	if err != nil {
+		t.Fatalf("Expected the fallback to answer, got %v", err)
+	}
+	if data.Name != "Tokyo from fallback" {
+		t.Errorf("Expected the fallback's data, got %q", data.Name)
+	}
+	if elapsed > 500*time.Millisecond {
+		t.Errorf("Expected the slow primary to be cut off by its own timeout, took %s", elapsed)
+	}
+}
+
+func TestCurrentWeatherFallbackAllFail(t *testing.T) {
+	previous := config
+	t.Cleanup(func() { config = previous })
+
+	config.ProviderTimeouts = map[string]time.Duration{"primary": 10 * time.Millisecond, "fallback": 10 * time.Millisecond}
+
+	providers := []Provider{
+		fakeProvider{name: "primary", delay: time.Second},
+		fakeProvider{name: "fallback", delay: time.Second},
+	}
+
+	_, err := currentWeatherWithFallback(context.Background(), providers, "Tokyo")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the providers' deadline errors, got %v", err)
+	}
+	for _, name := range []string{"primary", "fallback"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("Expected %s in error %q", name, err)
+		}
+	}
+}
+
+// TestWeatherResponseFlagsFallbackProvider checks that weather served by the fallback because the
+// primary failed is flagged as degraded with the fallback's name, and primary data is not.
+func TestWeatherResponseFlagsFallbackProvider(t *testing.T) {
+	previousConfig, previousProviders := config, weatherProviders
+	t.Cleanup(func() { config, weatherProviders = previousConfig, previousProviders })
+
+	config.ProviderTimeouts = map[string]time.Duration{"primary": 10 * time.Millisecond}
+
+	request := func() map[string]any {
+		w := httptest.NewRecorder()
+		ctx, _ := gin.CreateTestContext(w)
+		ctx.Params = []gin.Param{{Key: "location", Value: "Tokyo"}}
+		ctx.Request, _ = http.NewRequest(http.MethodGet, "/weather/Tokyo", nil)
+
+		getWeatherInternational(ctx)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status code 200, got %v", w.Code)
+		}
+
+		var data map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+			t.Fatalf("Error unmarshalling JSON response: %v", err)
+		}
+		return data
+	}
+
+	weatherProviders = []Provider{
+		fakeProvider{name: "primary", delay: time.Second},
+		fakeProvider{name: "backup"},
+	}
+
+	data := request()
+	if data["degraded"] != true || data["provider"] != "backup" {
+		t.Errorf("Expected a degraded response from backup, got %v", data)
+	}
+
+	weatherProviders = []Provider{
+		fakeProvider{name: "primary"},
+		fakeProvider{name: "backup"},
+	}
+
+	data = request()
+	if _, ok := data["degraded"]; ok {
+		t.Errorf("Expected no degraded flag from the primary, got %v", data)
+	}
+}
+
+func TestLoadConfigProviderTimeouts(t *testing.T) {
+	t.Setenv("WEATHER_PROVIDER_TIMEOUTS", "OpenWeather=300ms, backup=2s")
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cfg.ProviderTimeouts["openweather"] != 300*time.Millisecond || cfg.ProviderTimeouts["backup"] != 2*time.Second {
+		t.Errorf("Unexpected provider timeouts %v", cfg.ProviderTimeouts)
+	}
+
+	for _, value := range []string{"openweather", "openweather=fast", "openweather=0s", "=1s"} {
+		t.Setenv("WEATHER_PROVIDER_TIMEOUTS", value)
+		if _, err := loadConfig(); err == nil {
+			t.Errorf("Expected %q to be rejected", value)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package weather
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Ways /weather/query resolves a request giving both a city name and coordinates, see WeatherConfig.LocationPrecedence.
+const (
+	// Use the coordinates and ignore the name (default)
+	precedenceCoordinates = "coordinates"
+	// Use the name and ignore the coordinates
+	precedenceName = "name"
+	// Reject the request as ambiguous
+	precedenceReject = "reject"
+)
+
+var locationPrecedences = []string{precedenceCoordinates, precedenceName, precedenceReject}
+
+// locationQuery is the location of a /weather/query request, either a city name or coordinates.
+type locationQuery struct {
+	name        string
+	coordinates *Coordinates
+}
+
+/*
+parseLocationQuery reads the location of the request from its q, lat and lon query parameters.
+
+- q alone looks the city up by name, lat and lon alone by coordinates.
+- q with lat and lon is resolved by WeatherConfig.LocationPrecedence: the coordinates win by
+default, since they cannot be ambiguous the way a city name can, and the request is rejected
+with the "reject" precedence.
+- lat without lon, or lon without lat, is always rejected, whether q is given or not: the
+position is incomplete and silently falling back to the name would hide the mistake.
+- Invalid coordinates are rejected unless they are ignored because the name wins.
+*/
+func parseLocationQuery(ctx *gin.Context) (locationQuery, error) {
+	name := ctx.Query("q")
+	_, hasLat := ctx.GetQuery("lat")
+	_, hasLon := ctx.GetQuery("lon")
+
+	if hasLat != hasLon {
+		return locationQuery{}, errors.New("lat and lon must be given together")
+	}
+
+	if !hasLat {
+		if name == "" {
+			return locationQuery{}, errors.New("q or lat and lon are required")
+		}
+		return locationQuery{name: name}, nil
+	}
+
+	if name != "" {
+		switch config.LocationPrecedence {
+		case precedenceName:
+			return locationQuery{name: name}, nil
+		case precedenceReject:
+			return locationQuery{}, errors.New("give either q or lat and lon, not both")
+		}
+	}
+
+	coordinates, err := parseCoordinates(ctx)
+	if err != nil {
+		return locationQuery{}, err
+	}
+
+	return locationQuery{coordinates: &coordinates}, nil
+}
+
+// getWeatherQuery retrieves the current weather for a location given by name or by coordinates.
+//
+// Parameters:
+// ctx (gin.Context): The Gin context containing request and response objects. The location is read from the
+// "q" or "lat" and "lon" query parameters, see parseLocationQuery. The optional "display" and "units" query
+// parameters select the temperature scale and the units the upstream is queried in, as for /weather/:location.
+//
+// Return:
+// None. The function responds with the weather data as JSON, an HTTP 400 status code for a missing or
+// ambiguous location, or an HTTP 500 status code if the fetch fails.
+func getWeatherQuery(ctx *gin.Context) {
+
+	units := parseUnits(ctx)
+
+	display, err := parseTemperatureDisplay(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	query, err := parseLocationQuery(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var weatherData WeatherData
+	if query.coordinates != nil {
+		weatherData, err = instrumentedSendWeatherRequestByCoordinates(ctx.Request.Context(), *query.coordinates, units)
+	} else {
+		weatherData, err = fetchWeatherSharedContext(ctx.Request.Context(), query.name, units, 0)
+	}
+
+	if errors.Is(err, ErrCityNotFound) {
+		ctx.JSON(http.StatusNotFound, cityNotFoundResponse(query.name))
+		return
+	}
+	if err != nil {
+		logger.Error("Error fetching weather data", "error", err)
+		ctx.JSON(http.StatusInternalServerError, failedFetchResponse("Failed to fetch weather data", err))
+		return
+	}
+
+	if notModifiedSince(ctx, weatherData) {
+		ctx.AbortWithStatus(http.StatusNotModified)
+		return
+	}
+
+	if isStaleObservation(weatherData, time.Now()) {
+		ctx.Header("Warning", staleObservationWarning)
+	}
+
+	response := weatherResponse(weatherData, display)
+	response["units"] = units
+
+	ctx.JSON(http.StatusOK, response)
+
+}
+
+func instrumentedGetWeatherQuery(ctx *gin.Context) {
+	traceCtx, span := tracer.Start(ctx.Request.Context(), "getWeatherQuery")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("method", ctx.Request.Method),
+		attribute.String("path", ctx.Request.URL.Path),
+	)
+
+	start := time.Now()
+	weatherRequestCounter.Add(traceCtx, 1,
+		metric.WithAttributes(attribute.Key("endpoint").String("getWeatherQuery")))
+	getWeatherQuery(ctx)
+	duration := time.Since(start).Seconds()
+	weatherRequestDuration.Record(traceCtx, duration,
+		metric.WithAttributes(attribute.Key("endpoint").String("getWeatherQuery")))
+
+	span.SetAttributes(attribute.Int("http.status_code", ctx.Writer.Status()))
+}
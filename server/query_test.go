@@ -0,0 +1,112 @@
+package weather
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestWeatherQueryLocationPrecedence(t *testing.T) {
+	// How the upstream was asked for the location, by "name" or by "coordinates"
+	var resolvedBy string
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		resolvedBy = "name"
+		if r.URL.Query().Has("lat") {
+			resolvedBy = "coordinates"
+		}
+		w.Write([]byte(`{"name": "Tokyo", "main": {"temp": 290}}`))
+	})
+
+	tests := []struct {
+		name       string
+		precedence string
+		query      string
+		wantStatus int
+		wantBy     string
+	}{
+		{"nothing", precedenceCoordinates, "", http.StatusBadRequest, ""},
+		{"name only", precedenceCoordinates, "q=Tokyo", http.StatusOK, "name"},
+		{"coordinates only", precedenceCoordinates, "lat=35.68&lon=139.69", http.StatusOK, "coordinates"},
+		{"lat only", precedenceCoordinates, "lat=35.68", http.StatusBadRequest, ""},
+		{"lon only", precedenceCoordinates, "lon=139.69", http.StatusBadRequest, ""},
+		{"name and lat", precedenceCoordinates, "q=Tokyo&lat=35.68", http.StatusBadRequest, ""},
+		{"name and lon", precedenceName, "q=Tokyo&lon=139.69", http.StatusBadRequest, ""},
+		{"both, coordinates win", precedenceCoordinates, "q=Tokyo&lat=35.68&lon=139.69", http.StatusOK, "coordinates"},
+		{"both, name wins", precedenceName, "q=Tokyo&lat=35.68&lon=139.69", http.StatusOK, "name"},
+		{"both, rejected", precedenceReject, "q=Tokyo&lat=35.68&lon=139.69", http.StatusBadRequest, ""},
+		{"both, invalid coordinates win", precedenceCoordinates, "q=Tokyo&lat=95&lon=139.69", http.StatusBadRequest, ""},
+		{"both, invalid coordinates ignored", precedenceName, "q=Tokyo&lat=95&lon=139.69", http.StatusOK, "name"},
+		{"invalid coordinates only", precedenceName, "lat=95&lon=139.69", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous := config
+			t.Cleanup(func() { config = previous })
+			config.LocationPrecedence = tt.precedence
+			resolvedBy = ""
+
+			w := httptest.NewRecorder()
+			ctx, _ := gin.CreateTestContext(w)
+			ctx.Request, _ = http.NewRequest(http.MethodGet, "/weather/query?"+tt.query, nil)
+
+			instrumentedGetWeatherQuery(ctx)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("Expected status code %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+			if resolvedBy != tt.wantBy {
+				t.Errorf("Expected the location to be resolved by %q, got %q", tt.wantBy, resolvedBy)
+			}
+		})
+	}
+}
+
+func TestWeatherQueryForwardsUnits(t *testing.T) {
+	var upstreamUnits []string
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		upstreamUnits = append(upstreamUnits, r.URL.Query().Get("units"))
+		fmt.Fprintf(w, `{"name": "Tokyo", "main": {"temp": %v}}`, inRequestedUnits(r, 290))
+	})
+
+	for _, query := range []string{"q=Tokyo", "lat=35.68&lon=139.69"} {
+		upstreamUnits = nil
+
+		w := httptest.NewRecorder()
+		ctx, _ := gin.CreateTestContext(w)
+		ctx.Request, _ = http.NewRequest(http.MethodGet, "/weather/query?units=imperial&"+query, nil)
+
+		getWeatherQuery(ctx)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected status code %d, got %d: %s", query, http.StatusOK, w.Code, w.Body.String())
+		}
+		if len(upstreamUnits) != 1 || upstreamUnits[0] != unitsImperial {
+			t.Errorf("%s: expected the upstream to be queried in imperial units, got %v", query, upstreamUnits)
+		}
+
+		var response map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("%s: failed to decode the response: %v", query, err)
+		}
+		if response["units"] != unitsImperial {
+			t.Errorf("%s: expected the response to report imperial units, got %v", query, response["units"])
+		}
+	}
+}
+
+func TestLoadConfigLocationPrecedence(t *testing.T) {
+	t.Setenv("WEATHER_LOCATION_PRECEDENCE", "name")
+	if cfg, err := loadConfig(); err != nil || cfg.LocationPrecedence != precedenceName {
+		t.Errorf("Expected the name precedence, got %q (%v)", cfg.LocationPrecedence, err)
+	}
+
+	t.Setenv("WEATHER_LOCATION_PRECEDENCE", "both")
+	if _, err := loadConfig(); err == nil {
+		t.Error("Expected an unknown precedence to be rejected")
+	}
+}
@@ -0,0 +1,107 @@
+package weather
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxTrackedClients bounds the number of client buckets kept before idle ones are pruned.
+const maxTrackedClients = 10000
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// ipRateLimiter is a token bucket per client IP: each IP may burst up to burst requests,
+// and regains rate tokens per second after that.
+type ipRateLimiter struct {
+	mutex   sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*tokenBucket
+
+	// Clock, replaced in tests
+	now func() time.Time
+}
+
+func newIPRateLimiter(rate float64, burst int) *ipRateLimiter {
+	return &ipRateLimiter{
+		rate:    rate,
+		burst:   math.Max(float64(burst), 1),
+		buckets: make(map[string]*tokenBucket),
+		now:     time.Now,
+	}
+}
+
+// allow takes a token from the bucket of ip.
+//
+// Parameters:
+// ip (string): The client IP the request came from.
+//
+// Return:
+// bool: true if the request may proceed.
+// time.Duration: When rejected, how long until the bucket refills enough for one request.
+func (l *ipRateLimiter) allow(ip string) (bool, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.now()
+
+	bucket, ok := l.buckets[ip]
+	if !ok {
+		if len(l.buckets) >= maxTrackedClients {
+			l.prune(now)
+		}
+		bucket = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[ip] = bucket
+	}
+
+	// Refill for the time elapsed since the last request
+	bucket.tokens = math.Min(l.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate)
+	bucket.last = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+
+	wait := time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+// prune drops the buckets that have refilled completely, they behave exactly like a new bucket.
+func (l *ipRateLimiter) prune(now time.Time) {
+	for ip, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, ip)
+		}
+	}
+}
+
+// rateLimitMiddleware rejects clients exceeding their token bucket with 429 Too Many Requests.
+//
+// The response tells the client when to retry, both in the Retry-After header and in the
+// JSON body as retry_after_seconds, rounded up to the next whole second.
+func rateLimitMiddleware(limiter *ipRateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		allowed, wait := limiter.allow(c.ClientIP())
+		if allowed {
+			c.Next()
+			return
+		}
+
+		retryAfter := int(math.Ceil(wait.Seconds()))
+
+		c.Header("Retry-After", strconv.Itoa(retryAfter))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+			"error":               "rate limited",
+			"retry_after_seconds": retryAfter,
+		})
+	}
+}
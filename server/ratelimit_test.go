@@ -0,0 +1,110 @@
+package weather
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRateLimitRetryAfterMatchesBody(t *testing.T) {
+	now := time.Date(2025, 4, 13, 15, 0, 0, 0, time.UTC)
+
+	// One request every 4 seconds, no burst
+	limiter := newIPRateLimiter(0.25, 1)
+	limiter.now = func() time.Time { return now }
+
+	router := gin.New()
+	router.Use(rateLimitMiddleware(limiter))
+	router.GET("/", getHandleDefaultRoute)
+
+	request := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "203.0.113.7:4242"
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := request(); w.Code != http.StatusOK {
+		t.Fatalf("Expected the first request to pass, got %d", w.Code)
+	}
+
+	now = now.Add(time.Second)
+	w := request()
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status %d, got %d", http.StatusTooManyRequests, w.Code)
+	}
+
+	var data struct {
+		Error             string `json:"error"`
+		RetryAfterSeconds int    `json:"retry_after_seconds"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+		t.Fatalf("Error unmarshalling JSON response: %v", err)
+	}
+
+	if data.Error != "rate limited" {
+		t.Errorf("Expected error %q, got %q", "rate limited", data.Error)
+	}
+	if data.RetryAfterSeconds != 3 {
+		t.Errorf("Expected to retry after 3 seconds, got %d", data.RetryAfterSeconds)
+	}
+	if header := w.Header().Get("Retry-After"); header != strconv.Itoa(data.RetryAfterSeconds) {
+		t.Errorf("Retry-After header %q does not match body %d", header, data.RetryAfterSeconds)
+	}
+
+	now = now.Add(3 * time.Second)
+	if w := request(); w.Code != http.StatusOK {
+		t.Errorf("Expected the request after the refill to pass, got %d", w.Code)
+	}
+}
+
+func TestRateLimitIgnoresForwardedForFromUntrustedProxies(t *testing.T) {
+	request := func(router *gin.Engine, forwardedFor string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "203.0.113.7:4242"
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	for _, tt := range []struct {
+		proxies []string
+		want    int
+	}{
+		{nil, http.StatusTooManyRequests},
+		{[]string{"203.0.113.0/24"}, http.StatusOK},
+	} {
+		router := gin.New()
+		if err := router.SetTrustedProxies(tt.proxies); err != nil {
+			t.Fatalf("Error setting the trusted proxies %v: %v", tt.proxies, err)
+		}
+		router.Use(rateLimitMiddleware(newIPRateLimiter(1, 1)))
+		router.GET("/", getHandleDefaultRoute)
+
+		request(router, "198.51.100.1")
+		if code := request(router, "198.51.100.2"); code != tt.want {
+			t.Errorf("Trusted proxies %v: expected status %d for a rotated X-Forwarded-For, got %d", tt.proxies, tt.want, code)
+		}
+	}
+}
+
+func TestRateLimitIsPerClient(t *testing.T) {
+	limiter := newIPRateLimiter(1, 1)
+
+	if ok, _ := limiter.allow("203.0.113.7"); !ok {
+		t.Fatal("Expected the first client to pass")
+	}
+	if ok, _ := limiter.allow("203.0.113.7"); ok {
+		t.Error("Expected the first client to be limited")
+	}
+	if ok, _ := limiter.allow("198.51.100.1"); !ok {
+		t.Error("Expected another client to be unaffected")
+	}
+}
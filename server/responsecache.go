@@ -0,0 +1,154 @@
+package weather
+
+import (
+	"bytes"
+	"container/list"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// cachedHeaders are the response headers replayed along a cached body.
+var cachedHeaders = []string{"Content-Type", "Last-Modified", "ETag", "Warning"}
+
+type cachedResponse struct {
+	key     string
+	expires time.Time
+	header  http.Header
+	body    []byte
+}
+
+// responseCache holds serialized responses keyed by request, evicting the least recently used
+// entry once it holds size entries.
+type responseCache struct {
+	mutex   sync.Mutex
+	ttl     time.Duration
+	size    int
+	entries map[string]*list.Element
+	order   *list.List
+
+	// Clock, replaced in tests
+	now func() time.Time
+}
+
+func newResponseCache(ttl time.Duration, size int) *responseCache {
+	return &responseCache{
+		ttl:     ttl,
+		size:    max(size, 1),
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+		now:     time.Now,
+	}
+}
+
+func (c *responseCache) get(key string) (*cachedResponse, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	response := element.Value.(*cachedResponse)
+	if c.now().After(response.expires) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	c.order.MoveToFront(element)
+	return response, true
+}
+
+func (c *responseCache) set(key string, header http.Header, body []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	response := &cachedResponse{key: key, expires: c.now().Add(c.ttl), header: header, body: body}
+
+	if element, ok := c.entries[key]; ok {
+		element.Value = response
+		c.order.MoveToFront(element)
+		return
+	}
+
+	if c.order.Len() >= c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedResponse).key)
+	}
+
+	c.entries[key] = c.order.PushFront(response)
+}
+
+// responseRecorder copies the body written by the handler so it can be cached.
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *responseRecorder) WriteString(data string) (int, error) {
+	r.body.WriteString(data)
+	return r.ResponseWriter.WriteString(data)
+}
+
+// responseCacheKey identifies a request by everything the response may depend on.
+func responseCacheKey(c *gin.Context) string {
+	return c.Request.URL.Path + "?" + c.Request.URL.RawQuery + "|" + c.GetHeader("Accept")
+}
+
+/*
+responseCacheMiddleware serves repeated identical GET requests from the serialized bytes of
+the first response, skipping the handler and the JSON serialization altogether.
+
+Requests are keyed by path, query and Accept header. Only 200 responses are stored, and
+conditional requests (If-Modified-Since, If-None-Match) bypass the cache so the handler can answer 304.
+
+Parameters:
+- cache: The cache to serve from, nil disables the middleware.
+*/
+func responseCacheMiddleware(cache *responseCache) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if cache == nil || c.Request.Method != http.MethodGet || c.GetHeader("If-Modified-Since") != "" || c.GetHeader("If-None-Match") != "" {
+			c.Next()
+			return
+		}
+
+		key := responseCacheKey(c)
+
+		if response, ok := cache.get(key); ok {
+			for name, values := range response.header {
+				c.Writer.Header()[name] = values
+			}
+			c.Writer.WriteHeader(http.StatusOK)
+			c.Writer.Write(response.body)
+			c.Abort()
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+
+		if recorder.Status() != http.StatusOK {
+			return
+		}
+
+		header := http.Header{}
+		for _, name := range cachedHeaders {
+			if values := recorder.Header().Values(name); len(values) > 0 {
+				header[name] = values
+			}
+		}
+
+		cache.set(key, header, recorder.body.Bytes())
+	}
+}
@@ -0,0 +1,95 @@
+package weather
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newCachedRouter serves a handler counting its calls behind the response cache.
+func newCachedRouter(cache *responseCache, calls *int) *gin.Engine {
+	router := gin.New()
+	router.GET("/weather/:location", responseCacheMiddleware(cache), func(ctx *gin.Context) {
+		*calls++
+		ctx.Header("Last-Modified", "Sun, 12 Oct 2026 10:00:00 GMT")
+		ctx.JSON(http.StatusOK, gin.H{"city": ctx.Param("location"), "call": *calls})
+	})
+	return router
+}
+
+func TestResponseCacheReplaysIdenticalBytes(t *testing.T) {
+	calls := 0
+	router := newCachedRouter(newResponseCache(time.Minute, 10), &calls)
+
+	first := httptest.NewRecorder()
+	router.ServeHTTP(first, httptest.NewRequest(http.MethodGet, "/weather/Tokyo?display=both", nil))
+
+	second := httptest.NewRecorder()
+	router.ServeHTTP(second, httptest.NewRequest(http.MethodGet, "/weather/Tokyo?display=both", nil))
+
+	if calls != 1 {
+		t.Fatalf("Expected the handler to run once, ran %d times", calls)
+	}
+	if second.Code != http.StatusOK || !bytes.Equal(first.Body.Bytes(), second.Body.Bytes()) {
+		t.Errorf("Expected identical bytes, got %q and %q", first.Body.String(), second.Body.String())
+	}
+	for _, name := range []string{"Content-Type", "Last-Modified"} {
+		if first.Header().Get(name) != second.Header().Get(name) {
+			t.Errorf("Expected header %s %q to be replayed, got %q", name, first.Header().Get(name), second.Header().Get(name))
+		}
+	}
+
+	other := httptest.NewRecorder()
+	router.ServeHTTP(other, httptest.NewRequest(http.MethodGet, "/weather/Tokyo?display=celsius", nil))
+	if calls != 2 {
+		t.Errorf("Expected a different query to miss the cache, handler ran %d times", calls)
+	}
+}
+
+func TestResponseCacheExpiresAndEvicts(t *testing.T) {
+	calls := 0
+	cache := newResponseCache(time.Minute, 2)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	router := newCachedRouter(cache, &calls)
+
+	get := func(path string) {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	get("/weather/Tokyo")
+	get("/weather/London")
+	get("/weather/Paris") // Evicts Tokyo, the least recently used
+	get("/weather/London")
+	if calls != 3 {
+		t.Fatalf("Expected London to be served from the cache, handler ran %d times", calls)
+	}
+
+	get("/weather/Tokyo")
+	if calls != 4 {
+		t.Errorf("Expected Tokyo to have been evicted, handler ran %d times", calls)
+	}
+
+	now = now.Add(2 * time.Minute)
+	get("/weather/Tokyo")
+	if calls != 5 {
+		t.Errorf("Expected the entry to have expired, handler ran %d times", calls)
+	}
+}
+
+func TestResponseCacheDisabled(t *testing.T) {
+	calls := 0
+	router := newCachedRouter(nil, &calls)
+
+	for range 2 {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/weather/Tokyo", nil))
+	}
+
+	if calls != 2 {
+		t.Errorf("Expected every request to reach the handler, ran %d times", calls)
+	}
+}
@@ -4,9 +4,11 @@ import (
 	"context"
 	stdlog "log"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -25,6 +27,7 @@ import (
 	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/netutil"
 )
 
 var (
@@ -35,6 +38,10 @@ var (
 	traceProvider          *sdktrace.TracerProvider
 	weatherRequestDuration metric.Float64Histogram
 	weatherRequestCounter  metric.Float64Counter
+	upstreamSlotWait       metric.Float64Histogram
+	staleServedCounter     metric.Float64Counter
+	cacheSweptCounter      metric.Float64Counter
+	upstreamErrorCounter   metric.Float64Counter
 	tracer                 trace.Tracer
 )
 
@@ -62,8 +69,64 @@ func otelMiddleware() gin.HandlerFunc {
 	}
 }
 
+// newRequestDurationHistogram returns the Prometheus histogram of request durations served on /metrics,
+// the one exemplarMiddleware attaches trace IDs to.
+func newRequestDurationHistogram() *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "weather_http_request_duration_seconds",
+		Help:    "Histogram of response time for handler in seconds, with trace ID exemplars.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "endpoint"})
+}
+
+/*
+exemplarMiddleware records the duration of every request in histogram, with the trace ID of the
+request as an exemplar, so a latency spike on a dashboard links to the trace that caused it.
+
+It starts the request span itself, so the spans of the instrumented handlers become its children
+and share its trace ID. With tracing disabled the span context is invalid and the durations are
+recorded without exemplars. Prometheus only scrapes exemplars in the OpenMetrics format, see
+WeatherConfig.MetricsOpenMetrics.
+*/
+func exemplarMiddleware(histogram *prometheus.HistogramVec) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		traceCtx, span := tracer.Start(c.Request.Context(), c.Request.Method+" "+c.FullPath())
+		defer span.End()
+		c.Request = c.Request.WithContext(traceCtx)
+
+		start := time.Now()
+
+		c.Next()
+
+		duration := time.Since(start).Seconds()
+		observer := histogram.WithLabelValues(c.Request.Method, c.FullPath())
+
+		spanContext := span.SpanContext()
+		if exemplarObserver, ok := observer.(prometheus.ExemplarObserver); ok && spanContext.IsSampled() {
+			exemplarObserver.ObserveWithExemplar(duration, prometheus.Labels{"trace_id": spanContext.TraceID().String()})
+			return
+		}
+		observer.Observe(duration)
+	}
+}
+
 func initMetrics(m metric.Meter) {
 	var err error
+	httpRequestsTotal, err = m.Float64Counter(
+		"http_requests_total",
+		metric.WithDescription("Total number of HTTP requests"),
+	)
+	if err != nil {
+		stdlog.Fatal(err)
+	}
+	httpRequestDuration, err = m.Float64Histogram(
+		"http_request_duration_seconds",
+		metric.WithDescription("Histogram of response time for handler in seconds"),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		stdlog.Fatal(err)
+	}
 	weatherRequestDuration, err = m.Float64Histogram(
 		"weather_request_duration_seconds",
 		metric.WithDescription("Histogram of response time for weather requests in seconds"),
@@ -79,15 +142,218 @@ func initMetrics(m metric.Meter) {
 	if err != nil {
 		stdlog.Fatal(err)
 	}
+	upstreamSlotWait, err = m.Float64Histogram(
+		"weather_upstream_slot_wait_seconds",
+		metric.WithDescription("Histogram of the time upstream requests wait for a free upstream slot in seconds"),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		stdlog.Fatal(err)
+	}
+
+	staleServedCounter, err = m.Float64Counter(
+		"weather_stale_served_total",
+		metric.WithDescription("Total number of cached entries served past their TTL because the upstream fetch failed"),
+	)
+	if err != nil {
+		stdlog.Fatal(err)
+	}
+
+	cacheSweptCounter, err = m.Float64Counter(
+		"weather_cache_swept_total",
+		metric.WithDescription("Total number of expired cache entries removed by the background sweep"),
+	)
+	if err != nil {
+		stdlog.Fatal(err)
+	}
+
+	upstreamErrorCounter, err = m.Float64Counter(
+		"weather_upstream_errors_total",
+		metric.WithDescription("Total number of failed upstream requests by endpoint and error_type"),
+	)
+	if err != nil {
+		stdlog.Fatal(err)
+	}
 
 	// Initialize tracer from global provider
 	tracer = otel.Tracer("weather-service")
 }
 
+// forceExitOnSignal waits for one more signal on quit and exits right away with exit, so an
+// operator can cut a graceful shutdown short with a second Ctrl-C instead of waiting it out.
+func forceExitOnSignal(quit <-chan os.Signal, exit func(int)) {
+	sig := <-quit
+	logger.Warn("Received a second signal, forcing exit", "signal", sig.String())
+	exit(1)
+}
+
+// newListener listens on addr and, when maxConnections is positive, caps the number of simultaneous
+// TCP connections the server accepts at maxConnections.
+//
+// Connections beyond the cap are not refused, they wait in the kernel accept queue until a slot
+// frees up. This is a coarse flood protection: it bounds the goroutines and sockets of the server
+// itself, whereas WeatherConfig.MaxUpstreamRequests bounds the calls made to the upstream API.
+// Idle keep-alive connections hold a slot, so the cap should stay well above the number of
+// clients expected to keep connections open.
+func newListener(addr string, maxConnections int) (net.Listener, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if maxConnections > 0 {
+		listener = netutil.LimitListener(listener, maxConnections)
+	}
+
+	return listener, nil
+}
+
+// registerStressRoutes registers the /weather/stress0 to /weather/stress3 benchmarking routes on
+// router, each behind cached, unless WeatherConfig.StressEndpoints disables them.
+func registerStressRoutes(router gin.IRoutes, cached gin.HandlerFunc) {
+	if !config.StressEndpoints {
+		return
+	}
+
+	router.GET("/weather/stress0", cached, instrumentedGetWeatherStressTest0)
+	router.GET("/weather/stress1", cached, instrumentedGetWeatherStressTest1)
+	router.GET("/weather/stress2", cached, instrumentedGetWeatherStressTest2)
+	router.GET("/weather/stress3", cached, instrumentedGetWeatherStressTest3)
+}
+
+// registerRoutes registers every route of the service on router, with their response cache, cache
+// control and body limit middlewares, and serves the metrics of registry on /metrics.
+func registerRoutes(router *gin.Engine, registry *prometheus.Registry) {
+	// Replay serialized responses of the weather routes for identical requests
+	var responses *responseCache
+	if config.ResponseCacheTTL > 0 {
+		responses = newResponseCache(config.ResponseCacheTTL, config.ResponseCacheSize)
+	}
+	cached := responseCacheMiddleware(responses)
+
+	// Let clients and proxies reuse responses for as long as each kind of data stays relevant
+	current := cacheControlMiddleware(config.CurrentMaxAge)
+	forecast := cacheControlMiddleware(config.ForecastMaxAge)
+	noStore := cacheControlMiddleware(0)
+
+	// Bound the size of uploaded bodies
+	bodyLimit := bodyLimitMiddleware(int64(config.MaxBodyBytes))
+
+	// Define routes
+	router.GET("/", getHandleDefaultRoute)
+	router.GET("/weather", current, cached, instrumentedGetWeatherLocal)
+	router.GET("/weather/:location", current, cached, instrumentedGetWeatherInternational)
+	router.GET("/weather/:location/summary", current, cached, instrumentedGetWeatherSummary)
+	router.GET("/weather/:location/consensus", current, cached, instrumentedGetWeatherConsensus)
+	router.GET("/weather/query", current, cached, instrumentedGetWeatherQuery)
+
+	registerStressRoutes(router, cached)
+
+	router.POST("/weather/batch", bodyLimit, instrumentedGetWeatherBatch)
+	router.GET("/weather/group/:name", current, cached, instrumentedGetWeatherGroup)
+	router.GET("/weather/airport/:code", current, cached, instrumentedGetWeatherAirport)
+
+	router.GET("/forecast/:location", forecast, cached, instrumentedGetForecast)
+	router.POST("/forecast/batch", bodyLimit, instrumentedGetForecastBatch)
+
+	router.GET("/astro/:location", forecast, cached, instrumentedGetAstro)
+	router.GET("/trend/:location", current, cached, instrumentedGetTrend)
+
+	router.GET("/alerts/coords", current, cached, instrumentedGetWeatherAlerts)
+	router.GET("/uv/coords", current, cached, instrumentedGetUVIndex)
+
+	router.GET("/last-seen", getLastSeen)
+
+	if config.StatsEnabled {
+		router.GET("/stats", getStats)
+	}
+
+	if config.AdminToken != "" {
+		admin := router.Group("/admin", noStore, adminAuthMiddleware(config.AdminToken))
+		admin.PUT("/config/timeout", bodyLimit, putClientTimeout)
+		admin.GET("/cache", getCacheSummary)
+		admin.GET("/circuit", getCircuitStatus)
+		if config.StatsEnabled {
+			admin.GET("/stats", getStats)
+		}
+	}
+
+	router.GET("/metrics", gin.WrapH(metricsHandler(registry, config.MetricsOpenMetrics)))
+}
+
+// Trailing slash behaviors, see WeatherConfig.TrailingSlash.
+const (
+	trailingSlashRedirect = "redirect"
+	trailingSlashServe    = "serve"
+)
+
+// trailingSlashHandler makes a path with a trailing slash, such as /weather/, behave like the same
+// path without it, /weather, according to behavior.
+//
+// With "redirect", gin answers with a redirect to the path without the slash: 301 for GET requests
+// and 307 for the others, so the method and body are kept. With "serve", the slash is stripped
+// before routing and the request is served without a round trip, for clients that do not follow
+// redirects.
+func trailingSlashHandler(router *gin.Engine, behavior string) http.Handler {
+	router.RedirectTrailingSlash = behavior != trailingSlashServe
+	if router.RedirectTrailingSlash {
+		return router
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if path := r.URL.Path; len(path) > 1 && strings.HasSuffix(path, "/") {
+			r.URL.Path = strings.TrimSuffix(path, "/")
+			r.URL.RawPath = strings.TrimSuffix(r.URL.RawPath, "/")
+		}
+		router.ServeHTTP(w, r)
+	})
+}
+
+// metricsHandler serves the metrics gathered by registry in the Prometheus text format.
+// With openMetrics, scrapers sending an OpenMetrics Accept header are answered in that format instead.
+func metricsHandler(registry *prometheus.Registry, openMetrics bool) http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{EnableOpenMetrics: openMetrics})
+}
+
+// registerMetrics registers the Prometheus metrics served on /metrics against registry. It returns the
+// request duration histogram when WeatherConfig.MetricsExemplars enables it, nil otherwise.
+func registerMetrics(registry *prometheus.Registry) *prometheus.HistogramVec {
+	registry.MustRegister(citiesServedCounter())
+	registry.MustRegister(cacheCounters()...)
+
+	if !config.MetricsExemplars {
+		return nil
+	}
+
+	histogram := newRequestDurationHistogram()
+	registry.MustRegister(histogram)
+	return histogram
+}
+
+// WeatherServer runs the weather server, with its Prometheus metrics in a registry of its own.
 func WeatherServer() {
+	WeatherServerWithRegistry(prometheus.NewRegistry())
+}
+
+// WeatherServerWithRegistry runs the weather server with its Prometheus metrics registered against
+// registry and served from it on /metrics, so an embedding application controls their registration.
+func WeatherServerWithRegistry(registry *prometheus.Registry) {
 
-	// Create a new Prometheus registry for internal metrics endpoint
-	registry := prometheus.NewRegistry()
+	cfg, err := loadConfig()
+	if err != nil {
+		stdlog.Fatal("Invalid configuration: ", err)
+	}
+	config = cfg
+	upstreamSlots = make(chan struct{}, config.MaxUpstreamRequests)
+	limitUpstreamConnections(config.UpstreamMaxConnsPerHost)
+	poolUpstreamConnections(config.MaxUpstreamRequests)
+
+	if config.DemoMode {
+		weatherProviders = []Provider{DemoProvider{}}
+	}
+
+	// Register the metrics of the internal metrics endpoint
+	histogram := registerMetrics(registry)
 
 	// Initialize metric exporter for otel-collector sidecar
 	exporter, _ := otlpmetricgrpc.New(context.Background(), otlpmetricgrpc.WithEndpoint("0.0.0.0:4317"), otlpmetricgrpc.WithInsecure())
@@ -119,56 +385,72 @@ func WeatherServer() {
 	logger = otelslog.NewLogger("weather", otelslog.WithLoggerProvider(loggerProvider))
 
 	// Create instruments
-	httpRequestsTotal, err = meter.Float64Counter(
-		"http_requests_total",
-		metric.WithDescription("Total number of HTTP requests"),
-	)
-	if err != nil {
-		logger.Error("Failed to create http_requests_total counter", "error", err)
-		stdlog.Fatal(err)
-	}
-	httpRequestDuration, err = meter.Float64Histogram(
-		"http_request_duration_seconds",
-		metric.WithDescription("Histogram of response time for handler in seconds"),
-		metric.WithUnit("s"),
-	)
-	if err != nil {
-		logger.Error("Failed to create http_request_duration_seconds histogram", "error", err)
-		stdlog.Fatal(err)
-	}
-
 	initMetrics(meter)
 
 	router := gin.Default()
+	if err := router.SetTrustedProxies(config.TrustedProxies); err != nil {
+		stdlog.Fatal("Invalid WEATHER_TRUSTED_PROXIES: ", err)
+	}
 
 	// Add OpenTelemetry middleware
 	router.Use(otelMiddleware())
+	router.Use(statsMiddleware())
+	router.Use(servedByMiddleware(config.InstanceID))
 
-	// Define routes
-	router.GET("/", getHandleDefaultRoute)
-	router.GET("/weather", instrumentedGetWeatherLocal)
-	router.GET("/weather/:location", instrumentedGetWeatherInternational)
+	if histogram != nil {
+		router.Use(exemplarMiddleware(histogram))
+	}
+
+	if config.RateLimit > 0 {
+		router.Use(rateLimitMiddleware(newIPRateLimiter(config.RateLimit, config.RateBurst)))
+	}
 
-	router.GET("/weather/stress0", instrumentedGetWeatherStressTest0)
-	router.GET("/weather/stress1", instrumentedGetWeatherStressTest1)
-	router.GET("/weather/stress2", instrumentedGetWeatherStressTest2)
-	router.GET("/weather/stress3", instrumentedGetWeatherStressTest3)
+	// Keep the local weather fresh in the background when /weather never calls upstream itself
+	refreshCtx, stopRefresh := context.WithCancel(context.Background())
+	defer stopRefresh()
+	if config.LocalCacheOnly {
+		go refreshLocalWeather(refreshCtx, config.LocalRefreshInterval)
+	}
+
+	// Drop the expired entries of cities nobody asks for anymore, which are never looked up again
+	if config.CacheTTL > 0 && config.CacheSweepInterval > 0 {
+		go sweepWeatherCache(refreshCtx, config.CacheSweepInterval)
+	}
 
-	router.GET("/metrics", gin.WrapH(promhttp.HandlerFor(registry, promhttp.HandlerOpts{})))
+	registerRoutes(router, registry)
 
 	logger.Info("Starting gin gonic on :8081")
 
 	srv := &http.Server{
 		Addr:    ":8081",
-		Handler: router,
+		Handler: trailingSlashHandler(router, config.TrailingSlash),
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	if config.WarmupConnections > 0 && !config.DemoMode {
+		failed := warmUpstreamConnections(config.WarmupConnections)
+		logger.Info("Warmed upstream connections", "count", config.WarmupConnections, "failed", failed)
+	}
+
+	// Fill the cache in the background, bounded and staggered so the boot does not burst the upstream
+	if len(config.WarmupCities) > 0 && config.CacheTTL > 0 {
+		go func() {
+			failed := preloadCities(refreshCtx, config.WarmupCities, config.WarmupConcurrency, config.WarmupStagger)
+			logger.Info("Preloaded cities", "count", len(config.WarmupCities), "failed", failed)
+		}()
+	}
+
+	listener, err := newListener(srv.Addr, config.MaxConnections)
+	if err != nil {
+		logger.Error("Failed to start server", "error", err)
+		stdlog.Fatalf("listen: %v\n", err)
+	}
+
 	go func() {
 		// service connections
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := srv.Serve(listener); err != nil && err != http.ErrServerClosed {
 			logger.Error("Failed to start server", "error", err)
 			stdlog.Fatalf("listen: %v\n", err)
 		}
@@ -182,11 +464,17 @@ func WeatherServer() {
 
 	logger.Info("Shutdown Server ...")
 
+	// A second signal during the graceful shutdown forces the exit
+	go forceExitOnSignal(quit, os.Exit)
+
 	if err := srv.Shutdown(ctx); err != nil {
 		logger.Error("Server Shutdown Failed", "error", err)
 		stdlog.Fatal("Server Shutdown:", err)
 	}
 
+	// Stop the background refresh and cache sweep
+	stopRefresh()
+
 	// catching ctx.Done(). timeout of 5 seconds.
 	<-ctx.Done()
 
@@ -1,14 +1,143 @@
 package weather
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric/noop"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.uber.org/goleak"
 )
 
+// TestMain wires the package globals normally set up by WeatherServer and points the
+// upstream API at a mock server, so the tests never depend on OpenWeatherMap being reachable.
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+
+	logger = slog.Default()
+	initMetrics(noop.NewMeterProvider().Meter("weather"))
+
+	// Tests swap the upstream between cases, a cached or recently shared response would leak from one case into the next
+	config.CacheTTL = 0
+	config.DedupWindow = 0
+	// The mock upstreams stall on purpose in places, fail those fetches fast rather than after the production timeout
+	config.RequestTimeout = 200 * time.Millisecond
+
+	upstream := httptest.NewServer(http.HandlerFunc(serveCannedUpstream))
+	weatherApiHost = upstream.URL
+
+	code := m.Run()
+
+	upstream.Close()
+	os.Exit(code)
+}
+
+var cannedCountries = map[string]string{
+	"Bengaluru": "IN",
+	"Sydney":    "AU",
+	"Tokyo":     "JP",
+	"London":    "GB",
+	"Paris":     "FR",
+	"Berlin":    "DE",
+	"New York":  "US",
+}
+
+// serveCannedUpstream routes a mock upstream request to the canned response of the requested API.
+func serveCannedUpstream(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/data/2.5/forecast":
+		serveCannedForecast(w, r)
+	default:
+		serveCannedWeather(w, r)
+	}
+}
+
+// serveCannedWeather mimics the OpenWeatherMap current weather endpoint for any city name.
+func serveCannedWeather(w http.ResponseWriter, r *http.Request) {
+	city := r.URL.Query().Get("q")
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if city == "" {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"cod":"404","message":"city not found"}`)
+		return
+	}
+
+	country, ok := cannedCountries[city]
+	if !ok {
+		country = "XX"
+	}
+
+	fmt.Fprintf(w, `{"name":%q,"sys":{"country":%q},"main":{"temp":%v,"temp_min":%v,"temp_max":%v,"feels_like":%v,"pressure":1012,"humidity":60},"weather":[{"id":800,"main":"Clear","description":"clear sky","icon":"01d"}],"dt":%d,"cod":200}`,
+		city, country, inRequestedUnits(r, 21.5), inRequestedUnits(r, 19), inRequestedUnits(r, 24), inRequestedUnits(r, 21), time.Now().Unix())
+}
+
+// inRequestedUnits converts a canned temperature in Kelvin to the units asked for in the upstream
+// request r, as the real API does.
+func inRequestedUnits(r *http.Request, kelvin float64) float64 {
+	switch r.URL.Query().Get("units") {
+	case unitsMetric:
+		return kelvinToCelsius(kelvin)
+	case unitsImperial:
+		return kelvinToFahrenheit(kelvin)
+	}
+	return kelvin
+}
+
+// startMockUpstream points the upstream API at a test server using handler for the duration of the test.
+// Responses are declared as JSON like the real API's, unless handler sets another Content-Type.
+func startMockUpstream(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		handler(w, r)
+	}))
+	previous := weatherApiHost
+	weatherApiHost = upstream.URL
+
+	t.Cleanup(func() {
+		weatherApiHost = previous
+		upstream.Close()
+	})
+
+	return upstream
+}
+
+// waitUntil polls condition until it holds or a second has passed.
+func waitUntil(t *testing.T, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met before deadline")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
 // TestGetWeatherLocalResponse tests the instrumentedGetWeatherLocal function to ensure it handles the request correctly.
 //
 // The function uses httptest.NewRecorder to create a response recorder for testing HTTP responses.
@@ -26,6 +155,7 @@ func TestGetWeatherLocalResponse(t *testing.T) {
 
 	w := httptest.NewRecorder()
 	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request, _ = http.NewRequest(http.MethodGet, "/weather", nil)
 	instrumentedGetWeatherLocal(ctx)
 
 	//assert.Equal(t, http.StatusOK, w.Code)
@@ -52,7 +182,7 @@ func TestGetWeatherLocalResponseLocation(t *testing.T) {
 
 	//assert.Equal(t, http.StatusOK, w.Code)
 
-	var data map[string]string
+	var data map[string]any
 	err := json.Unmarshal(w.Body.Bytes(), &data)
 	if err != nil {
 		t.Errorf("Error unmarshalling JSON response: %v", err)
@@ -193,6 +323,102 @@ func TestWeatherStressResponse3(t *testing.T) {
 
 }
 
+// TestStressHandlersDoNotLeakGoroutines runs each stress handler against a healthy and a failing mock
+// upstream and checks that none of the goroutines it starts outlives the handler.
+func TestStressHandlersDoNotLeakGoroutines(t *testing.T) {
+	handlers := map[string]gin.HandlerFunc{
+		"stress0": getWeatherStressTest0,
+		"stress1": getWeatherStressTest1,
+		"stress2": getWeatherStressTest2,
+		"stress3": getWeatherStressTest3,
+	}
+
+	upstreams := map[string]http.HandlerFunc{
+		"healthy": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"name": "Tokyo", "sys": {"country": "JP"}, "main": {"temp": 20}}`))
+		},
+		"failing": func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		},
+	}
+
+	for name, handler := range handlers {
+		for upstreamName, upstreamHandler := range upstreams {
+			t.Run(name+"/"+upstreamName, func(t *testing.T) {
+				// Goroutines of the canned upstream and of earlier tests are not the handler's
+				ignore := goleak.IgnoreCurrent()
+
+				upstream := httptest.NewServer(upstreamHandler)
+				previous := weatherApiHost
+				weatherApiHost = upstream.URL
+
+				w := httptest.NewRecorder()
+				ctx, _ := gin.CreateTestContext(w)
+				ctx.Request, _ = http.NewRequest(http.MethodGet, "/weather", nil)
+
+				handler(ctx)
+
+				weatherApiHost = previous
+				upstream.Close()
+				// Keep-alive connections to the mock are owned by the transport, not by the handler
+				upstreamTransport.CloseIdleConnections()
+
+				if w.Code != http.StatusOK {
+					t.Errorf("Expected status code 200, got %v", w.Code)
+				}
+
+				goleak.VerifyNone(t, ignore)
+			})
+		}
+	}
+}
+
+// TestStressHandlersStopWhenCancelled cancels the request context while every fetch is still waiting
+// on the upstream, and checks that each stress handler returns promptly without writing a response.
+func TestStressHandlersStopWhenCancelled(t *testing.T) {
+	handlers := map[string]gin.HandlerFunc{
+		"stress0": getWeatherStressTest0,
+		"stress1": getWeatherStressTest1,
+		"stress2": getWeatherStressTest2,
+		"stress3": getWeatherStressTest3,
+	}
+
+	// The upstream never answers on its own, the client timeout would end each fetch after 200ms
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+
+	for name, handler := range handlers {
+		t.Run(name, func(t *testing.T) {
+			requestCtx, cancel := context.WithCancel(context.Background())
+
+			w := httptest.NewRecorder()
+			ctx, _ := gin.CreateTestContext(w)
+			ctx.Request, _ = http.NewRequestWithContext(requestCtx, http.MethodGet, "/weather/"+name, nil)
+
+			done := make(chan struct{})
+			go func() {
+				handler(ctx)
+				close(done)
+			}()
+
+			time.Sleep(20 * time.Millisecond)
+			cancel()
+
+			select {
+			case <-done:
+			case <-time.After(150 * time.Millisecond):
+				t.Fatal("Expected the handler to return promptly once the request was cancelled")
+			}
+
+			if w.Body.Len() != 0 {
+				t.Errorf("Expected no response for a cancelled request, got %q", w.Body.String())
+			}
+		})
+	}
+}
+
 // TestGetHandleDefaultRouteResponse tests the HandleDefaultRoute function to ensure it handles the request correctly.
 func TestGetHandleDefaultRouteResponse(t *testing.T) {
 	gin.SetMode(gin.TestMode)
@@ -203,3 +429,913 @@ func TestGetHandleDefaultRouteResponse(t *testing.T) {
 
 	//assert.Equal(t, http.StatusOK, w.Code)
 }
+
+// TestWeatherInternationalIfModifiedSince checks that Last-Modified follows the observation time
+// and that a client revalidating with it gets 304 until a newer observation arrives.
+func TestWeatherInternationalIfModifiedSince(t *testing.T) {
+	observed := time.Date(2025, 4, 13, 15, 0, 0, 0, time.UTC)
+
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"name":"Tokyo","sys":{"country":"JP"},"main":{"temp":18},"dt":%d,"cod":200}`, observed.Unix())
+	})
+
+	tests := []struct {
+		name            string
+		ifModifiedSince string
+		expectedStatus  int
+	}{
+		{"no header", "", http.StatusOK},
+		{"same observation", observed.Format(http.TimeFormat), http.StatusNotModified},
+		{"later than observation", observed.Add(time.Hour).Format(http.TimeFormat), http.StatusNotModified},
+		{"older observation", observed.Add(-10 * time.Minute).Format(http.TimeFormat), http.StatusOK},
+		{"malformed header", "yesterday", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			ctx, _ := gin.CreateTestContext(w)
+			ctx.Params = []gin.Param{{Key: "location", Value: "Tokyo"}}
+			ctx.Request, _ = http.NewRequest(http.MethodGet, "/weather/Tokyo", nil)
+			if tt.ifModifiedSince != "" {
+				ctx.Request.Header.Set("If-Modified-Since", tt.ifModifiedSince)
+			}
+
+			instrumentedGetWeatherInternational(ctx)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if got := w.Header().Get("Last-Modified"); got != observed.Format(http.TimeFormat) {
+				t.Errorf("Expected Last-Modified %q, got %q", observed.Format(http.TimeFormat), got)
+			}
+			if tt.expectedStatus == http.StatusNotModified && w.Body.Len() != 0 {
+				t.Errorf("Expected an empty body for 304, got %q", w.Body.String())
+			}
+		})
+	}
+}
+
+// TestWeatherInternationalETag checks that the ETag only depends on the observation time, so two
+// upstream fetches of the same observation share it and a client revalidating with it gets 304.
+func TestWeatherInternationalETag(t *testing.T) {
+	withCache(t, 0, false)
+
+	observed := time.Date(2025, 4, 13, 15, 0, 0, 0, time.UTC)
+	temp := 18
+
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		temp++
+		fmt.Fprintf(w, `{"name":"Tokyo","sys":{"country":"JP"},"main":{"temp":%d},"dt":%d,"cod":200}`, temp, observed.Unix())
+	})
+
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		ctx, _ := gin.CreateTestContext(w)
+		ctx.Params = []gin.Param{{Key: "location", Value: "Tokyo"}}
+		ctx.Request, _ = http.NewRequest(http.MethodGet, "/weather/Tokyo", nil)
+		if ifNoneMatch != "" {
+			ctx.Request.Header.Set("If-None-Match", ifNoneMatch)
+		}
+
+		instrumentedGetWeatherInternational(ctx)
+
+		return w
+	}
+
+	first, second := get(""), get("")
+	etag := first.Header().Get("ETag")
+	if etag == "" || etag != second.Header().Get("ETag") {
+		t.Fatalf("Expected the same ETag for the same observation, got %q and %q", etag, second.Header().Get("ETag"))
+	}
+
+	tests := []struct {
+		ifNoneMatch    string
+		expectedStatus int
+	}{
+		{etag, http.StatusNotModified},
+		{`"other", ` + etag, http.StatusNotModified},
+		{"*", http.StatusNotModified},
+		{`W/"1"`, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		if w := get(tt.ifNoneMatch); w.Code != tt.expectedStatus {
+			t.Errorf("If-None-Match %s: expected status %d, got %d", tt.ifNoneMatch, tt.expectedStatus, w.Code)
+		}
+	}
+}
+
+func TestSendWeatherRequestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected []string
+	}{
+		{
+			name:     "type mismatch",
+			body:     `{"name":"Tokyo","main":{"temp":"warm"}}`,
+			expected: []string{`field "main.temp"`, "float64", "JSON string", "offset"},
+		},
+		{
+			name:     "syntax error",
+			body:     `{"name":"Tokyo",,"cod":200}`,
+			expected: []string{"syntax error at offset 17", `"Tokyo\",,\"cod\":200}"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, tt.body)
+			})
+
+			_, err := sendWeatherRequest("Tokyo", unitsStandard)
+			if err == nil {
+				t.Fatal("Expected a decode error")
+			}
+			if class := errorClass(err); class != "decode_error" {
+				t.Errorf("Expected the error to be classified as decode_error, got %s", class)
+			}
+
+			for _, expected := range tt.expected {
+				if !strings.Contains(err.Error(), expected) {
+					t.Errorf("Expected %q in error %q", expected, err)
+				}
+			}
+		})
+	}
+}
+
+func TestSendWeatherRequestHTMLResponse(t *testing.T) {
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, "<html><body><h1>502 Bad Gateway</h1></body></html>")
+	})
+
+	_, err := sendWeatherRequest("Tokyo", unitsStandard)
+	if err == nil {
+		t.Fatal("Expected an upstream error")
+	}
+
+	if class := errorClass(err); class != "upstream_error" {
+		t.Errorf("Expected the error to be classified as upstream_error, got %s", class)
+	}
+
+	for _, expected := range []string{`"text/html; charset=utf-8"`, "502 Bad Gateway"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected %q in error %q", expected, err)
+		}
+	}
+}
+
+func TestSendWeatherRequestOversizedResponse(t *testing.T) {
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"name": "Tokyo", "base": %q, "main": {"temp": 290}}`, strings.Repeat("x", maxUpstreamBodyBytes))
+	})
+
+	_, err := sendWeatherRequest("Tokyo", unitsStandard)
+	if !errors.Is(err, ErrUpstreamResponse) {
+		t.Fatalf("Expected ErrUpstreamResponse for a body above %d bytes, got %v", maxUpstreamBodyBytes, err)
+	}
+}
+
+func TestSendWeatherRequestEmptyResponse(t *testing.T) {
+	for _, body := range []string{"", "\n"} {
+		startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, body)
+		})
+
+		_, err := sendWeatherRequest("Tokyo", unitsStandard)
+		if !errors.Is(err, ErrEmptyResponse) {
+			t.Fatalf("Body %q: expected ErrEmptyResponse, got %v", body, err)
+		}
+
+		if class := errorClass(err); class != "empty_response" {
+			t.Errorf("Body %q: expected the error to be classified as empty_response, got %s", body, class)
+		}
+	}
+}
+
+// TestUpstreamErrorsCountedByType checks that weather_upstream_errors_total counts the empty
+// responses apart from the other upstream failures.
+func TestUpstreamErrorsCountedByType(t *testing.T) {
+	reader := withMetricReader(t)
+
+	var body atomic.Value
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, body.Load())
+	})
+
+	for _, response := range []string{"", "\n", `{"main": "not an object"}`} {
+		body.Store(response)
+		if _, err := instrumentedSendWeatherRequestContext(context.Background(), "Tokyo", unitsStandard); err == nil {
+			t.Fatalf("Body %q: expected the fetch to fail", response)
+		}
+	}
+
+	var collected metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &collected); err != nil {
+		t.Fatalf("Error collecting metrics: %v", err)
+	}
+
+	counted := map[string]float64{}
+	for _, scope := range collected.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			if data, ok := m.Data.(metricdata.Sum[float64]); ok && m.Name == "weather_upstream_errors_total" {
+				for _, point := range data.DataPoints {
+					class, _ := point.Attributes.Value("error_type")
+					counted[class.AsString()] += point.Value
+				}
+			}
+		}
+	}
+
+	if counted["empty_response"] != 2 || counted["decode_error"] != 1 {
+		t.Errorf("Expected 2 empty_response and 1 decode_error upstream errors, got %v", counted)
+	}
+}
+
+func TestSendWeatherRequestMissingMain(t *testing.T) {
+	for _, body := range []string{`{"name":"Tokyo","dt":1744556400}`, `{"name":"Tokyo","main":null}`} {
+		startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, body)
+		})
+
+		_, err := sendWeatherRequest("Tokyo", unitsStandard)
+		if !errors.Is(err, ErrUpstreamResponse) {
+			t.Errorf("Body %s: expected an error wrapping %v, got %v", body, ErrUpstreamResponse, err)
+		}
+	}
+
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"name":"Tokyo","main":{"temp":0}}`)
+	})
+
+	if _, err := sendWeatherRequest("Tokyo", unitsStandard); err != nil {
+		t.Errorf("Expected a genuine 0 Kelvin reading to be accepted, got %v", err)
+	}
+}
+
+func TestWeatherInternationalStaleObservation(t *testing.T) {
+	tests := []struct {
+		name  string
+		age   time.Duration
+		stale bool
+	}{
+		{"fresh", 10 * time.Minute, false},
+		{"stale", 3 * time.Hour, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, `{"name":"Tokyo","sys":{"country":"JP"},"main":{"temp":18},"dt":%d,"cod":200}`,
+					time.Now().Add(-tt.age).Unix())
+			})
+
+			w := httptest.NewRecorder()
+			ctx, _ := gin.CreateTestContext(w)
+			ctx.Params = []gin.Param{{Key: "location", Value: "Tokyo"}}
+			ctx.Request, _ = http.NewRequest(http.MethodGet, "/weather/Tokyo", nil)
+
+			instrumentedGetWeatherInternational(ctx)
+
+			var data map[string]interface{}
+			if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+				t.Fatalf("Error unmarshalling JSON response: %v", err)
+			}
+
+			if stale, _ := data["stale_observation"].(bool); stale != tt.stale {
+				t.Errorf("Expected stale_observation %v, got %v", tt.stale, data["stale_observation"])
+			}
+			if hasWarning := w.Header().Get("Warning") != ""; hasWarning != tt.stale {
+				t.Errorf("Expected a Warning header: %v, got %q", tt.stale, w.Header().Get("Warning"))
+			}
+		})
+	}
+}
+
+func TestIsStaleObservationDisabled(t *testing.T) {
+	previous := config
+	t.Cleanup(func() { config = previous })
+
+	config.StaleObservationAge = 0
+
+	old := WeatherData{Dt: int(time.Now().Add(-48 * time.Hour).Unix())}
+	if isStaleObservation(old, time.Now()) {
+		t.Error("Expected the staleness check to be disabled")
+	}
+}
+
+func TestObservationAge(t *testing.T) {
+	now := time.Date(2025, 4, 13, 15, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		observed time.Time
+		expected int64
+	}{
+		{"past", now.Add(-90 * time.Second), 90},
+		{"now", now, 0},
+		{"clock skew", now.Add(time.Minute), 0},
+	}
+
+	for _, tt := range tests {
+		data := WeatherData{Dt: int(tt.observed.Unix())}
+		if got := observationAge(data, now); got != tt.expected {
+			t.Errorf("%s: expected age %d, got %d", tt.name, tt.expected, got)
+		}
+	}
+}
+
+func TestWeatherResponseAge(t *testing.T) {
+	data := WeatherData{Dt: int(time.Now().Add(-time.Minute).Unix())}
+	if age, ok := weatherResponse(data, displayStandard)["age_seconds"].(int64); !ok || age < 60 || age > 61 {
+		t.Errorf("Expected an age of about 60 seconds, got %v", weatherResponse(data, displayStandard)["age_seconds"])
+	}
+
+	if _, ok := weatherResponse(WeatherData{}, displayStandard)["age_seconds"]; ok {
+		t.Error("Expected no age_seconds without an observation time")
+	}
+}
+
+func TestIsDaytime(t *testing.T) {
+	const sunrise, sunset = 1700000000, 1700040000
+
+	tests := []struct {
+		name     string
+		dt       int
+		sunrise  int
+		sunset   int
+		expected bool
+	}{
+		{"before sunrise", sunrise - 1, sunrise, sunset, false},
+		{"at sunrise", sunrise, sunrise, sunset, true},
+		{"between", sunrise + 3600, sunrise, sunset, true},
+		{"at sunset", sunset, sunrise, sunset, false},
+		{"after sunset", sunset + 1, sunrise, sunset, false},
+		{"missing sunrise", sunrise + 3600, 0, sunset, false},
+		{"missing sunset", sunrise + 3600, sunrise, 0, false},
+	}
+
+	for _, tt := range tests {
+		data := WeatherData{Dt: tt.dt, Sys: Sys{Sunrise: tt.sunrise, Sunset: tt.sunset}}
+		if got := data.IsDaytime(); got != tt.expected {
+			t.Errorf("%s: expected IsDaytime() %v, got %v", tt.name, tt.expected, got)
+		}
+	}
+}
+
+func TestWeatherResponseDayFlag(t *testing.T) {
+	data := sampleWeatherData("Sydney")
+	if response := weatherResponse(data, displayStandard); response["is_day"] != true {
+		t.Errorf("Expected is_day true, got %v", response["is_day"])
+	}
+
+	data.Sys.Sunrise, data.Sys.Sunset = 0, 0
+	if _, ok := weatherResponse(data, displayStandard)["is_day"]; ok {
+		t.Error("Expected no is_day flag without sunrise and sunset")
+	}
+}
+
+func TestConditionGroup(t *testing.T) {
+	tests := []struct {
+		id       int
+		expected string
+	}{
+		{199, ""},
+		{200, "thunderstorm"},
+		{299, "thunderstorm"},
+		{300, "drizzle"},
+		{399, "drizzle"},
+		{400, ""},
+		{499, ""},
+		{500, "rain"},
+		{599, "rain"},
+		{600, "snow"},
+		{699, "snow"},
+		{700, "atmosphere"},
+		{799, "atmosphere"},
+		{800, "clear"},
+		{801, "clouds"},
+		{804, "clouds"},
+		{900, ""},
+	}
+
+	for _, tt := range tests {
+		if got := (Weather{ID: tt.id}).ConditionGroup(); got != tt.expected {
+			t.Errorf("ConditionGroup() of %d = %q, want %q", tt.id, got, tt.expected)
+		}
+	}
+}
+
+func TestWeatherResponseCondition(t *testing.T) {
+	data := sampleWeatherData("Sydney")
+	if response := weatherResponse(data, displayStandard); response["condition"] != "clear" {
+		t.Errorf("Expected condition clear, got %v", response["condition"])
+	}
+
+	data.Weather = nil
+	if _, ok := weatherResponse(data, displayStandard)["condition"]; ok {
+		t.Error("Expected no condition without a weather entry")
+	}
+}
+
+func TestWeatherResponseDescription(t *testing.T) {
+	if response := weatherResponse(sampleWeatherData("Sydney"), displayStandard); response["description"] != "clear sky" {
+		t.Errorf("Expected description clear sky, got %v", response["description"])
+	}
+
+	if response := weatherResponse(WeatherData{}, displayStandard); response["description"] != "" {
+		t.Errorf("Expected an empty description without a weather entry, got %v", response["description"])
+	}
+}
+
+// TestStressHandlersWithoutWeatherEntry serves weather without any condition, as the upstream does
+// under load, and checks that the stress handlers answer with an empty description.
+func TestStressHandlersWithoutWeatherEntry(t *testing.T) {
+	handlers := map[string]gin.HandlerFunc{
+		"stress0": getWeatherStressTest0,
+		"stress1": getWeatherStressTest1,
+		"stress2": getWeatherStressTest2,
+		"stress3": getWeatherStressTest3,
+	}
+
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"name":%q,"main":{"temp":290},"weather":[],"sys":{"country":"XX"}}`, r.URL.Query().Get("q"))
+	})
+
+	for name, handler := range handlers {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			ctx, _ := gin.CreateTestContext(w)
+			ctx.Request, _ = http.NewRequest(http.MethodGet, "/weather/"+name, nil)
+
+			handler(ctx)
+
+			var data []map[string]any
+			if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+				t.Fatalf("Error unmarshalling JSON response: %v", err)
+			}
+			if len(data) == 0 {
+				t.Fatalf("Expected stress results, got %s", w.Body.String())
+			}
+			for _, entry := range data {
+				if description, ok := entry["description"]; !ok || description != "" {
+					t.Errorf("Expected an empty description, got %v", entry)
+				}
+			}
+		})
+	}
+}
+
+func TestStressHandlersForwardUnits(t *testing.T) {
+	handlers := map[string]gin.HandlerFunc{
+		"stress0": getWeatherStressTest0,
+		"stress1": getWeatherStressTest1,
+		"stress2": getWeatherStressTest2,
+		"stress3": getWeatherStressTest3,
+	}
+
+	var forwarded sync.Map
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		forwarded.Store(r.URL.Query().Get("units"), true)
+		serveCannedWeather(w, r)
+	})
+
+	for name, handler := range handlers {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			ctx, _ := gin.CreateTestContext(w)
+			ctx.Request, _ = http.NewRequest(http.MethodGet, "/weather/"+name+"?units=imperial", nil)
+
+			handler(ctx)
+
+			var data []map[string]any
+			if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+				t.Fatalf("Error unmarshalling JSON response: %v", err)
+			}
+			for _, entry := range data {
+				// The canned upstream reports 21.5 K, and answers 404 for the empty city of the stress lists
+				if entry["city"] != "" && entry["temperature"] != "-420.97" {
+					t.Errorf("Expected the temperature in Fahrenheit, got %v", entry)
+				}
+			}
+		})
+	}
+
+	forwarded.Range(func(units, _ any) bool {
+		if units != unitsImperial {
+			t.Errorf("Expected every upstream request in imperial units, got units=%q", units)
+		}
+		return true
+	})
+}
+
+func TestWeatherDataLogValue(t *testing.T) {
+	var buffer bytes.Buffer
+	slog.New(slog.NewJSONHandler(&buffer, nil)).Info("Result", "weather", sampleWeatherData("Sydney"))
+
+	var record struct {
+		Weather map[string]any `json:"weather"`
+	}
+	if err := json.Unmarshal(buffer.Bytes(), &record); err != nil {
+		t.Fatalf("Error unmarshalling log record: %v", err)
+	}
+
+	expected := map[string]any{"city": "Sydney", "country": "AU", "temperature": 21.5, "condition": "clear"}
+	if !maps.Equal(record.Weather, expected) {
+		t.Errorf("Expected the weather group %v, got %v", expected, record.Weather)
+	}
+}
+
+func TestWeatherResponsePrecipitation(t *testing.T) {
+	tests := []struct {
+		name     string
+		rain     Rain
+		snow     Snow
+		expected gin.H
+	}{
+		{"none", Rain{}, Snow{}, gin.H{}},
+		{"rain last hour", Rain{OneH: 0.4}, Snow{}, gin.H{"rain": gin.H{"1h": 0.4}}},
+		{"rain and snow", Rain{OneH: 0.4, ThreeH: 1.2}, Snow{ThreeH: 2}, gin.H{"rain": gin.H{"1h": 0.4, "3h": 1.2}, "snow": gin.H{"3h": 2.0}}},
+	}
+
+	for _, tt := range tests {
+		data := sampleWeatherData("Oslo")
+		data.Rain, data.Snow = tt.rain, tt.snow
+
+		response := weatherResponse(data, displayStandard)
+		for _, kind := range []string{"rain", "snow"} {
+			got, ok := response[kind]
+			want, expected := tt.expected[kind]
+			if ok != expected || fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("%s: expected %s %v, got %v", tt.name, kind, want, got)
+			}
+		}
+	}
+}
+
+func TestWeatherResponsePressure(t *testing.T) {
+	withCache(t, 0, false)
+	config.DedupWindow = 0
+
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		levels := ""
+		if r.URL.Query().Get("q") == "Denver" {
+			levels = `,"sea_level":1021,"grnd_level":835`
+		}
+		fmt.Fprintf(w, `{"name":%q,"sys":{"country":"US"},"main":{"temp":290,"pressure":1019%s,"humidity":40},"weather":[{"id":800,"main":"Clear","description":"clear sky","icon":"01d"}],"dt":%d,"cod":200}`,
+			r.URL.Query().Get("q"), levels, time.Now().Unix())
+	})
+
+	router := gin.New()
+	router.GET("/weather/:location", getWeatherInternational)
+
+	get := func(city string) map[string]any {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/weather/"+city, nil))
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected status code %d, got %d: %s", city, http.StatusOK, w.Code, w.Body.String())
+		}
+
+		var data map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+			t.Fatalf("%s: error unmarshalling JSON response: %v", city, err)
+		}
+		return data
+	}
+
+	denver := get("Denver")
+	if denver["pressure_sea_level"] != 1021.0 || denver["pressure_ground_level"] != 835.0 {
+		t.Errorf("Expected the sea and ground level pressures 1021 and 835, got %v and %v", denver["pressure_sea_level"], denver["pressure_ground_level"])
+	}
+
+	// Without the specific values, the general pressure is the sea level one
+	boston := get("Boston")
+	if boston["pressure_sea_level"] != 1019.0 {
+		t.Errorf("Expected the sea level pressure to fall back to 1019, got %v", boston["pressure_sea_level"])
+	}
+	if level, ok := boston["pressure_ground_level"]; ok {
+		t.Errorf("Expected no ground level pressure when the upstream reports none, got %v", level)
+	}
+}
+
+// TestListenerQueuesConnectionsBeyondLimit holds the only connection slot open with a keep-alive
+// connection and checks that a second client is queued until that connection closes.
+func TestListenerQueuesConnectionsBeyondLimit(t *testing.T) {
+	listener, err := newListener("127.0.0.1:0", 1)
+	if err != nil {
+		t.Fatalf("Error creating listener: %v", err)
+	}
+
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	})}
+	go srv.Serve(listener)
+	t.Cleanup(func() { srv.Close() })
+
+	request := "GET / HTTP/1.1\r\nHost: weather\r\n\r\n"
+
+	first, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Error dialing: %v", err)
+	}
+	fmt.Fprint(first, request)
+	if _, err := http.ReadResponse(bufio.NewReader(first), nil); err != nil {
+		t.Fatalf("Expected the first connection to be served: %v", err)
+	}
+
+	second, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Error dialing: %v", err)
+	}
+	defer second.Close()
+	fmt.Fprint(second, request)
+
+	reader := bufio.NewReader(second)
+	second.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, err := http.ReadResponse(reader, nil); err == nil {
+		t.Fatal("Expected the second connection to wait while the first one holds the slot")
+	}
+
+	first.Close()
+
+	second.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := http.ReadResponse(reader, nil); err != nil {
+		t.Errorf("Expected the second connection to be served once the slot frees up: %v", err)
+	}
+}
+
+func TestStressRoutesDisabled(t *testing.T) {
+	previous := config
+	t.Cleanup(func() { config = previous })
+
+	passThrough := func(c *gin.Context) { c.Next() }
+
+	for _, enabled := range []bool{true, false} {
+		config.StressEndpoints = enabled
+
+		router := gin.New()
+		registerStressRoutes(router, passThrough)
+
+		expected := 0
+		if enabled {
+			expected = 4
+		}
+		if registered := len(router.Routes()); registered != expected {
+			t.Errorf("StressEndpoints=%v: expected %d stress routes, got %d", enabled, expected, registered)
+		}
+
+		if enabled {
+			continue
+		}
+		for i := range 4 {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/weather/stress%d", i), nil))
+			if w.Code != http.StatusNotFound {
+				t.Errorf("Expected /weather/stress%d to be absent, got status %d", i, w.Code)
+			}
+		}
+	}
+}
+
+func TestTrailingSlashHandler(t *testing.T) {
+	newRouter := func() *gin.Engine {
+		router := gin.New()
+		router.GET("/weather", func(c *gin.Context) { c.String(http.StatusOK, "local") })
+		router.GET("/weather/:location", func(c *gin.Context) { c.String(http.StatusOK, c.Param("location")) })
+		router.POST("/weather/batch", func(c *gin.Context) { c.String(http.StatusOK, "batch") })
+		return router
+	}
+
+	tests := []struct {
+		behavior         string
+		method           string
+		path             string
+		expectedStatus   int
+		expectedLocation string
+		expectedBody     string
+	}{
+		{trailingSlashRedirect, http.MethodGet, "/weather", http.StatusOK, "", "local"},
+		{trailingSlashRedirect, http.MethodGet, "/weather/", http.StatusMovedPermanently, "/weather", ""},
+		{trailingSlashRedirect, http.MethodGet, "/weather/Tokyo/", http.StatusMovedPermanently, "/weather/Tokyo", ""},
+		{trailingSlashRedirect, http.MethodPost, "/weather/batch/", http.StatusTemporaryRedirect, "/weather/batch", ""},
+		{trailingSlashServe, http.MethodGet, "/weather", http.StatusOK, "", "local"},
+		{trailingSlashServe, http.MethodGet, "/weather/", http.StatusOK, "", "local"},
+		{trailingSlashServe, http.MethodGet, "/weather/Tokyo/", http.StatusOK, "", "Tokyo"},
+		{trailingSlashServe, http.MethodPost, "/weather/batch/", http.StatusOK, "", "batch"},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		trailingSlashHandler(newRouter(), tt.behavior).ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+
+		if w.Code != tt.expectedStatus || w.Header().Get("Location") != tt.expectedLocation {
+			t.Errorf("%s %s %s: expected status %d to %q, got %d to %q", tt.behavior, tt.method, tt.path,
+				tt.expectedStatus, tt.expectedLocation, w.Code, w.Header().Get("Location"))
+		}
+		if tt.expectedBody != "" && w.Body.String() != tt.expectedBody {
+			t.Errorf("%s %s %s: expected body %q, got %q", tt.behavior, tt.method, tt.path, tt.expectedBody, w.Body.String())
+		}
+	}
+}
+
+// withMetricReader records the instruments with a manual reader for the duration of the test.
+func withMetricReader(t *testing.T) *sdkmetric.ManualReader {
+	t.Helper()
+
+	reader := sdkmetric.NewManualReader()
+	initMetrics(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("weather"))
+	t.Cleanup(func() { initMetrics(noop.NewMeterProvider().Meter("weather")) })
+
+	return reader
+}
+
+// TestSendWeatherRequestMetrics checks that an upstream fetch counts a weather request and observes its duration.
+func TestSendWeatherRequestMetrics(t *testing.T) {
+	reader := withMetricReader(t)
+
+	startMockUpstream(t, serveCannedWeather)
+
+	for range 2 {
+		if _, err := instrumentedSendWeatherRequestContext(context.Background(), "Tokyo", unitsStandard); err != nil {
+			t.Fatalf("Error fetching weather: %v", err)
+		}
+	}
+
+	var collected metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &collected); err != nil {
+		t.Fatalf("Error collecting metrics: %v", err)
+	}
+
+	endpoint := attribute.Key("endpoint").String("sendWeatherRequest")
+	fromEndpoint := func(attributes attribute.Set) bool {
+		value, ok := attributes.Value(endpoint.Key)
+		return ok && value.AsString() == endpoint.Value.AsString()
+	}
+	var requests float64
+	var observations uint64
+
+	for _, scope := range collected.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			switch data := m.Data.(type) {
+			case metricdata.Sum[float64]:
+				for _, point := range data.DataPoints {
+					if m.Name == "weather_requests_total" && fromEndpoint(point.Attributes) {
+						requests += point.Value
+					}
+				}
+			case metricdata.Histogram[float64]:
+				for _, point := range data.DataPoints {
+					if m.Name == "weather_request_duration_seconds" && fromEndpoint(point.Attributes) {
+						observations += point.Count
+					}
+				}
+			}
+		}
+	}
+
+	if requests != 2 {
+		t.Errorf("Expected weather_requests_total to count 2 requests, got %v", requests)
+	}
+	if observations != 2 {
+		t.Errorf("Expected weather_request_duration_seconds to observe 2 durations, got %d", observations)
+	}
+}
+
+// TestRegisterMetricsOnCustomRegistry registers the metrics against two registries, as two embedded
+// servers would, and scrapes one of them in isolation.
+func TestRegisterMetricsOnCustomRegistry(t *testing.T) {
+	previous := config
+	t.Cleanup(func() { config = previous })
+	config.MetricsExemplars = true
+
+	registry := prometheus.NewRegistry()
+	histogram := registerMetrics(registry)
+	if histogram == nil {
+		t.Fatal("Expected the request duration histogram with exemplars enabled")
+	}
+	histogram.WithLabelValues(http.MethodGet, "/weather").Observe(0.1)
+
+	// Registering against the global registry twice would panic on the duplicate metrics
+	registerMetrics(prometheus.NewRegistry())
+
+	w := httptest.NewRecorder()
+	metricsHandler(registry, false).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	for _, expected := range []string{"weather_cities_served_total", "weather_http_request_duration_seconds"} {
+		if !strings.Contains(w.Body.String(), expected) {
+			t.Errorf("Expected %s in the scrape, got %s", expected, w.Body.String())
+		}
+	}
+	if strings.Contains(w.Body.String(), "go_goroutines") {
+		t.Error("Expected none of the default registry's collectors in the scrape")
+	}
+}
+
+func TestMetricsHandlerNegotiatesOpenMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "weather_test_total", Help: "Test counter."})
+	registry.MustRegister(counter)
+	counter.Inc()
+
+	tests := []struct {
+		openMetrics bool
+		expected    string
+	}{
+		{true, "application/openmetrics-text"},
+		{false, "text/plain"},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		request, _ := http.NewRequest(http.MethodGet, "/metrics", nil)
+		request.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+
+		metricsHandler(registry, tt.openMetrics).ServeHTTP(w, request)
+
+		if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, tt.expected) {
+			t.Errorf("openMetrics=%v: expected content type %s, got %s", tt.openMetrics, tt.expected, contentType)
+		}
+		if tt.openMetrics && !strings.HasSuffix(w.Body.String(), "# EOF\n") {
+			t.Errorf("Expected the OpenMetrics body to end with # EOF, got %q", w.Body.String())
+		}
+	}
+}
+
+// requestExemplars serves one request through exemplarMiddleware and returns the trace IDs
+// attached to the recorded duration.
+func requestExemplars(t *testing.T) []string {
+	t.Helper()
+
+	registry := prometheus.NewRegistry()
+	histogram := newRequestDurationHistogram()
+	registry.MustRegister(histogram)
+
+	router := gin.New()
+	router.Use(exemplarMiddleware(histogram))
+	router.GET("/", getHandleDefaultRoute)
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Error gathering metrics: %v", err)
+	}
+
+	var traceIDs []string
+	for _, bucket := range families[0].GetMetric()[0].GetHistogram().GetBucket() {
+		for _, label := range bucket.GetExemplar().GetLabel() {
+			if label.GetName() == "trace_id" {
+				traceIDs = append(traceIDs, label.GetValue())
+			}
+		}
+	}
+	return traceIDs
+}
+
+func TestExemplarMiddlewareRecordsTraceID(t *testing.T) {
+	previous := tracer
+	t.Cleanup(func() { tracer = previous })
+
+	provider := sdktrace.NewTracerProvider()
+	t.Cleanup(func() { provider.Shutdown(context.Background()) })
+	tracer = provider.Tracer("weather-test")
+
+	traceIDs := requestExemplars(t)
+	if len(traceIDs) != 1 || len(traceIDs[0]) != 32 {
+		t.Errorf("Expected a single trace ID exemplar, got %v", traceIDs)
+	}
+}
+
+func TestExemplarMiddlewareWithoutTracing(t *testing.T) {
+	if traceIDs := requestExemplars(t); len(traceIDs) != 0 {
+		t.Errorf("Expected no exemplar with tracing disabled, got %v", traceIDs)
+	}
+}
+
+func TestForceExitOnSecondSignal(t *testing.T) {
+	quit := make(chan os.Signal, 2)
+	exited := make(chan int, 1)
+
+	go forceExitOnSignal(quit, func(code int) { exited <- code })
+
+	select {
+	case <-exited:
+		t.Fatal("Expected no exit before a second signal")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	quit <- os.Interrupt
+
+	select {
+	case code := <-exited:
+		if code == 0 {
+			t.Errorf("Expected a nonzero exit code, got %d", code)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the second signal to force the exit")
+	}
+}
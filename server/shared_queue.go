@@ -1,27 +1,43 @@
 package weather
 
 import (
+	"context"
+	"slices"
 	"sync"
 	"time"
 )
 
-type SharedQueue struct {
+// SharedQueue is a FIFO of T shared between the producer and consumer goroutines of the stress handlers.
+type SharedQueue[T any] struct {
 	mutex sync.RWMutex
-	data  []WeatherData
+	data  []T
 
 	// Mutex to facilitate Check
 	NotifyMutex sync.RWMutex
 	notify      bool
+
+	// Signalled on every push, so WaitFor can sleep instead of spinning on the length
+	pushed   *sync.Cond
+	pushOnce sync.Once
+}
+
+// pushedCond returns the condition variable signalled on every push, created on first use
+// so the zero SharedQueue stays usable.
+func (q *SharedQueue[T]) pushedCond() *sync.Cond {
+	q.pushOnce.Do(func() {
+		q.pushed = sync.NewCond(&q.mutex)
+	})
+	return q.pushed
 }
 
-func (q *SharedQueue) GetLength() int {
+func (q *SharedQueue[T]) GetLength() int {
 	q.mutex.RLock()
 	tmp := len(q.data)
 	q.mutex.RUnlock()
 	return tmp
 }
 
-func (q *SharedQueue) TryPush(data WeatherData) bool {
+func (q *SharedQueue[T]) TryPush(data T) bool {
 
 	if q.GetLength() > 0 {
 		q.Notify()
@@ -31,13 +47,14 @@ func (q *SharedQueue) TryPush(data WeatherData) bool {
 	q.mutex.Lock()
 	q.data = append(q.data, data)
 	q.Notify()
+	q.pushedCond().Broadcast()
 	q.mutex.Unlock()
 
 	return true
 
 }
 
-func (q *SharedQueue) FastPush(data WeatherData) {
+func (q *SharedQueue[T]) FastPush(data T) {
 
 	// Ease the contention, don't push if the queue has data already
 	for !q.TryPush(data) {
@@ -46,33 +63,34 @@ func (q *SharedQueue) FastPush(data WeatherData) {
 
 }
 
-func (q *SharedQueue) Push(data WeatherData) {
+func (q *SharedQueue[T]) Push(data T) {
 	q.mutex.Lock()
 	q.data = append(q.data, data)
 	q.Notify()
+	q.pushedCond().Broadcast()
 	q.mutex.Unlock()
 }
 
-func (q *SharedQueue) Check() {
+func (q *SharedQueue[T]) Check() {
 	for q.GetLength() < 1 {
 		time.Sleep(1 * time.Microsecond)
 	}
 }
 
-func (q *SharedQueue) Notify() {
+func (q *SharedQueue[T]) Notify() {
 	q.NotifyMutex.Lock()
 	q.notify = !q.notify
 	q.NotifyMutex.Unlock()
 }
 
-func (q *SharedQueue) CheckNotify() bool {
+func (q *SharedQueue[T]) CheckNotify() bool {
 	q.NotifyMutex.RLock()
 	tmp := q.notify
 	q.NotifyMutex.RUnlock()
 	return !tmp
 }
 
-func (q *SharedQueue) Pop() WeatherData {
+func (q *SharedQueue[T]) Pop() T {
 	// SENSITIVE LOCKING: This read lock has to be done strictly BEFORE.
 	// Yield Barrier: Wait for at least one element to be present in the queue
 	q.Check()
@@ -99,12 +117,16 @@ func (q *SharedQueue) Pop() WeatherData {
 	// NOTE: HB_SENSITIVE happens before this line, other goros check the notify variable,
 	// and if it is true, then all the goros need to go back.
 
-	for q.CheckNotify() {
+	for len(q.data) == 0 {
 		q.mutex.Unlock()
 		q.Check()
 		q.mutex.Lock()
 	}
 
+	// LIVELOCK: Waiting on CheckNotify() spins forever once every producer has pushed, because nobody
+	// toggles notify anymore and the parity left behind is random.
+	// FIX: Re-check the length while holding the write lock, that is the condition that actually matters.
+
 	// OK NOW, THE PROBLEM IS THE THE FIRST GORO CANT PASS :0 :O
 
 	// AHA: Problem is, there is contention on mutex, and Push is not happening at all, before Pop.
@@ -138,20 +160,70 @@ func (q *SharedQueue) Pop() WeatherData {
 	return tmp
 }
 
-func (q *SharedQueue) GetAll() []WeatherData {
+// RemoveWhere drops every queued item matching pred in a single pass under the write lock,
+// keeping the order of the others, and returns the number of items removed.
+func (q *SharedQueue[T]) RemoveWhere(pred func(T) bool) int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	before := len(q.data)
+	q.data = slices.DeleteFunc(q.data, pred)
+
+	return before - len(q.data)
+}
+
+func (q *SharedQueue[T]) GetAll() []T {
 	q.mutex.RLock()
 	defer q.mutex.RUnlock()
 
-	results := make([]WeatherData, 0, len(q.data))
+	results := make([]T, 0, len(q.data))
 	results = append(results, q.data...)
 
 	return results
 }
 
+// WaitFor blocks until the queue holds at least count items, without retrieving them.
+// It sleeps on the push condition instead of spinning, pair it with GetAll to collect the items.
+func (q *SharedQueue[T]) WaitFor(count int) {
+	cond := q.pushedCond()
+
+	q.mutex.Lock()
+	for len(q.data) < count {
+		cond.Wait()
+	}
+	q.mutex.Unlock()
+}
+
+// WaitForContext is WaitFor giving up when ctx is done, in which case it returns the context error.
+func (q *SharedQueue[T]) WaitForContext(ctx context.Context, count int) error {
+	cond := q.pushedCond()
+
+	// Wake the waiter below when ctx is done, the lock makes sure it is either
+	// already waiting or has not checked ctx yet
+	stop := context.AfterFunc(ctx, func() {
+		q.mutex.Lock()
+		cond.Broadcast()
+		q.mutex.Unlock()
+	})
+	defer stop()
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for len(q.data) < count {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		cond.Wait()
+	}
+
+	return nil
+}
+
 // Excellent work, works at scale!
-func (q *SharedQueue) GetAllBlocking(count int) []WeatherData {
+func (q *SharedQueue[T]) GetAllBlocking(count int) []T {
 
-	results := make([]WeatherData, 0, count)
+	results := make([]T, 0, count)
 
 	// Barrier: Wait for queue to be populated
 	for q.GetLength() < count {
@@ -168,7 +240,7 @@ func (q *SharedQueue) GetAllBlocking(count int) []WeatherData {
 }
 
 // Excellent work, works at scale!
-func (q *SharedQueue) GetAllYielding(count int, ch chan WeatherData) {
+func (q *SharedQueue[T]) GetAllYielding(count int, ch chan T) {
 
 	// Yield Barrier: Wait for at least one element to be present in the queue
 	for count > 0 {
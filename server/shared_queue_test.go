@@ -0,0 +1,174 @@
+package weather
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"runtime"
+	"runtime/metrics"
+	"testing"
+	"time"
+)
+
+// TestSharedQueueWaitFor checks that WaitFor stays blocked below the threshold and wakes once it is reached.
+func TestSharedQueueWaitFor(t *testing.T) {
+	sq := &SharedQueue[WeatherData]{}
+
+	done := make(chan struct{})
+	go func() {
+		sq.WaitFor(3)
+		close(done)
+	}()
+
+	for i := range 2 {
+		sq.Push(sampleWeatherData(fmt.Sprint("city", i)))
+	}
+
+	select {
+	case <-done:
+		t.Fatalf("WaitFor returned with only %d items queued", sq.GetLength())
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	sq.Push(sampleWeatherData("city2"))
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("WaitFor did not return once the threshold was reached")
+	}
+
+	if got := len(sq.GetAll()); got != 3 {
+		t.Errorf("Expected WaitFor to leave the 3 items queued, got %d", got)
+	}
+}
+
+func TestSharedQueueWaitForContextCancelled(t *testing.T) {
+	sq := &SharedQueue[WeatherData]{}
+	sq.Push(sampleWeatherData("Sydney"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if err := sq.WaitForContext(ctx, 2); err != context.DeadlineExceeded {
+		t.Errorf("Expected %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	if err := sq.WaitForContext(context.Background(), 1); err != nil {
+		t.Errorf("Expected the threshold to be met already, got %v", err)
+	}
+}
+
+// queueDone is the location of the item pushed to stop the consumer of BenchmarkSharedQueuePush.
+const queueDone = "done"
+
+// cpuSeconds returns the CPU time spent by the process so far, user code and runtime alike.
+// The runtime only updates its CPU estimate when collecting, hence the forced GC.
+func cpuSeconds() float64 {
+	runtime.GC()
+
+	sample := []metrics.Sample{{Name: "/cpu/classes/total:cpu-seconds"}}
+	metrics.Read(sample)
+	return sample[0].Value.Float64()
+}
+
+/*
+BenchmarkSharedQueuePush compares the push variants of SharedQueue with many producers and the
+single Pop consumer of the stress handlers.
+
+Besides ns/op it reports cpu-ns/op, the CPU time burnt per push, which exposes the busy-waiting:
+FastPush spins until the consumer has drained the queue, so it burns CPU in every producer while
+Push only contends on the lock. TryPush is the single attempt FastPush spins on, it gives up
+rather than push onto a non-empty queue, so its items are dropped instead of waiting.
+
+Run it with go test -bench SharedQueuePush -cpu 1,4,16 to vary the producer concurrency.
+*/
+func BenchmarkSharedQueuePush(b *testing.B) {
+	variants := []struct {
+		name string
+		push func(sq *SharedQueue[WeatherData], data WeatherData)
+	}{
+		{"Push", (*SharedQueue[WeatherData]).Push},
+		{"FastPush", (*SharedQueue[WeatherData]).FastPush},
+		{"TryPush", func(sq *SharedQueue[WeatherData], data WeatherData) { sq.TryPush(data) }},
+	}
+
+	for _, variant := range variants {
+		b.Run(variant.name, func(b *testing.B) {
+			sq := &SharedQueue[WeatherData]{}
+			item := sampleWeatherData("Sydney")
+
+			consumed := make(chan struct{})
+			go func() {
+				for sq.Pop().Name != queueDone {
+				}
+				close(consumed)
+			}()
+
+			cpuBefore := cpuSeconds()
+			b.SetParallelism(4)
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					variant.push(sq, item)
+				}
+			})
+
+			sq.Push(sampleWeatherData(queueDone))
+			<-consumed
+
+			b.ReportMetric((cpuSeconds()-cpuBefore)*1e9/float64(b.N), "cpu-ns/op")
+		})
+	}
+}
+
+func TestSharedQueueRemoveWhere(t *testing.T) {
+	sq := &SharedQueue[WeatherData]{}
+	for _, city := range []string{"Tokyo", "", "London", "", ""} {
+		if city == "" {
+			sq.Push(WeatherData{})
+		} else {
+			sq.Push(sampleWeatherData(city))
+		}
+	}
+
+	removed := sq.RemoveWhere(func(data WeatherData) bool {
+		return reflect.ValueOf(data).IsZero()
+	})
+	if removed != 3 {
+		t.Errorf("Expected 3 zero value entries removed, got %d", removed)
+	}
+
+	remaining := sq.GetAll()
+	if len(remaining) != 2 || remaining[0].Name != "Tokyo" || remaining[1].Name != "London" {
+		t.Errorf("Expected Tokyo and London to remain in order, got %v", remaining)
+	}
+}
+
+// TestSharedQueueInts runs the queue machinery with a payload other than WeatherData.
+func TestSharedQueueInts(t *testing.T) {
+	sq := &SharedQueue[int]{}
+	for i := range 3 {
+		sq.Push(i)
+	}
+
+	if first := sq.Pop(); first != 0 {
+		t.Errorf("Expected the first pushed value, got %d", first)
+	}
+
+	if removed := sq.RemoveWhere(func(n int) bool { return n == 2 }); removed != 1 {
+		t.Errorf("Expected 1 value removed, got %d", removed)
+	}
+
+	if !sq.TryPush(3) {
+		sq.Push(3)
+	}
+	if got := sq.GetAllBlocking(2); fmt.Sprint(got) != "[1 3]" {
+		t.Errorf("Expected [1 3] queued, got %v", got)
+	}
+
+	ch := make(chan int, 2)
+	sq.GetAllYielding(2, ch)
+	if sum := <-ch + <-ch; sum != 4 {
+		t.Errorf("Expected to pop 1 and 3, got a sum of %d", sum)
+	}
+}
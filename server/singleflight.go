@@ -0,0 +1,282 @@
+package weather
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// ErrFlightPanicked is returned to the callers sharing a fetch that panicked.
+var ErrFlightPanicked = errors.New("shared fetch panicked")
+
+// flightCall is an in-flight or completed upstream fetch shared by every caller asking for the same key.
+type flightCall struct {
+	done     chan struct{}
+	data     WeatherData
+	err      error
+	panicked any
+
+	// Number of callers that joined this call instead of starting their own
+	dups int
+	// Number of callers still waiting for the result, the fetch is cancelled once they all gave up
+	waiting int
+	cancel  context.CancelFunc
+}
+
+// flightGroup collapses concurrent fetches for the same key into a single upstream call,
+// in the spirit of golang.org/x/sync/singleflight but typed for WeatherData.
+type flightGroup struct {
+	mutex sync.Mutex
+	calls map[string]*flightCall
+}
+
+// weatherFlights is shared by all handlers so that overlapping requests for a city share one fetch.
+var weatherFlights = &flightGroup{}
+
+// Do is DoContext for callers that never give up waiting, with a fetch that takes no context.
+func (g *flightGroup) Do(key string, fn func() (WeatherData, error)) (WeatherData, error, bool) {
+	return g.DoContext(context.Background(), key, func(context.Context) (WeatherData, error) {
+		return fn()
+	})
+}
+
+// DoContext executes fn for the given key, making sure only one execution is in flight at a time.
+// Callers that arrive while a fetch for the same key is running wait for it and receive its result.
+//
+// A successful result is also handed to the callers arriving within WeatherConfig.DedupWindow of
+// its completion, so a burst of near simultaneous requests still results in a single fetch.
+// Failures are never kept past the call, the next caller retries right away.
+//
+// fn runs detached from the caller that started it, on a context carrying the values of ctx but not
+// its cancellation. A caller whose ctx is done stops waiting and gets ctx.Err(), and once every
+// caller has given up, the context of fn is cancelled so the upstream request is abandoned too.
+//
+// Parameters:
+// ctx (context.Context): Bounds how long the caller waits for the result.
+// key (string): The deduplication key, usually a normalized location.
+// fn (func): The fetch to run when no call for the key is in flight.
+//
+// Return:
+// WeatherData: The result of the shared call.
+// error: The error of the shared call, or ctx.Err() if the caller gave up first.
+// bool: true if the result was shared with other callers.
+func (g *flightGroup) DoContext(ctx context.Context, key string, fn func(context.Context) (WeatherData, error)) (WeatherData, error, bool) {
+	g.mutex.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+
+	if c, ok := g.calls[key]; ok {
+		c.dups++
+		c.waiting++
+		g.mutex.Unlock()
+		data, err := g.wait(ctx, key, c)
+		return data, err, true
+	}
+
+	callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	c := &flightCall{done: make(chan struct{}), waiting: 1, cancel: cancel}
+	g.calls[key] = c
+	g.mutex.Unlock()
+
+	go g.run(callCtx, key, c, fn, config.DedupWindow)
+
+	data, err := g.wait(ctx, key, c)
+
+	// The waiters got the panic as an error, the caller that started fn gets the panic itself
+	select {
+	case <-c.done:
+		if c.panicked != nil {
+			panic(c.panicked)
+		}
+	default:
+	}
+
+	g.mutex.Lock()
+	shared := c.dups > 0
+	g.mutex.Unlock()
+
+	return data, err, shared
+}
+
+// wait blocks until the call c for key completes or ctx is done. The last caller to give up on
+// a call still in flight cancels it, and forgets it so the next caller starts a fresh fetch.
+func (g *flightGroup) wait(ctx context.Context, key string, c *flightCall) (WeatherData, error) {
+	select {
+	case <-c.done:
+		return c.data, c.err
+	case <-ctx.Done():
+	}
+
+	g.mutex.Lock()
+	c.waiting--
+	abandoned := c.waiting == 0
+	if abandoned && g.calls[key] == c {
+		delete(g.calls, key)
+	}
+	g.mutex.Unlock()
+
+	if abandoned {
+		c.cancel()
+	}
+	return WeatherData{}, ctx.Err()
+}
+
+// run calls fn, releases the callers waiting on c and forgets c, right away or after window.
+func (g *flightGroup) run(ctx context.Context, key string, c *flightCall, fn func(context.Context) (WeatherData, error), window time.Duration) {
+	defer c.cancel()
+
+	c.panicked = c.call(ctx, fn)
+	close(c.done)
+
+	if window <= 0 || c.err != nil {
+		g.forget(key, c)
+		return
+	}
+	time.AfterFunc(window, func() { g.forget(key, c) })
+}
+
+// call calls fn and stores its result in c. A panic in fn is recovered so the waiters are always
+// released, stored as an error wrapping ErrFlightPanicked and returned for the caller to re-raise.
+func (c *flightCall) call(ctx context.Context, fn func(context.Context) (WeatherData, error)) (panicked any) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.data, c.err = WeatherData{}, fmt.Errorf("%w: %v", ErrFlightPanicked, r)
+			panicked = r
+		}
+	}()
+
+	c.data, c.err = fn(ctx)
+	return nil
+}
+
+// forget removes the completed call c for key, unless a newer call has replaced it since.
+func (g *flightGroup) forget(key string, c *flightCall) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.calls[key] == c {
+		delete(g.calls, key)
+	}
+}
+
+// callers returns the number of callers currently waiting on the in-flight call for key, or 0 if there is none.
+func (g *flightGroup) callers(key string) int {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if c, ok := g.calls[key]; ok {
+		return c.dups + 1
+	}
+	return 0
+}
+
+// normalizeLocation folds a location into the key used to share work between requests for the same city.
+func normalizeLocation(location string) string {
+	return strings.ToLower(strings.TrimSpace(location))
+}
+
+// fetchWeatherShared fetches the weather for a location from the cache, or else through the shared
+// flight group, so concurrent requests for the same city result in a single upstream call.
+func fetchWeatherShared(location string) (WeatherData, error) {
+	return fetchWeatherSharedWithin(location, defaultUnits, 0)
+}
+
+// fetchWeatherSharedWithin is fetchWeatherSharedContext for a caller that waits until the fetch completes.
+func fetchWeatherSharedWithin(location string, units string, timeout time.Duration) (WeatherData, error) {
+	return fetchWeatherSharedContext(context.Background(), location, units, timeout)
+}
+
+// fetchWeatherSharedContext is fetchWeatherShared with the upstream queried in the given units, and
+// the upstream call bounded by timeout instead of the default client timeout, when timeout is positive.
+// The data is shared whatever the units asked for, it is always brought back to the standard units.
+//
+// The caller stops waiting once ctx is done, and the upstream call is cancelled when no other caller
+// is waiting for it, see flightGroup.DoContext. A cancelled fetch is neither served stale nor counted
+// as a failure of the location.
+//
+// Calls with a timeout override are only shared with calls using the same override, so a caller
+// never inherits a shorter deadline than the one it asked for.
+//
+// A location whose circuit is open fails right away with ErrCircuitOpen, see circuitBreakers.
+// When the fetch fails, the last cached weather is served instead if it is recent enough, see serveStale.
+//
+// Every successful lookup counts as a city served, whether it came from the cache, its own
+// upstream call or one shared with other callers.
+func fetchWeatherSharedContext(ctx context.Context, location string, units string, timeout time.Duration) (WeatherData, error) {
+	key := normalizeLocation(location)
+
+	if config.CacheTTL > 0 {
+		if data, ok := currentWeatherCache.Get(key); ok {
+			stats.cacheHits.Add(1)
+			stats.citiesServed.Add(1)
+			return data, nil
+		}
+		stats.cacheMisses.Add(1)
+	}
+
+	if !locationBreakers.Allow(key) {
+		return serveStale(key, fmt.Errorf("%w %q", ErrCircuitOpen, location))
+	}
+
+	flightKey := key
+	if timeout > 0 {
+		flightKey = fmt.Sprintf("%s|%s", key, timeout)
+	}
+
+	data, err, _ := weatherFlights.DoContext(ctx, flightKey, func(ctx context.Context) (WeatherData, error) {
+		ctx = withUnits(ctx, units)
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		data, err := currentWeatherWithFallback(ctx, weatherProviders, location)
+		if errors.Is(err, context.Canceled) {
+			return data, err
+		}
+		locationBreakers.Record(key, err)
+		if err == nil {
+			currentWeatherCache.Set(key, data)
+			lastSeen.Record(key)
+		}
+		return data, err
+	})
+	if err != nil && ctx.Err() != nil {
+		return WeatherData{}, err
+	}
+	if err != nil {
+		return serveStale(key, err)
+	}
+	stats.citiesServed.Add(1)
+	return data, nil
+}
+
+// serveStale falls back to the cached weather for key, expired or not, when fetching it failed
+// with err. Only entries stored at most WeatherConfig.MaxStaleAge ago are served, past that err is
+// returned rather than weather too old to be trusted.
+//
+// Each entry served counts in weather_stale_served_total, labelled with its city and country
+// through cityLabels and countryLabels, so a sustained upstream outage shows on the metrics even
+// while the clients still get answers.
+func serveStale(key string, err error) (WeatherData, error) {
+	data, ok := currentWeatherCache.GetStale(key, config.MaxStaleAge)
+	if !ok {
+		return WeatherData{}, err
+	}
+
+	logger.Warn("Serving stale weather after a failed fetch", "location", key, "error", err)
+	staleServedCounter.Add(context.Background(), 1, metric.WithAttributes(
+		attribute.String("city", cityLabels.Label(key)),
+		attribute.String("country", countryLabels.Label(data.Sys.Country)),
+	))
+	stats.citiesServed.Add(1)
+	return data, nil
+}
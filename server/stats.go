@@ -0,0 +1,93 @@
+package weather
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// serviceStats are cumulative counters served on /stats for a quick look without Prometheus.
+// They are updated from the concurrent handlers, hence atomics rather than a mutex.
+type serviceStats struct {
+	requests      atomic.Int64
+	errors        atomic.Int64
+	upstreamCalls atomic.Int64
+	cacheHits     atomic.Int64
+	cacheMisses   atomic.Int64
+
+	// City weather lookups served successfully, a batch request counts once per city
+	citiesServed atomic.Int64
+
+	started time.Time
+}
+
+var stats = &serviceStats{started: time.Now()}
+
+// snapshot returns the current value of every counter along with the uptime.
+func (s *serviceStats) snapshot() gin.H {
+	return gin.H{
+		"requests":       s.requests.Load(),
+		"errors":         s.errors.Load(),
+		"upstream_calls": s.upstreamCalls.Load(),
+		"cache_hits":     s.cacheHits.Load(),
+		"cache_misses":   s.cacheMisses.Load(),
+		"cities_served":  s.citiesServed.Load(),
+		"uptime_seconds": int64(time.Since(s.started).Seconds()),
+	}
+}
+
+// statsMiddleware counts every request, and every request answered with a server error.
+func statsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		stats.requests.Add(1)
+		if c.Writer.Status() >= http.StatusInternalServerError {
+			stats.errors.Add(1)
+		}
+	}
+}
+
+// citiesServedCounter exposes the cities served counter to Prometheus, reading it at scrape time.
+func citiesServedCounter() prometheus.CounterFunc {
+	return prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name: "weather_cities_served_total",
+		Help: "Total number of city weather lookups served successfully.",
+	}, func() float64 {
+		return float64(stats.citiesServed.Load())
+	})
+}
+
+// cacheCounters expose the cache hit and miss counters to Prometheus, reading them at scrape time.
+// An expired entry counts as a miss.
+func cacheCounters() []prometheus.Collector {
+	return []prometheus.Collector{
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "weather_cache_hits_total",
+			Help: "Total number of current weather lookups served from the cache.",
+		}, func() float64 {
+			return float64(stats.cacheHits.Load())
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "weather_cache_misses_total",
+			Help: "Total number of current weather lookups not found in the cache, or found expired.",
+		}, func() float64 {
+			return float64(stats.cacheMisses.Load())
+		}),
+	}
+}
+
+// getStats handles the /stats and /admin/stats routes.
+// It responds with the cumulative request, error, upstream, cache and cities served counters and the uptime of the server.
+//
+// Parameters:
+// ctx (gin.Context): The Gin context containing request and response objects.
+//
+// Return:
+// None
+func getStats(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, stats.snapshot())
+}
@@ -0,0 +1,142 @@
+package weather
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TestStatsConcurrentIncrements hammers the counters from many goroutines, run it with -race.
+func TestStatsConcurrentIncrements(t *testing.T) {
+	s := &serviceStats{}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				s.requests.Add(1)
+				s.errors.Add(1)
+				s.upstreamCalls.Add(1)
+				s.cacheHits.Add(1)
+				s.cacheMisses.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	snapshot := s.snapshot()
+	for _, key := range []string{"requests", "errors", "upstream_calls", "cache_hits", "cache_misses"} {
+		if snapshot[key] != int64(10000) {
+			t.Errorf("Expected %s to be 10000, got %v", key, snapshot[key])
+		}
+	}
+}
+
+func TestStatsMiddlewareCountsRequests(t *testing.T) {
+	previous := stats
+	stats = &serviceStats{}
+	t.Cleanup(func() { stats = previous })
+
+	router := gin.New()
+	router.Use(statsMiddleware())
+	router.GET("/", getHandleDefaultRoute)
+	router.GET("/fail", func(ctx *gin.Context) { ctx.Status(http.StatusBadGateway) })
+	router.GET("/stats", getStats)
+
+	for _, path := range []string{"/", "/", "/fail"} {
+		req, _ := http.NewRequest(http.MethodGet, path, nil)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/stats", nil)
+	router.ServeHTTP(w, req)
+
+	var data map[string]int64
+	if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+		t.Fatalf("Error unmarshalling JSON response: %v", err)
+	}
+
+	if data["requests"] != 3 || data["errors"] != 1 {
+		t.Errorf("Expected 3 requests and 1 error, got %v", data)
+	}
+	if _, ok := data["uptime_seconds"]; !ok {
+		t.Errorf("Expected uptime_seconds in %v", data)
+	}
+}
+
+func TestCitiesServedCountsSuccessfulLookups(t *testing.T) {
+	previous := stats
+	stats = &serviceStats{}
+	t.Cleanup(func() { stats = previous })
+
+	withCache(t, time.Minute, false)
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("q") == "Atlantis" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, `{"name":%q,"main":{"temp":290}}`, r.URL.Query().Get("q"))
+	})
+
+	// Tokyo is served twice, once from the upstream and once from the cache
+	for _, city := range []string{"Tokyo", "London", "Atlantis", "Tokyo"} {
+		fetchWeatherShared(city)
+	}
+
+	if served := stats.citiesServed.Load(); served != 3 {
+		t.Errorf("Expected 3 cities served, got %d", served)
+	}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(citiesServedCounter())
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Error gathering metrics: %v", err)
+	}
+	if served := families[0].GetMetric()[0].GetCounter().GetValue(); served != 3 {
+		t.Errorf("Expected the Prometheus counter to report 3 cities served, got %v", served)
+	}
+}
+
+func TestCacheCountersHitMissExpiry(t *testing.T) {
+	previous := stats
+	stats = &serviceStats{}
+	t.Cleanup(func() { stats = previous })
+
+	withCache(t, time.Minute, false)
+	now := time.Now()
+	currentWeatherCache.now = func() time.Time { return now }
+
+	startMockUpstream(t, serveCannedWeather)
+
+	// A miss, then a hit, then a miss once the entry expired
+	fetchWeatherShared("Tokyo")
+	fetchWeatherShared("Tokyo")
+	now = now.Add(2 * time.Minute)
+	fetchWeatherShared("Tokyo")
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(cacheCounters()...)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Error gathering metrics: %v", err)
+	}
+
+	counts := make(map[string]float64)
+	for _, family := range families {
+		counts[family.GetName()] = family.GetMetric()[0].GetCounter().GetValue()
+	}
+
+	if counts["weather_cache_hits_total"] != 1 || counts["weather_cache_misses_total"] != 2 {
+		t.Errorf("Expected 1 hit and 2 misses, got %v", counts)
+	}
+}
@@ -0,0 +1,168 @@
+package weather
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// summaryLanguage holds the sentences of a language accepted in the lang query parameter of /summary.
+type summaryLanguage struct {
+	// Sentence with the temperature, the condition and the city
+	withCondition string
+	// Sentence with the temperature and the city, when the upstream gave no condition
+	withoutCondition string
+	// Names of the condition groups, see Weather.ConditionGroup. Nil keeps the upstream description,
+	// which is in English
+	conditions map[string]string
+}
+
+// summaryLanguages are the languages of /summary, keyed by their ISO 639-1 code.
+var summaryLanguages = map[string]summaryLanguage{
+	"en": {
+		withCondition:    "It's %s and %s in %s.",
+		withoutCondition: "It's %s in %s.",
+	},
+	"fr": {
+		withCondition:    "Il fait %s, temps %s à %s.",
+		withoutCondition: "Il fait %s à %s.",
+		conditions: map[string]string{
+			"thunderstorm": "orageux", "drizzle": "bruineux", "rain": "pluvieux", "snow": "neigeux",
+			"atmosphere": "brumeux", "clear": "dégagé", "clouds": "nuageux",
+		},
+	},
+	"de": {
+		withCondition:    "Es sind %s und %s in %s.",
+		withoutCondition: "Es sind %s in %s.",
+		conditions: map[string]string{
+			"thunderstorm": "gewittrig", "drizzle": "nieselig", "rain": "regnerisch", "snow": "verschneit",
+			"atmosphere": "dunstig", "clear": "klar", "clouds": "bewölkt",
+		},
+	},
+	"es": {
+		withCondition:    "Hace %s y está %s en %s.",
+		withoutCondition: "Hace %s en %s.",
+		conditions: map[string]string{
+			"thunderstorm": "tormentoso", "drizzle": "lloviznando", "rain": "lluvioso", "snow": "nevado",
+			"atmosphere": "brumoso", "clear": "despejado", "clouds": "nublado",
+		},
+	},
+}
+
+// summaryTemperature renders kelvin rounded to the degree in the given display, both scales for the both display.
+func summaryTemperature(kelvin float64, display string) string {
+	switch display {
+	case displayCelsius:
+		return fmt.Sprintf("%.0f°C", math.Round(kelvinToCelsius(kelvin)))
+	case displayFahrenheit:
+		return fmt.Sprintf("%.0f°F", math.Round(kelvinToFahrenheit(kelvin)))
+	case displayBoth:
+		return fmt.Sprintf("%.0f°C (%.0f°F)", math.Round(kelvinToCelsius(kelvin)), math.Round(kelvinToFahrenheit(kelvin)))
+	default:
+		return fmt.Sprintf("%.0f K", math.Round(kelvin))
+	}
+}
+
+// Summary describes the weather in a single sentence of the given language, such as
+// "It's 22°C and broken clouds in Tokyo." for English in the celsius display.
+//
+// English uses the upstream description of the condition, the other languages the name of its
+// group. The condition is left out when the upstream gave none.
+//
+// Parameters:
+// display (string): The display mode of the temperature.
+// lang (string): One of the keys of summaryLanguages, English for any other.
+func (w WeatherData) Summary(display string, lang string) string {
+	language, ok := summaryLanguages[lang]
+	if !ok {
+		language = summaryLanguages["en"]
+	}
+
+	temperature := summaryTemperature(w.Main.Temp, display)
+
+	condition := ""
+	if len(w.Weather) > 0 {
+		condition = w.Weather[0].Description
+		if language.conditions != nil {
+			condition = language.conditions[w.Weather[0].ConditionGroup()]
+		}
+	}
+
+	if condition == "" {
+		return fmt.Sprintf(language.withoutCondition, temperature, w.Name)
+	}
+	return fmt.Sprintf(language.withCondition, temperature, condition, w.Name)
+}
+
+// getWeatherSummary responds with the current weather of the location given in the path as a single
+// plain text sentence, for chat bots and voice assistants. See WeatherData.Summary.
+//
+// The temperature is in Celsius unless the display or units query parameter asks otherwise, and
+// the lang query parameter selects the language of the sentence, English by default. The units
+// the upstream was queried in are echoed in the X-Units header.
+//
+// Parameters:
+// ctx (gin.Context): The Gin context containing request and response objects. The location is extracted from the "location" parameter.
+//
+// Return:
+// None. The function responds with a text/plain sentence, an HTTP 400 status code for an invalid
+// parameter or an HTTP 500 status code if the fetch fails.
+func getWeatherSummary(ctx *gin.Context) {
+
+	city := ctx.Param("location")
+	units := parseUnits(ctx)
+
+	display, err := parseTemperatureDisplay(ctx)
+	if err != nil {
+		ctx.String(http.StatusBadRequest, "%s\n", err)
+		return
+	}
+
+	lang := ctx.DefaultQuery("lang", "en")
+	if _, ok := summaryLanguages[lang]; !ok {
+		ctx.String(http.StatusBadRequest, "lang must be one of en, fr, de or es\n")
+		return
+	}
+
+	weatherData, err := fetchWeatherSharedContext(ctx.Request.Context(), city, units, 0)
+	if errors.Is(err, ErrCityNotFound) {
+		ctx.String(http.StatusNotFound, "%s\n", cityNotFoundResponse(city)["error"])
+		return
+	}
+	if err != nil {
+		logger.Error("Error fetching weather data", "city", city, "error", err)
+		ctx.String(http.StatusInternalServerError, "Failed to fetch weather data\n")
+		return
+	}
+
+	ctx.Header("X-Units", units)
+	ctx.String(http.StatusOK, "%s\n", weatherData.Summary(display, lang))
+
+}
+
+func instrumentedGetWeatherSummary(ctx *gin.Context) {
+	traceCtx, span := tracer.Start(ctx.Request.Context(), "getWeatherSummary")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("location", ctx.Param("location")),
+		attribute.String("method", ctx.Request.Method),
+		attribute.String("path", ctx.Request.URL.Path),
+	)
+
+	start := time.Now()
+	weatherRequestCounter.Add(traceCtx, 1,
+		metric.WithAttributes(attribute.Key("endpoint").String("getWeatherSummary")))
+	getWeatherSummary(ctx)
+	duration := time.Since(start).Seconds()
+	weatherRequestDuration.Record(traceCtx, duration,
+		metric.WithAttributes(attribute.Key("endpoint").String("getWeatherSummary")))
+
+	span.SetAttributes(attribute.Int("http.status_code", ctx.Writer.Status()))
+}
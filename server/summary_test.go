@@ -0,0 +1,92 @@
+package weather
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestWeatherDataSummary(t *testing.T) {
+	// 22°C, 71.6°F, with broken clouds
+	data := WeatherData{
+		Name:    "Tokyo",
+		Main:    Main{Temp: 295.15},
+		Weather: []Weather{{ID: 803, Description: "broken clouds"}},
+	}
+
+	tests := []struct {
+		display  string
+		lang     string
+		expected string
+	}{
+		{displayCelsius, "en", "It's 22°C and broken clouds in Tokyo."},
+		{displayFahrenheit, "en", "It's 72°F and broken clouds in Tokyo."},
+		{displayBoth, "en", "It's 22°C (72°F) and broken clouds in Tokyo."},
+		{displayStandard, "en", "It's 295 K and broken clouds in Tokyo."},
+		{displayCelsius, "fr", "Il fait 22°C, temps nuageux à Tokyo."},
+		{displayCelsius, "de", "Es sind 22°C und bewölkt in Tokyo."},
+		{displayCelsius, "es", "Hace 22°C y está nublado en Tokyo."},
+		{displayCelsius, "xx", "It's 22°C and broken clouds in Tokyo."},
+	}
+
+	for _, tt := range tests {
+		if got := data.Summary(tt.display, tt.lang); got != tt.expected {
+			t.Errorf("Summary(%s, %s) = %q, want %q", tt.display, tt.lang, got, tt.expected)
+		}
+	}
+
+	data.Weather = nil
+	if got, want := data.Summary(displayCelsius, "en"), "It's 22°C in Tokyo."; got != want {
+		t.Errorf("Summary without a condition = %q, want %q", got, want)
+	}
+}
+
+func TestGetWeatherSummary(t *testing.T) {
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"name": "Tokyo", "main": {"temp": %v}, "weather": [{"id": 800, "description": "clear sky"}]}`, inRequestedUnits(r, 295.15))
+	})
+
+	router := gin.New()
+	router.GET("/weather/:location/summary", getWeatherSummary)
+
+	get := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		return w
+	}
+
+	w := get("/weather/Tokyo/summary")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain") {
+		t.Errorf("Expected a text/plain response, got %s", contentType)
+	}
+	if body := w.Body.String(); body != "It's 22°C and clear sky in Tokyo.\n" {
+		t.Errorf("Expected the summary in Celsius by default, got %q", body)
+	}
+
+	if w.Header().Get("X-Units") != unitsMetric {
+		t.Errorf("Expected the X-Units header to report metric units, got %q", w.Header().Get("X-Units"))
+	}
+
+	w = get("/weather/Tokyo/summary?units=imperial&lang=fr")
+	if body := w.Body.String(); body != "Il fait 72°F, temps dégagé à Tokyo.\n" {
+		t.Errorf("Expected the French summary in Fahrenheit, got %q", body)
+	}
+	if w.Header().Get("X-Units") != unitsImperial {
+		t.Errorf("Expected the X-Units header to report imperial units, got %q", w.Header().Get("X-Units"))
+	}
+
+	if w := get("/weather/Tokyo/summary?lang=tlh"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an unknown language, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	if body := get("/weather/Tokyo/summary?units=kelvin").Body.String(); body != "It's 22°C and clear sky in Tokyo.\n" {
+		t.Errorf("Expected unsupported units to fall back to Celsius, got %q", body)
+	}
+}
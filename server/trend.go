@@ -0,0 +1,95 @@
+package weather
+
+import (
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Temperature trends reported by /trend, and the change in degrees below which the temperature is steady.
+const (
+	trendRising  = "rising"
+	trendFalling = "falling"
+	trendSteady  = "steady"
+
+	trendSteadyDelta = 1.0
+)
+
+// temperatureTrend compares the first and the last temperature of entries. It returns the trend and
+// the change in degrees, which is the same in Kelvin and Celsius. It is false with fewer than two entries.
+func temperatureTrend(entries []ForecastEntry) (string, float64, bool) {
+	if len(entries) < 2 {
+		return "", 0, false
+	}
+
+	delta := math.Round((entries[len(entries)-1].Main.Temp-entries[0].Main.Temp)*100) / 100
+
+	switch {
+	case delta >= trendSteadyDelta:
+		return trendRising, delta, true
+	case delta <= -trendSteadyDelta:
+		return trendFalling, delta, true
+	default:
+		return trendSteady, delta, true
+	}
+}
+
+// getTrend tells whether the temperature at the location given in the path is rising, falling or
+// steady over the next 24 hours, from the forecast slots covering them.
+//
+// Parameters:
+// ctx (gin.Context): The Gin context containing request and response objects. The location is extracted from the "location" parameter.
+//
+// Return:
+// None. The function responds with the trend and the change in degrees as JSON, or an HTTP 500 status code if the fetch fails.
+func getTrend(ctx *gin.Context) {
+
+	city := ctx.Param("location")
+
+	forecastData, err := instrumentedSendForecastRequest(ctx.Request.Context(), city, 1)
+	if err != nil {
+		logger.Error("Error fetching forecast data", "city", city, "error", err)
+		ctx.JSON(http.StatusInternalServerError, failedFetchResponse("Failed to fetch forecast data", err))
+		return
+	}
+
+	trend, delta, ok := temperatureTrend(forecastData.List)
+	if !ok {
+		logger.Error("Forecast too short for a trend", "city", city, "slots", len(forecastData.List))
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Not enough forecast data for a trend"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"city":    forecastData.City.Name,
+		"country": forecastData.City.Country,
+		"trend":   trend,
+		"delta":   delta,
+	})
+
+}
+
+func instrumentedGetTrend(ctx *gin.Context) {
+	traceCtx, span := tracer.Start(ctx.Request.Context(), "getTrend")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("location", ctx.Param("location")),
+		attribute.String("method", ctx.Request.Method),
+		attribute.String("path", ctx.Request.URL.Path),
+	)
+
+	start := time.Now()
+	weatherRequestCounter.Add(traceCtx, 1,
+		metric.WithAttributes(attribute.Key("endpoint").String("getTrend")))
+	getTrend(ctx)
+	duration := time.Since(start).Seconds()
+	weatherRequestDuration.Record(traceCtx, duration,
+		metric.WithAttributes(attribute.Key("endpoint").String("getTrend")))
+
+	span.SetAttributes(attribute.Int("http.status_code", ctx.Writer.Status()))
+}
@@ -0,0 +1,71 @@
+package weather
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestTemperatureTrend(t *testing.T) {
+	slots := func(temperatures ...float64) []ForecastEntry {
+		entries := make([]ForecastEntry, 0, len(temperatures))
+		for _, temperature := range temperatures {
+			entries = append(entries, ForecastEntry{Main: Main{Temp: temperature}})
+		}
+		return entries
+	}
+
+	tests := []struct {
+		name          string
+		entries       []ForecastEntry
+		expectedTrend string
+		expectedDelta float64
+	}{
+		{"rising", slots(280, 283, 285.5), trendRising, 5.5},
+		{"falling", slots(290, 288, 286.8), trendFalling, -3.2},
+		{"steady", slots(290, 295, 290.5), trendSteady, 0.5},
+	}
+
+	for _, tt := range tests {
+		trend, delta, ok := temperatureTrend(tt.entries)
+		if !ok || trend != tt.expectedTrend || delta != tt.expectedDelta {
+			t.Errorf("%s: expected %s by %v, got %s by %v (ok %v)", tt.name, tt.expectedTrend, tt.expectedDelta, trend, delta, ok)
+		}
+	}
+
+	if _, _, ok := temperatureTrend(slots(290)); ok {
+		t.Error("Expected no trend from a single slot")
+	}
+}
+
+func TestGetTrendRising(t *testing.T) {
+	startMockUpstream(t, serveCannedForecast)
+
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request, _ = http.NewRequest(http.MethodGet, "/trend/Lisbon", nil)
+	ctx.Params = gin.Params{{Key: "location", Value: "Lisbon"}}
+
+	getTrend(ctx)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var response struct {
+		City  string  `json:"city"`
+		Trend string  `json:"trend"`
+		Delta float64 `json:"delta"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Error unmarshalling response: %v", err)
+	}
+
+	// The canned forecast warms by a degree every slot, over the 8 slots of the next 24 hours
+	if response.City != "Lisbon" || response.Trend != trendRising || response.Delta != 7 {
+		t.Errorf("Expected Lisbon rising by 7, got %+v", response)
+	}
+}
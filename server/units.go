@@ -0,0 +1,285 @@
+package weather
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Temperature display modes accepted by the display query parameter.
+// The upstream API reports temperatures in Kelvin, which is the standard display.
+const (
+	displayStandard   = "standard"
+	displayCelsius    = "celsius"
+	displayFahrenheit = "fahrenheit"
+	displayBoth       = "both"
+)
+
+// Unit systems of the OpenWeatherMap API, accepted in the units query parameter and forwarded upstream.
+const (
+	unitsStandard = "standard"
+	unitsMetric   = "metric"
+	unitsImperial = "imperial"
+)
+
+// defaultUnits are the units the upstream is queried in when the client asks for none.
+const defaultUnits = unitsMetric
+
+func kelvinToCelsius(kelvin float64) float64 {
+	return kelvin - 273.15
+}
+
+func kelvinToFahrenheit(kelvin float64) float64 {
+	return kelvinToCelsius(kelvin)*9/5 + 32
+}
+
+func celsiusToKelvin(celsius float64) float64 {
+	return celsius + 273.15
+}
+
+func fahrenheitToKelvin(fahrenheit float64) float64 {
+	return celsiusToKelvin((fahrenheit - 32) * 5 / 9)
+}
+
+// msToMph converts a wind speed from m/s, the unit of the upstream API, to mph.
+func msToMph(speed float64) float64 {
+	return speed * 3600 / 1609.344
+}
+
+// mphToMs converts a wind speed from mph, the unit of the upstream API in imperial units, to m/s.
+func mphToMs(speed float64) float64 {
+	return speed * 1609.344 / 3600
+}
+
+// formatTemperature renders a temperature rounded to two decimals, the way it is returned to clients.
+func formatTemperature(temperature float64) string {
+	return fmt.Sprint(math.Round(temperature*100) / 100)
+}
+
+// formatSpeed renders a converted wind speed rounded to two decimals.
+func formatSpeed(speed float64) string {
+	return fmt.Sprint(math.Round(speed*100) / 100)
+}
+
+// unitsDisplays maps the OpenWeatherMap units names, accepted in the units query parameter, to a display mode.
+var unitsDisplays = map[string]string{
+	unitsStandard: displayStandard,
+	unitsMetric:   displayCelsius,
+	unitsImperial: displayFahrenheit,
+}
+
+// parseUnits reads the units query parameter, falling back to defaultUnits when it is missing or
+// holds an unsupported unit system, so a typo still gets an answer, in metric units.
+//
+// Parameters:
+// ctx (gin.Context): The Gin context containing request and response objects.
+//
+// Return:
+// string: One of the OpenWeatherMap unit systems.
+func parseUnits(ctx *gin.Context) string {
+	units := ctx.Query("units")
+	if _, ok := unitsDisplays[units]; !ok {
+		return defaultUnits
+	}
+	return units
+}
+
+// parseTemperatureDisplay reads the display query parameter, defaulting to the display of the
+// units query parameter, itself defaulting to metric, see parseUnits.
+//
+// The units are forwarded upstream, while display only changes how the response is rendered: the
+// upstream data is brought back to the standard units on arrival, see toStandardUnits.
+//
+// Parameters:
+// ctx (gin.Context): The Gin context containing request and response objects.
+//
+// Return:
+// string: One of the display modes.
+// error: An error if the parameter holds an unknown mode.
+func parseTemperatureDisplay(ctx *gin.Context) (string, error) {
+	display := ctx.Query("display")
+
+	if display == "" {
+		return unitsDisplays[parseUnits(ctx)], nil
+	}
+
+	switch display {
+	case displayStandard, displayCelsius, displayFahrenheit, displayBoth:
+		return display, nil
+	}
+
+	return "", fmt.Errorf("display must be one of standard, celsius, fahrenheit or both")
+}
+
+// setTemperature adds the temperature fields for the given display to response.
+//
+// The single scale displays fill the field itself, while the both display fills field_c and
+// field_f, such as temperature_c and temperature_f, so dual-display clients need a single call.
+//
+// Parameters:
+// response (gin.H): The response body to complete.
+// field (string): The name of the field, such as temperature or feels_like.
+// kelvin (float64): The temperature as reported by the upstream API.
+// display (string): The display mode.
+func setTemperature(response gin.H, field string, kelvin float64, display string) {
+	if display == displayBoth {
+		response[field+"_c"] = displayTemperature(kelvin, displayCelsius)
+		response[field+"_f"] = displayTemperature(kelvin, displayFahrenheit)
+		return
+	}
+	response[field] = displayTemperature(kelvin, display)
+}
+
+// displayTemperature renders a temperature in Kelvin in a single scale display, unconverted for
+// the standard display.
+func displayTemperature(kelvin float64, display string) string {
+	switch display {
+	case displayCelsius:
+		return formatTemperature(kelvinToCelsius(kelvin))
+	case displayFahrenheit:
+		return formatTemperature(kelvinToFahrenheit(kelvin))
+	default:
+		return fmt.Sprint(kelvin)
+	}
+}
+
+// setTemperatures adds every temperature of main to response in the given display, see setTemperature.
+// The minimum, maximum and feels like temperatures are left out when the upstream did not report them.
+func setTemperatures(response gin.H, main Main, display string) {
+	setTemperature(response, "temperature", main.Temp, display)
+
+	optional := []struct {
+		field  string
+		kelvin *float64
+	}{
+		{"temperature_min", main.TempMin},
+		{"temperature_max", main.TempMax},
+		{"feels_like", main.FeelsLike},
+	}
+	for _, temperature := range optional {
+		if temperature.kelvin != nil {
+			setTemperature(response, temperature.field, *temperature.kelvin, display)
+		}
+	}
+}
+
+// optionalTemperature returns a reported optional temperature of Main.
+func optionalTemperature(value float64) *float64 {
+	return &value
+}
+
+// setWind adds the wind speed and, when it applies, the wind chill to response in the given display.
+//
+// The speed follows the unit system of the display: mph with fahrenheit, m/s otherwise, and both as
+// wind_speed_ms and wind_speed_mph with the both display. The wind chill is a temperature, added
+// like the others by setTemperature. Nothing is added when the upstream reported no wind.
+func setWind(response gin.H, weatherData WeatherData, display string) {
+	speed := weatherData.Wind.Speed
+	if speed == 0 {
+		return
+	}
+
+	switch display {
+	case displayFahrenheit:
+		response["wind_speed"] = formatSpeed(msToMph(speed))
+	case displayBoth:
+		response["wind_speed_ms"] = fmt.Sprint(speed)
+		response["wind_speed_mph"] = formatSpeed(msToMph(speed))
+	default:
+		response["wind_speed"] = fmt.Sprint(speed)
+	}
+
+	if weatherData.Main.Temp == 0 {
+		return
+	}
+	if chill, ok := windChill(kelvinToCelsius(weatherData.Main.Temp), speed); ok {
+		setTemperature(response, "wind_chill", chill+273.15, display)
+	}
+}
+
+// windChill returns the temperature felt in celsius once cooled by a wind blowing at speed m/s,
+// with the North American wind chill index. It is only defined at or below 10°C and for winds
+// above 4.8 km/h, false otherwise.
+//
+// The index is computed in km/h and Celsius. It matches the imperial formula in mph and
+// Fahrenheit once converted, so every display shows the same wind chill.
+func windChill(celsius float64, speed float64) (float64, bool) {
+	kmh := speed * 3.6
+	if celsius > 10 || kmh <= 4.8 {
+		return 0, false
+	}
+
+	factor := math.Pow(kmh, 0.16)
+	return 13.12 + 0.6215*celsius - 11.37*factor + 0.3965*celsius*factor, true
+}
+
+// comfortLevels describe how a temperature feels, from coldest to hottest. Each level but the first
+// starts at the matching entry of WeatherConfig.ComfortThresholds.
+var comfortLevels = []string{"freezing", "cold", "mild", "warm", "hot"}
+
+// comfortLevel describes how celsius feels, given the ascending Celsius thresholds at which each
+// level after "freezing" starts.
+func comfortLevel(celsius float64, thresholds []float64) string {
+	level := 0
+	for level < len(thresholds) && celsius >= thresholds[level] {
+		level++
+	}
+	return comfortLevels[level]
+}
+
+// comfort describes how the weather feels, from the upstream feels like temperature, which accounts
+// for wind and humidity, or from the temperature when the feels like one is missing.
+func comfort(weatherData WeatherData) string {
+	kelvin := weatherData.Main.Temp
+	if weatherData.Main.FeelsLike != nil {
+		kelvin = *weatherData.Main.FeelsLike
+	}
+	return comfortLevel(kelvinToCelsius(kelvin), config.ComfortThresholds)
+}
+
+// toStandardUnits brings weather data fetched in the given units back to the standard units, Kelvin
+// and m/s, in which it is cached and from which every display is converted. The converted values
+// are rounded to two decimals, the precision of the upstream.
+// Optional temperatures the upstream did not report stay nil, see setTemperatures.
+func toStandardUnits(data WeatherData, units string) WeatherData {
+	var toKelvin func(float64) float64
+	switch units {
+	case unitsMetric:
+		toKelvin = celsiusToKelvin
+	case unitsImperial:
+		toKelvin = fahrenheitToKelvin
+		data.Wind.Speed = roundHundredths(mphToMs(data.Wind.Speed))
+	default:
+		return data
+	}
+
+	data.Main.Temp = roundHundredths(toKelvin(data.Main.Temp))
+	for _, temperature := range []**float64{&data.Main.TempMin, &data.Main.TempMax, &data.Main.FeelsLike} {
+		if *temperature != nil {
+			*temperature = optionalTemperature(roundHundredths(toKelvin(**temperature)))
+		}
+	}
+	return data
+}
+
+func roundHundredths(value float64) float64 {
+	return math.Round(value*100) / 100
+}
+
+type unitsKey struct{}
+
+// withUnits returns a copy of ctx asking the upstream fetches made with it for the given units,
+// so they reach the provider through the shared fetch, see unitsFrom.
+func withUnits(ctx context.Context, units string) context.Context {
+	return context.WithValue(ctx, unitsKey{}, units)
+}
+
+// unitsFrom returns the units set on ctx by withUnits, defaultUnits when there are none.
+func unitsFrom(ctx context.Context) string {
+	if units, ok := ctx.Value(unitsKey{}).(string); ok {
+		return units
+	}
+	return defaultUnits
+}
@@ -0,0 +1,391 @@
+package weather
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestKelvinConversions(t *testing.T) {
+	tests := []struct {
+		kelvin     float64
+		celsius    float64
+		fahrenheit float64
+	}{
+		{273.15, 0, 32},
+		{373.15, 100, 212},
+		{233.15, -40, -40},
+	}
+
+	for _, test := range tests {
+		if got := kelvinToCelsius(test.kelvin); formatTemperature(got) != formatTemperature(test.celsius) {
+			t.Errorf("kelvinToCelsius(%v) = %v, expected %v", test.kelvin, got, test.celsius)
+		}
+		if got := kelvinToFahrenheit(test.kelvin); formatTemperature(got) != formatTemperature(test.fahrenheit) {
+			t.Errorf("kelvinToFahrenheit(%v) = %v, expected %v", test.kelvin, got, test.fahrenheit)
+		}
+	}
+}
+
+func TestComfortLevel(t *testing.T) {
+	thresholds := []float64{0, 10, 20, 28}
+
+	tests := []struct {
+		celsius float64
+		want    string
+	}{
+		{-12, "freezing"},
+		{0, "cold"},
+		{9.9, "cold"},
+		{15, "mild"},
+		{20, "warm"},
+		{35, "hot"},
+	}
+
+	for _, test := range tests {
+		if got := comfortLevel(test.celsius, thresholds); got != test.want {
+			t.Errorf("comfortLevel(%v) = %q, expected %q", test.celsius, got, test.want)
+		}
+	}
+}
+
+func TestComfortPrefersFeelsLike(t *testing.T) {
+	// 22°C in the shade, but a cold wind makes it feel like 8°C
+	windy := WeatherData{Main: Main{Temp: 295.15, FeelsLike: optionalTemperature(281.15)}}
+	if got := comfort(windy); got != "cold" {
+		t.Errorf("Expected the feels like temperature to make it cold, got %q", got)
+	}
+
+	// Without a feels like temperature, the temperature decides
+	if got := comfort(WeatherData{Main: Main{Temp: 295.15}}); got != "warm" {
+		t.Errorf("Expected 22°C to be warm, got %q", got)
+	}
+}
+
+func TestLoadConfigComfortThresholds(t *testing.T) {
+	t.Setenv("WEATHER_COMFORT_THRESHOLDS", "-5, 5, 15, 25")
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := comfortLevel(-2, cfg.ComfortThresholds); got != "cold" {
+		t.Errorf("Expected -2°C to be cold with the configured thresholds, got %q", got)
+	}
+
+	for _, value := range []string{"0,10,20", "0,20,10,28", "0,ten,20,28"} {
+		t.Setenv("WEATHER_COMFORT_THRESHOLDS", value)
+		if _, err := loadConfig(); err == nil {
+			t.Errorf("Expected %q to be rejected", value)
+		}
+	}
+}
+
+func TestGetWeatherDisplayModes(t *testing.T) {
+	router := gin.New()
+	router.GET("/weather/:location", getWeatherInternational)
+
+	// The canned upstream reports 21.5 K, and temperatures default to the metric units
+	tests := []struct {
+		display string
+		want    map[string]string
+	}{
+		{"", map[string]string{"temperature": "-251.65"}},
+		{"standard", map[string]string{"temperature": "21.5"}},
+		{"celsius", map[string]string{"temperature": "-251.65"}},
+		{"fahrenheit", map[string]string{"temperature": "-420.97"}},
+		{"both", map[string]string{"temperature_c": "-251.65", "temperature_f": "-420.97"}},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/weather/Tokyo?display="+test.display, nil))
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("display=%q: expected status code %d, got %d", test.display, http.StatusOK, w.Code)
+		}
+
+		var response map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("display=%q: error unmarshalling response: %v", test.display, err)
+		}
+
+		for field, want := range test.want {
+			if response[field] != want {
+				t.Errorf("display=%q: expected %s %q, got %v", test.display, field, want, response[field])
+			}
+		}
+
+		if _, ok := response["temperature"]; test.display == displayBoth && ok {
+			t.Errorf("display=both: expected no single scale temperature field, got %v", response["temperature"])
+		}
+	}
+}
+
+// TestGetWeatherConvertsEveryTemperature checks that every temperature field is converted to the
+// requested units, so no field is left in Kelvin while the others are converted.
+func TestGetWeatherConvertsEveryTemperature(t *testing.T) {
+	// 20°C, 15°C, 25°C and 18°C
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"name": "Tokyo", "main": {"temp": %v, "temp_min": %v, "temp_max": %v, "feels_like": %v}}`,
+			inRequestedUnits(r, 293.15), inRequestedUnits(r, 288.15), inRequestedUnits(r, 298.15), inRequestedUnits(r, 291.15))
+	})
+
+	router := gin.New()
+	router.GET("/weather/:location", getWeatherInternational)
+
+	tests := []struct {
+		query string
+		want  map[string]string
+	}{
+		{"units=standard", map[string]string{
+			"temperature": "293.15", "temperature_min": "288.15", "temperature_max": "298.15", "feels_like": "291.15",
+		}},
+		{"units=metric", map[string]string{
+			"temperature": "20", "temperature_min": "15", "temperature_max": "25", "feels_like": "18",
+		}},
+		{"units=imperial", map[string]string{
+			"temperature": "68", "temperature_min": "59", "temperature_max": "77", "feels_like": "64.4",
+		}},
+		{"display=both", map[string]string{
+			"temperature_c": "20", "temperature_min_c": "15", "temperature_max_c": "25", "feels_like_c": "18",
+			"temperature_f": "68", "temperature_min_f": "59", "temperature_max_f": "77", "feels_like_f": "64.4",
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/weather/Tokyo?"+test.query, nil))
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+			}
+
+			var response map[string]any
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Error unmarshalling response: %v", err)
+			}
+
+			for field, want := range test.want {
+				if response[field] != want {
+					t.Errorf("Expected %s %q, got %v", field, want, response[field])
+				}
+			}
+		})
+	}
+}
+
+func TestGetWeatherInvalidDisplay(t *testing.T) {
+	router := gin.New()
+	router.GET("/weather/:location", getWeatherInternational)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/weather/Tokyo?display=rankine", nil))
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestGetWeatherMalformedUnitsFallBackToMetric(t *testing.T) {
+	var upstreamQueries []string
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		upstreamQueries = append(upstreamQueries, r.URL.RawQuery)
+		serveCannedWeather(w, r)
+	})
+
+	router := gin.New()
+	router.GET("/weather/:location", getWeatherInternational)
+
+	for _, query := range []string{"", "?units=bogus"} {
+		upstreamQueries = nil
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/weather/Tokyo"+query, nil))
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("%q: expected status code %d, got %d", query, http.StatusOK, w.Code)
+		}
+
+		var response map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("%q: error unmarshalling response: %v", query, err)
+		}
+		if response["units"] != unitsMetric || response["temperature"] != "-251.65" {
+			t.Errorf("%q: expected the metric units and a Celsius temperature, got %v", query, response)
+		}
+
+		if len(upstreamQueries) != 1 || !strings.Contains(upstreamQueries[0], "units=metric") {
+			t.Errorf("%q: expected units=metric to be forwarded upstream, got %v", query, upstreamQueries)
+		}
+	}
+}
+
+func TestWeatherForwardsUnits(t *testing.T) {
+	var upstreamQuery string
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		upstreamQuery = r.URL.RawQuery
+		serveCannedWeather(w, r)
+	})
+
+	router := gin.New()
+	router.GET("/weather", getWeatherLocal)
+	router.GET("/weather/:location", getWeatherInternational)
+
+	for _, target := range []string{"/weather?units=imperial", "/weather/Tokyo?units=imperial"} {
+		upstreamQuery = ""
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected status code %d, got %d", target, http.StatusOK, w.Code)
+		}
+		if !strings.Contains(upstreamQuery, "units=imperial") {
+			t.Errorf("%s: expected units=imperial in the upstream URL, got %q", target, upstreamQuery)
+		}
+
+		var response map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("%s: error unmarshalling response: %v", target, err)
+		}
+		// The canned upstream reports 21.5 K
+		if response["units"] != unitsImperial || response["temperature"] != "-420.97" {
+			t.Errorf("%s: expected the imperial units and a Fahrenheit temperature, got %v", target, response)
+		}
+	}
+}
+
+func TestToStandardUnits(t *testing.T) {
+	tests := []struct {
+		units string
+		main  Main
+		speed float64
+	}{
+		{unitsStandard, Main{Temp: 293.15, TempMin: optionalTemperature(288.15), FeelsLike: optionalTemperature(291.15)}, 4.47},
+		{unitsMetric, Main{Temp: 20, TempMin: optionalTemperature(15), FeelsLike: optionalTemperature(18)}, 4.47},
+		{unitsImperial, Main{Temp: 68, TempMin: optionalTemperature(59), FeelsLike: optionalTemperature(64.4)}, 10},
+	}
+
+	for _, test := range tests {
+		data := toStandardUnits(WeatherData{Main: test.main, Wind: Wind{Speed: test.speed}}, test.units)
+
+		if data.Main.Temp != 293.15 || *data.Main.TempMin != 288.15 || *data.Main.FeelsLike != 291.15 {
+			t.Errorf("%s: expected 293.15, 288.15 and 291.15 K, got %v, %v and %v", test.units, data.Main.Temp, *data.Main.TempMin, *data.Main.FeelsLike)
+		}
+		if data.Main.TempMax != nil {
+			t.Errorf("%s: expected the unreported maximum to stay nil, got %v", test.units, *data.Main.TempMax)
+		}
+		if data.Wind.Speed != 4.47 {
+			t.Errorf("%s: expected a wind speed of 4.47 m/s, got %v", test.units, data.Wind.Speed)
+		}
+	}
+}
+
+func TestWeatherZeroTemperaturesInMetric(t *testing.T) {
+	withCache(t, 0, false)
+	config.DedupWindow = 0
+
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"name":"Oslo","sys":{"country":"NO"},"main":{"temp":1.5,"temp_min":0,"temp_max":3,"feels_like":0,"pressure":1012,"humidity":80},"weather":[{"id":600,"main":"Snow","description":"light snow","icon":"13d"}],"dt":%d,"cod":200}`,
+			time.Now().Unix())
+	})
+
+	router := gin.New()
+	router.GET("/weather/:location", getWeatherInternational)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/weather/Oslo?units=metric", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var response map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Error unmarshalling response: %v", err)
+	}
+	// 0 °C is a reading, not a missing value
+	if response["temperature_min"] != "0" || response["feels_like"] != "0" {
+		t.Errorf("Expected a minimum and feels like temperature of 0 °C, got %v and %v", response["temperature_min"], response["feels_like"])
+	}
+}
+
+func TestWindChill(t *testing.T) {
+	// The published index tables, in metric and in imperial units, the imperial one rounded to the degree
+	tests := []struct {
+		name     string
+		celsius  float64
+		speed    float64
+		expected float64
+		unit     func(float64) float64
+	}{
+		{"-20°C at 30 km/h", -20, 30 / 3.6, -32.6, func(c float64) float64 { return c }},
+		{"0°F at 15 mph", (0 - 32) * 5.0 / 9, 15 * 1609.344 / 3600, -19, func(c float64) float64 { return c*9/5 + 32 }},
+		{"-10°F at 30 mph", (-10 - 32) * 5.0 / 9, 30 * 1609.344 / 3600, -39, func(c float64) float64 { return c*9/5 + 32 }},
+	}
+
+	for _, tt := range tests {
+		chill, ok := windChill(tt.celsius, tt.speed)
+		if !ok {
+			t.Errorf("%s: expected a wind chill", tt.name)
+			continue
+		}
+		if got := tt.unit(chill); math.Abs(got-tt.expected) > 1 {
+			t.Errorf("%s: expected a wind chill of %v, got %v", tt.name, tt.expected, got)
+		}
+	}
+
+	if _, ok := windChill(15, 10); ok {
+		t.Error("Expected no wind chill above 10°C")
+	}
+	if _, ok := windChill(-5, 1); ok {
+		t.Error("Expected no wind chill in a light breeze")
+	}
+}
+
+func TestSetWindFollowsDisplay(t *testing.T) {
+	// -10°C with a 10 m/s wind, 22.37 mph
+	data := WeatherData{Main: Main{Temp: 263.15}, Wind: Wind{Speed: 10}}
+	chill, _ := windChill(-10, 10)
+
+	tests := []struct {
+		display string
+		want    map[string]string
+	}{
+		{displayStandard, map[string]string{"wind_speed": "10", "wind_chill": fmt.Sprint(chill + 273.15)}},
+		{displayCelsius, map[string]string{"wind_speed": "10", "wind_chill": formatTemperature(chill)}},
+		{displayFahrenheit, map[string]string{"wind_speed": "22.37", "wind_chill": formatTemperature(chill*9/5 + 32)}},
+		{displayBoth, map[string]string{
+			"wind_speed_ms": "10", "wind_speed_mph": "22.37",
+			"wind_chill_c": formatTemperature(chill), "wind_chill_f": formatTemperature(chill*9/5 + 32),
+		}},
+	}
+
+	for _, tt := range tests {
+		response := gin.H{}
+		setWind(response, data, tt.display)
+
+		if len(response) != len(tt.want) {
+			t.Errorf("display=%s: expected fields %v, got %v", tt.display, tt.want, response)
+		}
+		for field, want := range tt.want {
+			if response[field] != want {
+				t.Errorf("display=%s: expected %s %s, got %v", tt.display, field, want, response[field])
+			}
+		}
+	}
+
+	response := gin.H{}
+	setWind(response, WeatherData{Main: Main{Temp: 263.15}}, displayCelsius)
+	if len(response) != 0 {
+		t.Errorf("Expected no wind fields without wind, got %v", response)
+	}
+}
@@ -0,0 +1,367 @@
+package weather
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Errors wrapped by the upstream requests, so a failure can be classified without parsing its message.
+var (
+	// The upstream answered with something other than JSON, typically an HTML error page during an outage
+	ErrUpstreamResponse = errors.New("unexpected upstream response")
+	// The upstream answered with JSON that does not match the expected schema
+	ErrDecode = errors.New("error unmarshalling JSON response")
+	// The upstream answered 200 OK without a body, as seen during partial outages
+	ErrEmptyResponse = errors.New("upstream returned empty response")
+	// The upstream does not know the location asked for, typically a typo in the city name
+	ErrCityNotFound = errors.New("city not found")
+)
+
+// upstreamStatusError is the status code of an upstream response other than 200 OK.
+type upstreamStatusError int
+
+func (e upstreamStatusError) Error() string {
+	return fmt.Sprintf("status %d", int(e))
+}
+
+// upstreamStatus returns the status code the upstream answered a failed request with, if it answered at all.
+func upstreamStatus(err error) (int, bool) {
+	var status upstreamStatusError
+	if errors.As(err, &status) {
+		return int(status), true
+	}
+	return 0, false
+}
+
+// isCityNotFound reports whether body is the answer of OpenWeatherMap to an unknown location,
+// {"cod":"404","message":"city not found"}, rather than a 404 from a misconfigured host or path.
+func isCityNotFound(body []byte) bool {
+	var answer struct {
+		Cod     any    `json:"cod"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &answer); err != nil {
+		return false
+	}
+	return fmt.Sprint(answer.Cod) == "404" && strings.EqualFold(answer.Message, "city not found")
+}
+
+// cityNotFoundResponse builds the error body returned when the upstream does not know location.
+func cityNotFoundResponse(location string) gin.H {
+	return gin.H{"error": fmt.Sprintf("City %q not found, check its spelling or add the country code, e.g. London,GB", location)}
+}
+
+// failedFetchResponse builds the error body returned when fetching from upstream failed with err.
+//
+// With WeatherConfig.DebugErrors, the status the upstream answered with is added as upstream_status,
+// telling an invalid API key (401) from throttling (429) or an outage (5xx) without the server logs.
+func failedFetchResponse(message string, err error) gin.H {
+	response := gin.H{"error": message}
+
+	if status, ok := upstreamStatus(err); ok && config.DebugErrors {
+		response["upstream_status"] = status
+	}
+
+	return response
+}
+
+// Bounds of the upstream client timeout, see SetRequestTimeout and WeatherConfig.RequestTimeout.
+const (
+	minUpstreamTimeout = 50 * time.Millisecond
+	maxUpstreamTimeout = 10 * time.Second
+)
+
+// upstreamTimeout is the timeout of the upstream clients in nanoseconds, 0 until it is first adjusted
+// through SetRequestTimeout. It changes at runtime, hence an atomic read by every request.
+var upstreamTimeout atomic.Int64
+
+// upstreamClientTimeout returns the timeout the upstream clients use for their next requests,
+// WeatherConfig.RequestTimeout unless it was adjusted at runtime.
+func upstreamClientTimeout() time.Duration {
+	if timeout := upstreamTimeout.Load(); timeout > 0 {
+		return time.Duration(timeout)
+	}
+	return config.RequestTimeout
+}
+
+// SetRequestTimeout replaces the timeout of the future upstream requests, the ones in flight keep theirs.
+// It fails when timeout is outside of the minUpstreamTimeout to maxUpstreamTimeout range.
+func SetRequestTimeout(timeout time.Duration) error {
+	if timeout < minUpstreamTimeout || timeout > maxUpstreamTimeout {
+		return fmt.Errorf("timeout must be between %s and %s", minUpstreamTimeout, maxUpstreamTimeout)
+	}
+
+	upstreamTimeout.Store(int64(timeout))
+	return nil
+}
+
+// upstreamTransport is the transport of upstreamClient, a clone of http.DefaultTransport so the
+// connection limits set by limitUpstreamConnections and poolUpstreamConnections leave every other
+// HTTP client of the process alone.
+var upstreamTransport = http.DefaultTransport.(*http.Transport).Clone()
+
+// upstreamClient sends every upstream request. A single client is shared by every handler so the
+// connections to the upstream are pooled and kept alive between requests, through upstreamTransport,
+// see poolUpstreamConnections.
+//
+// It has no timeout of its own, since the timeout changes at runtime and callers may replace it
+// with a deadline of their own: upstreamGet bounds each request instead.
+var upstreamClient = &http.Client{Transport: upstreamTransport}
+
+// cancelOnClose releases the context of an upstream request once its body is closed, so the
+// timeout of upstreamGet also covers reading the body, as http.Client.Timeout does.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// upstreamGet sends a GET request for rawURL with upstreamClient, bounded by ctx.
+//
+// The request times out after upstreamClientTimeout, unless ctx carries a deadline, which then
+// replaces the default so callers can allow a slow location more time. As with a client timeout,
+// a request timing out fails with an error reporting Timeout() true. The API key is redacted from
+// the URL quoted by the errors, as they end up in the logs.
+func upstreamGet(ctx context.Context, rawURL string) (*http.Response, error) {
+	cancel := context.CancelFunc(func() {})
+	if _, ok := ctx.Deadline(); !ok {
+		ctx, cancel = context.WithTimeout(ctx, upstreamClientTimeout())
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	resp, err := upstreamClient.Do(request)
+	if err != nil {
+		cancel()
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = redactApiKey(urlErr.URL)
+		}
+		return nil, err
+	}
+
+	resp.Body = cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// redactApiKey replaces the appid query parameter of rawURL, the API key, with REDACTED.
+func redactApiKey(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	query := parsed.Query()
+	if !query.Has("appid") {
+		return rawURL
+	}
+	query.Set("appid", "REDACTED")
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
+
+// upstreamSlots caps the number of requests in flight to the upstream API across every handler,
+// so a large batch or stress run cannot open an unbounded number of connections at once.
+var upstreamSlots = make(chan struct{}, defaultConfig().MaxUpstreamRequests)
+
+// acquireUpstream blocks until an upstream slot is free and returns the function releasing it, or
+// fails with ctx.Err() if ctx is done first. Every upstream call goes through here, so it is also where upstream calls are counted and where
+// the wait for a slot is recorded in weather_upstream_slot_wait_seconds: waits well above zero
+// mean WeatherConfig.MaxUpstreamRequests, rather than the upstream, is the bottleneck.
+//
+// The upstream requests never set Accept-Encoding themselves: upstreamTransport then asks for
+// gzip on its own and transparently decompresses the body, which setting the header by hand turns off.
+func acquireUpstream(ctx context.Context) (func(), error) {
+	stats.upstreamCalls.Add(1)
+
+	start := time.Now()
+	select {
+	case upstreamSlots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	upstreamSlotWait.Record(context.Background(), time.Since(start).Seconds())
+
+	return func() { <-upstreamSlots }, nil
+}
+
+// limitUpstreamConnections caps the number of connections open to the upstream host at maxConns, 0 lifting
+// the cap. Requests beyond it wait in the transport until a connection frees up, throttling bursts such as
+// the stress endpoints at the connection level on top of the upstream slots.
+//
+// The cap is set on upstreamTransport, which only ever connects to the upstream.
+func limitUpstreamConnections(maxConns int) {
+	upstreamTransport.MaxConnsPerHost = maxConns
+}
+
+// poolUpstreamConnections grows the idle pool of upstreamTransport to keep at least idle
+// connections per host, the default of two forcing most requests of a concurrent stress or batch
+// run to open a new connection.
+func poolUpstreamConnections(idle int) {
+	if upstreamTransport.MaxIdleConnsPerHost < idle {
+		upstreamTransport.MaxIdleConnsPerHost = idle
+	}
+}
+
+// warmupTimeout bounds each warm-up request, so an unreachable upstream cannot hold the startup back.
+const warmupTimeout = 2 * time.Second
+
+/*
+warmUpstreamConnections opens count connections to the upstream host ahead of the first request,
+so the first stress or batch requests do not all pay for a TCP (and TLS) handshake at once.
+
+The upstream requests share upstreamTransport, whose idle pool keeps only two connections
+per host by default. The pool is grown to count so the warmed connections are kept for reuse.
+
+Each connection is opened by a concurrent HEAD request to the host root, its status is ignored
+since only the connection matters. It returns the number of requests that failed to connect.
+*/
+func warmUpstreamConnections(count int) int {
+	poolUpstreamConnections(count)
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	failed := 0
+
+	for range count {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if err := warmUpstreamConnection(); err != nil {
+				logger.Error("Failed to warm an upstream connection", "error", err)
+				mutex.Lock()
+				failed++
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return failed
+}
+
+// warmUpstreamConnection makes a single HEAD request to the upstream host and hands its connection back to the pool.
+func warmUpstreamConnection() error {
+	ctx, cancel := context.WithTimeout(context.Background(), warmupTimeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodHead, weatherApiHost+"/", nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := upstreamClient.Do(request)
+	if err != nil {
+		return err
+	}
+
+	// Drain the body so the connection goes back to the idle pool
+	io.Copy(io.Discard, resp.Body)
+	return resp.Body.Close()
+}
+
+// maxUpstreamBodyBytes bounds the upstream bodies read into memory. The largest the upstream
+// sends, One Call responses with the hourly forecast, stay well below it.
+const maxUpstreamBodyBytes = 1 << 20
+
+// readUpstreamBody reads an upstream body of at most maxUpstreamBodyBytes, and fails with
+// ErrUpstreamResponse past it rather than buffering whatever a misbehaving upstream sends.
+func readUpstreamBody(body io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(body, maxUpstreamBodyBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxUpstreamBodyBytes {
+		return nil, fmt.Errorf("%w: body larger than %d bytes", ErrUpstreamResponse, maxUpstreamBodyBytes)
+	}
+	return data, nil
+}
+
+// checkNotEmpty rejects an upstream body holding nothing but whitespace, which the decoder would
+// otherwise report as a confusing unexpected end of JSON input.
+//
+// Return: nil for a body with content, or an error wrapping ErrEmptyResponse
+func checkNotEmpty(body []byte) error {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return fmt.Errorf("%w: %d bytes", ErrEmptyResponse, len(body))
+	}
+	return nil
+}
+
+// checkContentType rejects an upstream body whose Content-Type is not JSON before it is decoded,
+// quoting the start of the body so an outage page can be diagnosed from the error alone.
+// A missing Content-Type is let through to the decoder.
+//
+// Parameters:
+// contentType (string): The Content-Type header of the upstream response.
+// body ([]byte): The upstream response body.
+//
+// Return: nil for JSON, or an error wrapping ErrUpstreamResponse
+func checkContentType(contentType string, body []byte) error {
+	if contentType == "" {
+		return nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")) {
+		return nil
+	}
+
+	return fmt.Errorf("%w: content type %q instead of JSON, body starts with %q",
+		ErrUpstreamResponse, contentType, body[:min(len(body), 64)])
+}
+
+// errorClass names the kind of an upstream failure for traces and metrics: empty_response,
+// upstream_error, decode_error or error.
+func errorClass(err error) string {
+	switch {
+	case errors.Is(err, ErrEmptyResponse):
+		return "empty_response"
+	case errors.Is(err, ErrUpstreamResponse):
+		return "upstream_error"
+	case errors.Is(err, ErrDecode):
+		return "decode_error"
+	default:
+		return "error"
+	}
+}
+
+// recordUpstreamError records the failure err of the upstream request made by endpoint on span, and
+// counts it in weather_upstream_errors_total by endpoint and error_type, see errorClass.
+func recordUpstreamError(ctx context.Context, span trace.Span, endpoint string, err error) {
+	class := errorClass(err)
+
+	span.RecordError(err)
+	span.SetAttributes(attribute.String("error.type", class))
+	upstreamErrorCounter.Add(ctx, 1, metric.WithAttributes(
+		attribute.Key("endpoint").String(endpoint),
+		attribute.Key("error_type").String(class),
+	))
+}
@@ -0,0 +1,585 @@
+package weather
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestWarmUpstreamConnections(t *testing.T) {
+	transport := upstreamTransport
+	previousIdle := transport.MaxIdleConnsPerHost
+	previousHost := weatherApiHost
+
+	var mutex sync.Mutex
+	opened := 0
+
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	upstream.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			mutex.Lock()
+			opened++
+			mutex.Unlock()
+		}
+	}
+	upstream.Start()
+	weatherApiHost = upstream.URL
+
+	t.Cleanup(func() {
+		weatherApiHost = previousHost
+		upstream.Close()
+		transport.CloseIdleConnections()
+		transport.MaxIdleConnsPerHost = previousIdle
+	})
+
+	if failed := warmUpstreamConnections(4); failed != 0 {
+		t.Fatalf("Expected every warm-up request to succeed, %d failed", failed)
+	}
+
+	// Requests made after the warm-up reuse the idle connections instead of opening new ones
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if resp, err := upstreamClient.Get(upstream.URL); err == nil {
+				resp.Body.Close()
+			}
+		}()
+	}
+	wg.Wait()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if opened != 4 {
+		t.Errorf("Expected the 4 warmed connections to be reused, %d connections were opened", opened)
+	}
+}
+
+// TestLimitUpstreamConnections runs a batch of many cities with a connection cap of 3 and checks that
+// no more than 3 connections to the upstream are ever open at once.
+func TestLimitUpstreamConnections(t *testing.T) {
+	transport := upstreamTransport
+	previousMax := transport.MaxConnsPerHost
+	previousHost := weatherApiHost
+
+	var mutex sync.Mutex
+	open, peak := 0, 0
+
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(5 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"name": %q, "main": {"temp": 290}}`, r.URL.Query().Get("q"))
+	}))
+	upstream.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		switch state {
+		case http.StateNew:
+			open++
+			peak = max(peak, open)
+		case http.StateClosed, http.StateHijacked:
+			open--
+		}
+	}
+	upstream.Start()
+	weatherApiHost = upstream.URL
+
+	t.Cleanup(func() {
+		weatherApiHost = previousHost
+		upstream.Close()
+		transport.CloseIdleConnections()
+		transport.MaxConnsPerHost = previousMax
+	})
+
+	limitUpstreamConnections(3)
+	if defaultMax := http.DefaultTransport.(*http.Transport).MaxConnsPerHost; defaultMax == 3 {
+		t.Error("Expected the cap to leave http.DefaultTransport alone")
+	}
+
+	cities := make([]string, 30)
+	for i := range cities {
+		cities[i] = fmt.Sprintf("City%d", i)
+	}
+
+	// The client timeout also covers waiting for a connection, leave the batch plenty of time
+	timeouts := make(map[string]time.Duration, len(cities))
+	for _, city := range cities {
+		timeouts[city] = 5 * time.Second
+	}
+
+	for _, result := range fetchBatchChannel(context.Background(), cities, defaultUnits, timeouts) {
+		if result.err != nil {
+			t.Fatalf("Expected every city to be fetched, %s failed: %v", result.location, result.err)
+		}
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if peak > 3 {
+		t.Errorf("Expected at most 3 upstream connections at once, got %d", peak)
+	}
+}
+
+func TestFailedFetchResponseUpstreamStatus(t *testing.T) {
+	previous := config
+	t.Cleanup(func() { config = previous })
+
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+
+	request := func() map[string]any {
+		w := httptest.NewRecorder()
+		ctx, _ := gin.CreateTestContext(w)
+		ctx.Params = []gin.Param{{Key: "location", Value: "Tokyo"}}
+		ctx.Request, _ = http.NewRequest(http.MethodGet, "/weather/Tokyo", nil)
+
+		getWeatherInternational(ctx)
+
+		if w.Code != http.StatusInternalServerError {
+			t.Fatalf("Expected status code 500, got %v", w.Code)
+		}
+
+		var data map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+			t.Fatalf("Error unmarshalling JSON response: %v", err)
+		}
+		return data
+	}
+
+	if data := request(); data["upstream_status"] != nil {
+		t.Errorf("Expected no upstream status outside debug mode, got %v", data)
+	}
+
+	config.DebugErrors = true
+	if data := request(); data["upstream_status"] != float64(http.StatusTooManyRequests) {
+		t.Errorf("Expected the upstream status 429 in debug mode, got %v", data)
+	}
+}
+
+func TestFailedFetchResponseWithoutUpstreamAnswer(t *testing.T) {
+	previous := config
+	t.Cleanup(func() { config = previous })
+	config.DebugErrors = true
+
+	response := failedFetchResponse("Failed to fetch weather data", errors.New("connection refused"))
+	if _, ok := response["upstream_status"]; ok {
+		t.Errorf("Expected no upstream status when the upstream never answered, got %v", response)
+	}
+}
+
+func TestSendWeatherRequestGzipResponse(t *testing.T) {
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			t.Errorf("Expected the upstream request to accept gzip, got Accept-Encoding %q", r.Header.Get("Accept-Encoding"))
+			json.NewEncoder(w).Encode(sampleWeatherData("Tokyo"))
+			return
+		}
+
+		w.Header().Set("Content-Encoding", "gzip")
+		compressed := gzip.NewWriter(w)
+		json.NewEncoder(compressed).Encode(sampleWeatherData("Tokyo"))
+		compressed.Close()
+	})
+
+	data, err := sendWeatherRequest("Tokyo", unitsStandard)
+	if err != nil {
+		t.Fatalf("Expected the gzipped response to decode, got %v", err)
+	}
+
+	if expected := sampleWeatherData("Tokyo"); data.Name != expected.Name || !reflect.DeepEqual(data.Main, expected.Main) {
+		t.Errorf("Expected %+v, got %+v", expected, data)
+	}
+}
+
+func TestSendWeatherRequestContextCancelled(t *testing.T) {
+	requested := make(chan struct{})
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		close(requested)
+		<-r.Context().Done()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-requested
+		cancel()
+	}()
+
+	start := time.Now()
+	_, err := sendWeatherRequestContext(ctx, "Tokyo", unitsStandard)
+
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the error to wrap context.Canceled, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "cancelled") {
+		t.Errorf("Expected the error to say the request was cancelled, got %q", err)
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("Expected the request to be abandoned on cancel, took %s", elapsed)
+	}
+}
+
+func TestSendWeatherRequestKeepsApiKeyOutOfLogs(t *testing.T) {
+	previousLogger := logger
+	t.Cleanup(func() { logger = previousLogger })
+
+	var output bytes.Buffer
+	logger = slog.New(slog.NewTextHandler(&output, nil))
+	t.Setenv("OPENWEATHER_API_KEY", "secret-test-key")
+
+	upstream := startMockUpstream(t, serveCannedWeather)
+	if _, err := sendWeatherRequest("Tokyo", unitsStandard); err != nil {
+		t.Fatalf("Error fetching weather: %v", err)
+	}
+
+	upstream.Close()
+	_, err := sendWeatherRequest("Tokyo", unitsStandard)
+	if err == nil {
+		t.Fatal("Expected the request to a closed upstream to fail")
+	}
+
+	if logs := output.String() + err.Error(); strings.Contains(logs, "secret-test-key") {
+		t.Errorf("Expected the API key to be redacted, got %s", logs)
+	}
+	if !strings.Contains(output.String(), "status=200") || strings.Contains(output.String(), "StatusCode") {
+		t.Errorf("Expected the response to be logged as its status code, got %s", output.String())
+	}
+}
+
+func TestAcquireUpstreamCancelled(t *testing.T) {
+	previous := upstreamSlots
+	upstreamSlots = make(chan struct{}, 1)
+	t.Cleanup(func() { upstreamSlots = previous })
+
+	release, err := acquireUpstream(context.Background())
+	if err != nil {
+		t.Fatalf("Expected a free slot, got %v", err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if _, err := acquireUpstream(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected to give up waiting for a slot with the context, got %v", err)
+	}
+}
+
+func TestWeatherInternationalClientDisconnect(t *testing.T) {
+	withCache(t, 0, false)
+	config.DedupWindow = 0
+
+	requested := make(chan struct{})
+	cancelled := make(chan struct{})
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		close(requested)
+		<-r.Context().Done()
+		close(cancelled)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-requested
+		cancel()
+	}()
+
+	router := gin.New()
+	router.GET("/weather/:location", getWeatherInternational)
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/weather/Tokyo", nil).WithContext(ctx))
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the upstream request to be cancelled when the client goes away")
+	}
+}
+
+// TestCoordinatesAndForecastClientDisconnect checks that the coordinates, forecast and One Call
+// fetches are abandoned too when the client goes away.
+func TestCoordinatesAndForecastClientDisconnect(t *testing.T) {
+	withCache(t, 0, false)
+	config.DedupWindow = 0
+
+	at := time.Now().Add(3 * time.Hour).UTC().Format(time.RFC3339)
+
+	for _, tt := range []struct {
+		route   string
+		handler gin.HandlerFunc
+		target  string
+	}{
+		{"/weather/query", getWeatherQuery, "/weather/query?lat=35.68&lon=139.69"},
+		{"/weather/:location", getWeatherInternational, "/weather/Tokyo?at=" + at},
+		{"/forecast/:location", getForecast, "/forecast/Tokyo"},
+		{"/alerts/coords", getWeatherAlerts, "/alerts/coords?lat=35.68&lon=139.69"},
+		{"/uv/coords", getUVIndex, "/uv/coords?lat=35.68&lon=139.69"},
+	} {
+		t.Run(tt.target, func(t *testing.T) {
+			var once sync.Once
+			requested := make(chan struct{})
+			cancelled := make(chan struct{}, 1)
+			startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+				once.Do(func() { close(requested) })
+				<-r.Context().Done()
+				cancelled <- struct{}{}
+			})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			go func() {
+				<-requested
+				cancel()
+			}()
+
+			router := gin.New()
+			router.GET(tt.route, tt.handler)
+			router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tt.target, nil).WithContext(ctx))
+
+			select {
+			case <-cancelled:
+			case <-time.After(time.Second):
+				t.Fatal("Expected the upstream request to be cancelled when the client goes away")
+			}
+		})
+	}
+}
+
+func TestSendWeatherRequestCityNotFound(t *testing.T) {
+	var body atomic.Value
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, body.Load())
+	})
+
+	body.Store(`{"cod":"404","message":"city not found"}`)
+	_, err := sendWeatherRequest("Atlantis", unitsStandard)
+	if !errors.Is(err, ErrCityNotFound) {
+		t.Fatalf("Expected the error to wrap ErrCityNotFound, got %v", err)
+	}
+	if status, ok := upstreamStatus(err); !ok || status != http.StatusNotFound {
+		t.Errorf("Expected the upstream status 404 to be kept, got %d", status)
+	}
+
+	// A 404 from a wrong host or path is a misconfiguration, not an unknown city
+	body.Store(`<html><body>Not Found</body></html>`)
+	if _, err := sendWeatherRequest("Atlantis", unitsStandard); err == nil || errors.Is(err, ErrCityNotFound) {
+		t.Errorf("Expected a plain upstream error for a 404 without the city not found answer, got %v", err)
+	}
+}
+
+func TestWeatherCityNotFound(t *testing.T) {
+	withCache(t, 0, false)
+	withBreakers(t, 1, time.Minute)
+	config.DedupWindow = 0
+
+	var calls atomic.Int32
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"cod":"404","message":"city not found"}`)
+	})
+
+	router := gin.New()
+	router.GET("/weather/:location", getWeatherInternational)
+	router.GET("/summary/:location", getWeatherSummary)
+
+	for _, target := range []string{"/weather/Atlantis", "/weather/Atlantis", "/summary/Atlantis"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+
+		if w.Code != http.StatusNotFound {
+			t.Fatalf("%s: expected status code %d, got %d: %s", target, http.StatusNotFound, w.Code, w.Body.String())
+		}
+		if !strings.Contains(w.Body.String(), "Atlantis") {
+			t.Errorf("%s: expected the error to name the city, got %s", target, w.Body.String())
+		}
+	}
+
+	// Unknown cities must not open the circuit, every request reached the upstream
+	if got := calls.Load(); got != 3 {
+		t.Errorf("Expected 3 upstream calls, got %d", got)
+	}
+}
+
+// TestUpstreamSlotWaitUnderContention checks that the wait for an upstream slot is recorded,
+// and is nonzero once there are more requests than slots.
+func TestUpstreamSlotWaitUnderContention(t *testing.T) {
+	reader := withMetricReader(t)
+
+	previous := upstreamSlots
+	upstreamSlots = make(chan struct{}, 1)
+	t.Cleanup(func() { upstreamSlots = previous })
+
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		serveCannedWeather(w, r)
+	})
+
+	var wg sync.WaitGroup
+	for _, city := range []string{"Tokyo", "Paris", "Lima"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := sendWeatherRequest(city, unitsStandard); err != nil {
+				t.Errorf("Error fetching weather for %s: %v", city, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var collected metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &collected); err != nil {
+		t.Fatalf("Error collecting metrics: %v", err)
+	}
+
+	for _, scope := range collected.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			if m.Name != "weather_upstream_slot_wait_seconds" {
+				continue
+			}
+
+			point := m.Data.(metricdata.Histogram[float64]).DataPoints[0]
+			if point.Count != 3 {
+				t.Errorf("Expected 3 waits recorded, got %d", point.Count)
+			}
+			if longest, ok := point.Max.Value(); !ok || longest < 0.01 {
+				t.Errorf("Expected a request to wait for the busy slot, longest wait %vs", longest)
+			}
+			return
+		}
+	}
+
+	t.Error("Expected weather_upstream_slot_wait_seconds to be recorded")
+}
+
+func TestSetRequestTimeout(t *testing.T) {
+	t.Cleanup(func() { upstreamTimeout.Store(0) })
+
+	startMockUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(150 * time.Millisecond)
+		serveCannedWeather(w, r)
+	})
+
+	if err := SetRequestTimeout(time.Millisecond); err == nil {
+		t.Error("Expected a timeout below the minimum to be rejected")
+	}
+	if err := SetRequestTimeout(minUpstreamTimeout); err != nil {
+		t.Fatalf("Error setting the timeout: %v", err)
+	}
+
+	start := time.Now()
+	_, err := sendWeatherRequest("Tokyo", unitsStandard)
+
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("Expected a timeout error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= 150*time.Millisecond {
+		t.Errorf("Expected the request to give up after %s, took %s", minUpstreamTimeout, elapsed)
+	}
+}
+
+func TestLoadConfigRequestTimeout(t *testing.T) {
+	t.Setenv("WEATHER_REQUEST_TIMEOUT", "")
+	cfg, err := loadConfig()
+	if err != nil || cfg.RequestTimeout != 5*time.Second {
+		t.Errorf("Expected a 5s timeout by default, got %s (%v)", cfg.RequestTimeout, err)
+	}
+
+	t.Setenv("WEATHER_REQUEST_TIMEOUT", "800ms")
+	cfg, err = loadConfig()
+	if err != nil || cfg.RequestTimeout != 800*time.Millisecond {
+		t.Errorf("Expected an 800ms timeout, got %s (%v)", cfg.RequestTimeout, err)
+	}
+
+	t.Setenv("WEATHER_REQUEST_TIMEOUT", "1m")
+	if _, err = loadConfig(); err == nil {
+		t.Error("Expected an error for a timeout above the maximum")
+	}
+}
+
+// BenchmarkUpstreamClient compares the former per-request client, on the default idle pool of two
+// connections per host, with the shared upstreamClient on a pool grown by poolUpstreamConnections.
+// Each operation is a burst of concurrent requests, as a stress endpoint makes, and the connections
+// opened per burst are reported: a small pool closes most of them once the burst is over.
+func BenchmarkUpstreamClient(b *testing.B) {
+	const burst = 20
+
+	var opened atomic.Int64
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Millisecond)
+		serveCannedWeather(w, r)
+	}))
+	upstream.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			opened.Add(1)
+		}
+	}
+	upstream.Start()
+	b.Cleanup(upstream.Close)
+
+	transport := upstreamTransport
+	previousIdle := transport.MaxIdleConnsPerHost
+	b.Cleanup(func() {
+		transport.CloseIdleConnections()
+		transport.MaxIdleConnsPerHost = previousIdle
+	})
+
+	for _, tc := range []struct {
+		name string
+		get  func() (*http.Response, error)
+		idle int
+	}{
+		{"per_call", func() (*http.Response, error) {
+			client := http.Client{Transport: transport, Timeout: upstreamClientTimeout()}
+			return client.Get(upstream.URL)
+		}, previousIdle},
+		{"shared", func() (*http.Response, error) {
+			return upstreamGet(context.Background(), upstream.URL)
+		}, burst},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			transport.CloseIdleConnections()
+			transport.MaxIdleConnsPerHost = previousIdle
+			poolUpstreamConnections(tc.idle)
+			opened.Store(0)
+
+			for b.Loop() {
+				var wg sync.WaitGroup
+				for range burst {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						resp, err := tc.get()
+						if err != nil {
+							b.Error(err)
+							return
+						}
+						io.Copy(io.Discard, resp.Body)
+						resp.Body.Close()
+					}()
+				}
+				wg.Wait()
+			}
+
+			b.ReportMetric(float64(opened.Load())/float64(b.N), "conns/op")
+		})
+	}
+}